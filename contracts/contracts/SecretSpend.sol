@@ -2,28 +2,97 @@
 pragma solidity ^0.8.0;
 
 import {Verifier} from "./Verifier.sol";
+import {DepositVerifier} from "./DepositVerifier.sol";
+import {WithdrawVerifier} from "./WithdrawVerifier.sol";
+import {IERC20} from "./IERC20.sol";
 
+// The public inputs of the transfer circuit: the chain ID, the contract
+// address, the old and new balances roots and the hash of the transfer leaves.
 struct ZkProof {
     uint256[8] proof;
-    uint256[14] input;
+    uint256[5] input;
+}
+
+// The public inputs of the deposit circuit: the chain ID, the contract
+// address, the old and new balances roots, the amount and the hash of the old
+// and new leaves.
+struct DepositProof {
+    uint256[8] proof;
+    uint256[6] input;
+}
+
+// The public inputs of the withdraw circuit: the chain ID, the contract
+// address, the old and new balances roots, the amount, the recipient address
+// and the hash of the old and new leaves.
+struct WithdrawProof {
+    uint256[8] proof;
+    uint256[7] input;
 }
 
 contract SecretSpend {
+    // The circuits only hold balances of 64 bits. Capping the sum of the
+    // balances keeps transfers from pushing any of them beyond it.
+    uint256 public constant MAX_SUPPLY = type(uint64).max;
+
     Verifier internal verifier;
+    DepositVerifier internal depositVerifier;
+    WithdrawVerifier internal withdrawVerifier;
+    IERC20 public token;
     bytes32 public balancesRoot;
+    uint256 public totalSupply;
 
-    constructor(address _verifier) {
+    constructor(
+        address _verifier,
+        address _depositVerifier,
+        address _withdrawVerifier,
+        address _token
+    ) {
         verifier = Verifier(_verifier);
+        depositVerifier = DepositVerifier(_depositVerifier);
+        withdrawVerifier = WithdrawVerifier(_withdrawVerifier);
+        token = IERC20(_token);
     }
 
-    function setBalancesRootForDemo(bytes32 _balancesRoot) external {
+    // The root is set along with the sum of its balances.
+    function setBalancesRootForDemo(bytes32 _balancesRoot, uint256 _totalSupply) external {
+        require(_totalSupply <= MAX_SUPPLY, "supply cap exceeded");
         balancesRoot = _balancesRoot;
+        totalSupply = _totalSupply;
     }
 
     function transferPrivately(ZkProof calldata proof) external {
         verifier.verifyProof(proof.proof, proof.input);
+        updateRoot(proof.input[0], proof.input[1], proof.input[2], proof.input[3]);
+    }
+
+    // The amount is pulled from the caller, who must have approved it, and
+    // credited to the leaf of the proof.
+    function deposit(DepositProof calldata proof) external {
+        depositVerifier.verifyProof(proof.proof, proof.input);
+        updateRoot(proof.input[0], proof.input[1], proof.input[2], proof.input[3]);
+        totalSupply += proof.input[4];
+        require(totalSupply <= MAX_SUPPLY, "supply cap exceeded");
+
+        require(token.transferFrom(msg.sender, address(this), proof.input[4]), "deposit failed");
+    }
+
+    // The amount was taken out of the leaf of the proof, and is paid to the
+    // recipient its owner signed for.
+    function withdraw(WithdrawProof calldata proof) external {
+        withdrawVerifier.verifyProof(proof.proof, proof.input);
+        updateRoot(proof.input[0], proof.input[1], proof.input[2], proof.input[3]);
+        totalSupply -= proof.input[4];
+
+        require(token.transfer(address(uint160(proof.input[5])), proof.input[4]), "withdrawal failed");
+    }
+
+    // updateRoot moves the balances root from oldRoot to newRoot, once the
+    // proof is checked to be bound to this chain and this contract.
+    function updateRoot(uint256 chainId, uint256 contractAddress, uint256 oldRoot, uint256 newRoot) internal {
+        require(chainId == block.chainid, "wrong chain");
+        require(contractAddress == uint256(uint160(address(this))), "wrong contract");
 
-        assert(balancesRoot == bytes32(proof.input[0]));
-        balancesRoot = bytes32(proof.input[1]);
+        require(balancesRoot == bytes32(oldRoot), "stale root");
+        balancesRoot = bytes32(newRoot);
     }
 }
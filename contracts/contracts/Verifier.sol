@@ -53,58 +53,40 @@ contract Verifier {
     uint256 constant EXP_SQRT_FP = 0xC19139CB84C680A6E14116DA060561765E05AA45A1C72A34F082305B61F3F52; // (P + 1) / 4;
 
     // Groth16 alpha point in G1
-    uint256 constant ALPHA_X = 4502068018998719139384458221606451869457385029778425995481277578264753610809;
-    uint256 constant ALPHA_Y = 4142899523995430732397252244150361842227065921437237112206931296244586609302;
+    uint256 constant ALPHA_X = 20328410697113392528887259587802832118609681660983431767524824249481414467479;
+    uint256 constant ALPHA_Y = 9195724012886743959582959877523378841921029299891739962478336011057649663584;
 
     // Groth16 beta point in G2 in powers of i
-    uint256 constant BETA_NEG_X_0 = 1861742494261828922648875710315806612684679110571011432289233419846118261304;
-    uint256 constant BETA_NEG_X_1 = 8064422751977619133192259929418657376671805423456430650374821003618811716101;
-    uint256 constant BETA_NEG_Y_0 = 20739149966780845524992036650975895458552721045774428939093387921771831408308;
-    uint256 constant BETA_NEG_Y_1 = 20442053319942475010314190096147980199218894426462679473092111930968987739721;
+    uint256 constant BETA_NEG_X_0 = 19595155350161903584871952230059003399230004510477600329369834781319570847052;
+    uint256 constant BETA_NEG_X_1 = 8872071366821287861985521099483909218873324852818944279425438412595561756708;
+    uint256 constant BETA_NEG_Y_0 = 5620991248898187294570458451940623912771744919515822487922306024734802096157;
+    uint256 constant BETA_NEG_Y_1 = 561692592903064377020331531918885510947743794529122737160755414644788262317;
 
     // Groth16 gamma point in G2 in powers of i
-    uint256 constant GAMMA_NEG_X_0 = 21196924320345212997860323799207442275402326889863088624655895569941388823567;
-    uint256 constant GAMMA_NEG_X_1 = 12079160877431577034037179869009283664467515315302283526966645429444702792397;
-    uint256 constant GAMMA_NEG_Y_0 = 9950860310372972177968423334821496665929446060262079314271390951284537964139;
-    uint256 constant GAMMA_NEG_Y_1 = 4168663251190253998752429180419046230684858108787629240758326040944267266002;
+    uint256 constant GAMMA_NEG_X_0 = 21347916985974518273588586834068979085260593267233730988096410150214609161092;
+    uint256 constant GAMMA_NEG_X_1 = 18377984225370794241066935501101603450294080297453278144316089413144692770360;
+    uint256 constant GAMMA_NEG_Y_0 = 12136209613769058137615507361394519249263996004089797353428028300096494632733;
+    uint256 constant GAMMA_NEG_Y_1 = 3339740082463294011297824380934840765911102614220259827254754487508928743710;
 
     // Groth16 delta point in G2 in powers of i
-    uint256 constant DELTA_NEG_X_0 = 6932700621988966813622159440930746002115377387148643132775719427864950009894;
-    uint256 constant DELTA_NEG_X_1 = 2638203703369086154448910143955467787382713390363820606152121209064311720092;
-    uint256 constant DELTA_NEG_Y_0 = 7132536716969563902668906844737286645792274398160994747592454800874035388195;
-    uint256 constant DELTA_NEG_Y_1 = 20031853879029524083128363854429922865064453144870178580482155633569474542062;
+    uint256 constant DELTA_NEG_X_0 = 8272380186740465764293212027433736433302563704490215278343280601744797052689;
+    uint256 constant DELTA_NEG_X_1 = 20238966880390404123771168364770629482052038454039940995987575788486545557709;
+    uint256 constant DELTA_NEG_Y_0 = 5773432871509114782410405433679822840467381119732277388247255712879513042878;
+    uint256 constant DELTA_NEG_Y_1 = 20686420287248848236342005813340158270975272002435053469302706394804191359769;
 
     // Constant and public input points
-    uint256 constant CONSTANT_X = 5861849303540577577761914074259948461203338801172962743585623631768088363115;
-    uint256 constant CONSTANT_Y = 10010398444333780443699913211425593128942057368000922236095557907530024394049;
-    uint256 constant PUB_0_X = 17870399800449571607403710478559761355649973386175032969522834626615589559586;
-    uint256 constant PUB_0_Y = 12066116788485818175199034752138812791727108779154897679982981984787013516163;
-    uint256 constant PUB_1_X = 16290197503710266207931913183161141004274299559421329418444378539780217176869;
-    uint256 constant PUB_1_Y = 12680480228397784433329818216119563774094919955970412621113394118510249910533;
-    uint256 constant PUB_2_X = 19518748681021861785200092287861049934687214089084513058146218485181147038427;
-    uint256 constant PUB_2_Y = 1776167622511063492571979711071041859346126997343569163023462494796823906332;
-    uint256 constant PUB_3_X = 5468227792980747297596817147640827459281068088208857628152674469242784794200;
-    uint256 constant PUB_3_Y = 564166248379805339031083764727871511531006657204605490863726818907732223999;
-    uint256 constant PUB_4_X = 1431932118475557030674769364292945933318549858755083731039894003055571701362;
-    uint256 constant PUB_4_Y = 6011088932426881620412289279613264304970028318307796719271922324554997422788;
-    uint256 constant PUB_5_X = 16247398766562413679389260100349186954691792773389970391031390446861289498768;
-    uint256 constant PUB_5_Y = 8104029853308774318879855699957098302085350900692449548944753236280812690856;
-    uint256 constant PUB_6_X = 1925117509196904047471780894804816102798053280083669957862590079158910513206;
-    uint256 constant PUB_6_Y = 14106573466430464658492192625749789140689523754309182763739074086516801828465;
-    uint256 constant PUB_7_X = 5906300603340071443018357617399481475949126940325077447402315637366602654678;
-    uint256 constant PUB_7_Y = 3960703941314393961272555055163432698420236274775584471202144191231143364251;
-    uint256 constant PUB_8_X = 19530712910781704693873608130506948275462849275182882094225294625054837797588;
-    uint256 constant PUB_8_Y = 2784114668367693659386441988226362049700718472551953944755079126839044752427;
-    uint256 constant PUB_9_X = 435647452983185778816279890722684846911395566762227150587210846685820678115;
-    uint256 constant PUB_9_Y = 21736373732124602092051370628128588113488113679228415577416601137699381379949;
-    uint256 constant PUB_10_X = 15962154342553689419892573768370794077121980733261922266127787928426497392477;
-    uint256 constant PUB_10_Y = 19618284416561176550668157295068333874279567687277427506814959981120947945234;
-    uint256 constant PUB_11_X = 19917847802527681246462974812861172340914201846413748078049353493830846105876;
-    uint256 constant PUB_11_Y = 9478194498846066835428784809907702850063831728852179595048895514583178707508;
-    uint256 constant PUB_12_X = 5108468711647706432164980457489565879988111900922347691227470611996677910797;
-    uint256 constant PUB_12_Y = 19094018924051248759059929052716984464155759465687651070150879698251570093359;
-    uint256 constant PUB_13_X = 18947507290738241133443849109595085062201615737209861154088174590418569680742;
-    uint256 constant PUB_13_Y = 7731492834814809067886931332312901400030313349337610696703488719138047472074;
+    uint256 constant CONSTANT_X = 6614342351997481934962024729989085667586429152957596146920147003849236314111;
+    uint256 constant CONSTANT_Y = 6957736005192162294804771355581997841484350189011960151799310065983954835623;
+    uint256 constant PUB_0_X = 4784969270001712852470602352250212667880359178168212494067456506403493948569;
+    uint256 constant PUB_0_Y = 4975926179262464412532058251828154429370528689005128167369852021787383506108;
+    uint256 constant PUB_1_X = 11773169369521984386144064764987905478288511868080304848995549471872930959181;
+    uint256 constant PUB_1_Y = 8812012068287666275868415347462001688665351306198284012981456417211919982001;
+    uint256 constant PUB_2_X = 3904643891110178648609385516486217964530024841344492821072425463712844583452;
+    uint256 constant PUB_2_Y = 5093293973057475025960156456881538125027027373807304219116898792858444874259;
+    uint256 constant PUB_3_X = 11154593199754050439665432957340220709424971571519662495291383488244551266718;
+    uint256 constant PUB_3_Y = 21792161024536018002468997528879033276230643961844922161961344824575659932626;
+    uint256 constant PUB_4_X = 20934397866979902220256749686210373730115157673395824260488077615934075708605;
+    uint256 constant PUB_4_Y = 16376630360367202027601290661272343419614306811993405662919983910208415184040;
 
     /// Negation in Fp.
     /// @notice Returns a number x such that a + x = 0 in Fp.
@@ -381,7 +363,7 @@ contract Verifier {
     /// @param input The public inputs. These are elements of the scalar field Fr.
     /// @return x The X coordinate of the resulting G1 point.
     /// @return y The Y coordinate of the resulting G1 point.
-    function publicInputMSM(uint256[14] calldata input)
+    function publicInputMSM(uint256[5] calldata input)
     internal view returns (uint256 x, uint256 y) {
         // Note: The ECMUL precompile does not reject unreduced values, so we check this.
         // Note: Unrolling this loop does not cost much extra in code-size, the bulk of the
@@ -432,69 +414,6 @@ contract Verifier {
             success := and(success, lt(s, R))
             success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
             success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_5_X)
-            mstore(add(g, 0x20), PUB_5_Y)
-            s :=  calldataload(add(input, 160))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_6_X)
-            mstore(add(g, 0x20), PUB_6_Y)
-            s :=  calldataload(add(input, 192))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_7_X)
-            mstore(add(g, 0x20), PUB_7_Y)
-            s :=  calldataload(add(input, 224))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_8_X)
-            mstore(add(g, 0x20), PUB_8_Y)
-            s :=  calldataload(add(input, 256))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_9_X)
-            mstore(add(g, 0x20), PUB_9_Y)
-            s :=  calldataload(add(input, 288))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_10_X)
-            mstore(add(g, 0x20), PUB_10_Y)
-            s :=  calldataload(add(input, 320))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_11_X)
-            mstore(add(g, 0x20), PUB_11_Y)
-            s :=  calldataload(add(input, 352))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_12_X)
-            mstore(add(g, 0x20), PUB_12_Y)
-            s :=  calldataload(add(input, 384))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
-            mstore(g, PUB_13_X)
-            mstore(add(g, 0x20), PUB_13_Y)
-            s :=  calldataload(add(input, 416))
-            mstore(add(g, 0x40), s)
-            success := and(success, lt(s, R))
-            success := and(success, staticcall(gas(), PRECOMPILE_MUL, g, 0x60, g, 0x40))
-            success := and(success, staticcall(gas(), PRECOMPILE_ADD, f, 0x80, f, 0x40))
             x := mload(f)
             y := mload(add(f, 0x20))
         }
@@ -530,7 +449,7 @@ contract Verifier {
     /// Elements must be reduced.
     function verifyCompressedProof(
         uint256[4] calldata compressedProof,
-        uint256[14] calldata input
+        uint256[5] calldata input
     ) public view {
         (uint256 Ax, uint256 Ay) = decompress_g1(compressedProof[0]);
         (uint256 Bx0, uint256 Bx1, uint256 By0, uint256 By1) = decompress_g2(
@@ -595,7 +514,7 @@ contract Verifier {
     /// Elements must be reduced.
     function verifyProof(
         uint256[8] calldata proof,
-        uint256[14] calldata input
+        uint256[5] calldata input
     ) public view {
         (uint256 x, uint256 y) = publicInputMSM(input);
 
@@ -1,12 +1,23 @@
 import { ethers } from "hardhat";
 
 async function main() {
-    const verifier = await ethers.deployContract("Verifier");
-    await verifier.waitForDeployment();
-    console.log("Verifier deployed to:", verifier.target);
+    // The ERC-20 token deposited in and withdrawn from the private balances
+    const token = process.env.TOKEN_ADDRESS;
+    if (token === undefined) {
+        throw new Error("TOKEN_ADDRESS must be set");
+    }
+
+    const verifiers = [];
+    for (const name of ["Verifier", "DepositVerifier", "WithdrawVerifier"]) {
+        const verifier = await ethers.deployContract(name);
+        await verifier.waitForDeployment();
+        console.log(`${name} deployed to:`, verifier.target);
+        verifiers.push(verifier.target);
+    }
 
     const secretSpend = await ethers.deployContract("SecretSpend", [
-        verifier.target,
+        ...verifiers,
+        token,
     ]);
     await secretSpend.waitForDeployment();
     console.log("SecretSpend deployed to:", secretSpend.target);
@@ -2,15 +2,48 @@ import "./App.css";
 import contracts from "./contracts/SecretSpend.json";
 import { useEffect, useState } from "react";
 import { Contract, ethers, toBigInt } from "ethers";
+import { loadSecretSpend } from "./secretSpend";
 
 const contractAddress = "0x9AB81C32e1D621404b253c7fE0fC9972d1645E69";
 const contractABI = contracts.abi;
+const chainId = "534351";
+const serverURL = "http://localhost:8080";
+
+// The keys of an account in client custody, {index, p, q, spendingPrivKey},
+// with the keys returned by generateKeys of the WebAssembly prover. They never
+// leave the browser.
+const loadKeys = () => {
+    const keys = localStorage.getItem("secretSpendKeys");
+    return keys ? JSON.parse(keys) : null;
+};
+
+// fetchBalance decrypts the balance of the account in the browser, from the
+// encrypted balance the server holds.
+const fetchBalance = async (keys) => {
+    try {
+        const response = await fetch(`${serverURL}/get-user?index=${keys.index}`);
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        const user = await response.json();
+
+        const secretSpend = await loadSecretSpend();
+        const opening = await secretSpend.decryptBalance(keys, user.encBalance);
+        return opening.balance;
+    } catch (error) {
+        console.error("Error decrypting balance:", error);
+        return null;
+    }
+};
 
 function App() {
     const [currentAccount, setCurrentAccount] = useState(null);
     const [receiverID, setReceiverID] = useState("");
     const [amount, setAmount] = useState("");
     const [showToast, setShowToast] = useState("");
+    const [keys, setKeys] = useState(loadKeys);
+    const [keysInput, setKeysInput] = useState("");
+    const [balance, setBalance] = useState(null);
 
     const checkWalletIsConnected = () => {
         const { ethereum } = window;
@@ -56,31 +89,65 @@ function App() {
     //     }
     // };
 
-    const proveAndTransferHandler = async (e) => {
+    const saveKeysHandler = (e) => {
         e.preventDefault();
 
         try {
-            setShowToast({ message: "Generating Proof" });
+            const newKeys = JSON.parse(keysInput);
+            localStorage.setItem("secretSpendKeys", JSON.stringify(newKeys));
+            setKeys(newKeys);
+            setKeysInput("");
+        } catch (error) {
+            setShowToast({ message: "Invalid keys", type: "error" });
             setTimeout(() => setShowToast(""), 3000);
+        }
+    };
 
-            const fromIndex = 0;
-            const toIndex = receiverID;
-            const transferAmount = amount;
-            const url = `http://localhost:8080/transfer-funds?fromIndex=${fromIndex}&toIndex=${toIndex}&amount=${transferAmount}`;
+    // Accounts in client custody prove their transfers in the browser, and
+    // submit them to the server, which returns the proof once it applied
+    // them. Otherwise the server proves the transfers of the accounts it
+    // holds the keys of.
+    const proveTransfer = async (toIndex, transferAmount) => {
+        if (keys) {
+            const secretSpend = await loadSecretSpend();
+            return secretSpend.proveTransfer(
+                serverURL,
+                { chainId, contract: contractAddress },
+                keys,
+                Number(toIndex),
+                String(transferAmount)
+            );
+        }
 
-            const response = await fetch(url, {
-                method: "POST",
-                headers: {
-                    "Content-Type": "application/json",
-                },
-            });
+        const fromIndex = 0;
+        const url = `${serverURL}/transfer-funds?fromIndex=${fromIndex}&toIndex=${toIndex}&amount=${transferAmount}`;
 
-            if (!response.ok) {
-                throw new Error(`HTTP error! status: ${response.status}`);
-            }
+        const response = await fetch(url, {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json",
+            },
+        });
 
-            const data = await response.json();
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+
+        return response.json();
+    };
+
+    const proveAndTransferHandler = async (e) => {
+        e.preventDefault();
+
+        try {
+            setShowToast({ message: "Generating Proof" });
+            setTimeout(() => setShowToast(""), 3000);
+
+            const data = await proveTransfer(receiverID, amount);
             console.log("Response:", data);
+            if (keys) {
+                fetchBalance(keys).then(setBalance);
+            }
 
             setShowToast({ message: "Proof Generated", type: "success" });
             setTimeout(() => setShowToast(""), 3000);
@@ -196,6 +263,33 @@ function App() {
                 )}
                 <div className="row justify-content-center">
                     <div className="col-md-4">
+                        {keys ? (
+                            <p className="text-center text-secondary">
+                                Account {keys.index}, balance:{" "}
+                                {balance === null ? "..." : balance}
+                            </p>
+                        ) : (
+                            <form onSubmit={saveKeysHandler}>
+                                <div className="form-group">
+                                    <label>Account keys:</label>
+                                    <textarea
+                                        value={keysInput}
+                                        onChange={(e) =>
+                                            setKeysInput(e.target.value)
+                                        }
+                                        className="form-control"
+                                        placeholder='{"index": 1, "p": "...", "q": "...", "spendingPrivKey": "..."}'
+                                    />
+                                </div>
+                                <button
+                                    type="submit"
+                                    className="btn btn-secondary btn-block mb-4"
+                                    disabled={keysInput === ""}
+                                >
+                                    Prove Locally
+                                </button>
+                            </form>
+                        )}
                         <form onSubmit={proveAndTransferHandler}>
                             <div className="form-group">
                                 <label>ReceiverID:</label>
@@ -238,6 +332,12 @@ function App() {
         checkWalletIsConnected();
     }, []);
 
+    useEffect(() => {
+        if (keys) {
+            fetchBalance(keys).then(setBalance);
+        }
+    }, [keys]);
+
     return (
         <div>
             <div className="main-background"></div>
@@ -0,0 +1,40 @@
+// Loads the WebAssembly prover of zk-tee/cmd/wasm, which public/ serves along
+// with wasm_exec.js and the exports/custody_transfer.* keys of the server.
+let loading = null;
+
+const fetchBytes = async (path) => {
+    const response = await fetch(`${process.env.PUBLIC_URL}/${path}`);
+    if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+    }
+    return new Uint8Array(await response.arrayBuffer());
+};
+
+// loadSecretSpend resolves to the functions of the prover once its keys are
+// loaded, which only happens on the first call.
+export const loadSecretSpend = () => {
+    if (!loading) {
+        loading = (async () => {
+            const go = new window.Go();
+            const { instance } = await WebAssembly.instantiate(
+                await fetchBytes("secret_spend.wasm"),
+                go.importObject
+            );
+            go.run(instance);
+
+            const [r1cs, pk, vk] = await Promise.all(
+                ["r1cs", "pk", "vk"].map((ext) =>
+                    fetchBytes(`exports/custody_transfer.${ext}`)
+                )
+            );
+            await window.secretSpend.loadProver(r1cs, pk, vk);
+
+            return window.secretSpend;
+        })();
+        // A failed load is retried on the next call
+        loading.catch(() => {
+            loading = null;
+        });
+    }
+    return loading;
+};
@@ -0,0 +1,82 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+	gHash "github.com/consensys/gnark/std/hash"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// BatchTransferCircuit proves a sequence of transfers, each one applying to
+// the balances tree left by the previous one. Besides the domain, its only
+// public input is the hash of the old and new balances roots followed by the
+// leaf updates of every transfer, see batchUpdatesHash.
+//
+// With utils.PaillierBits keys and a tree of depth 5, a batch of 2 transfers
+// compiles to 785,794 constraints.
+type BatchTransferCircuit struct {
+	// Public inputs
+	Domain      Domain            `gnark:",public"`
+	UpdatesHash frontend.Variable `gnark:",public"`
+
+	// Private inputs
+	OldBalancesRoot   frontend.Variable
+	NewBalancesRoot   frontend.Variable
+	IntermediateRoots []frontend.Variable
+	Transfers         []TransferStep
+}
+
+// NewBatchTransferCircuit allocates a BatchTransferCircuit of batchSize
+// transfers, for a tree of the given depth and Paillier keys of the given bit
+// size.
+func NewBatchTransferCircuit(batchSize int, depth int, paillierBits int) BatchTransferCircuit {
+	var circuit BatchTransferCircuit
+	circuit.IntermediateRoots = make([]frontend.Variable, batchSize-1)
+	circuit.Transfers = make([]TransferStep, batchSize)
+	for i := range circuit.Transfers {
+		circuit.Transfers[i] = newTransferStep(depth, paillierBits)
+	}
+
+	return circuit
+}
+
+// batchUpdatesHash returns the hash of the old and new balances roots and of
+// the leaf updates of every transfer: the index, the fields of the old leaf
+// and the fields of the new leaf of the sender, then the same for the
+// recipient. Together with the roots, the updates let anyone replay the batch
+// on the old tree.
+func batchUpdatesHash(api frontend.API, hFunc gHash.FieldHasher, oldRoot, newRoot frontend.Variable, transfers []TransferStep) frontend.Variable {
+	inputs := []frontend.Variable{oldRoot, newRoot}
+	for _, transfer := range transfers {
+		inputs = append(inputs, transfer.FromIndex)
+		inputs = append(inputs, transfer.OldFromLeaf.fields()...)
+		inputs = append(inputs, transfer.NewFromLeaf.fields()...)
+		inputs = append(inputs, transfer.ToIndex)
+		inputs = append(inputs, transfer.OldToLeaf.fields()...)
+		inputs = append(inputs, transfer.NewToLeaf.fields()...)
+	}
+
+	return utils.HashInCircuit(hFunc, inputs...)
+}
+
+func (circuit *BatchTransferCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Domain.check(api)
+
+	roots := append([]frontend.Variable{circuit.OldBalancesRoot}, circuit.IntermediateRoots...)
+	roots = append(roots, circuit.NewBalancesRoot)
+	for i, transfer := range circuit.Transfers {
+		if err := transfer.verify(api, &hFunc, circuit.Domain, roots[i], roots[i+1]); err != nil {
+			return err
+		}
+	}
+
+	updatesHash := batchUpdatesHash(api, &hFunc, circuit.OldBalancesRoot, circuit.NewBalancesRoot, circuit.Transfers)
+	api.AssertIsEqual(updatesHash, circuit.UpdatesHash)
+
+	return nil
+}
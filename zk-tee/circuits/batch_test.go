@@ -0,0 +1,133 @@
+package circuits
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark-crypto/hash"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// nativeLeafFields mirrors BalanceLeaf.fields.
+func nativeLeafFields(leaf TestBalanceLeaf) []*big.Int {
+	var fields []*big.Int
+	fields = append(fields, utils.ToLimbs(leaf.PubKey.N, utils.NbLimbs(testPaillierBits))...)
+	fields = append(fields, utils.ToLimbs(leaf.PubKey.G, utils.NbLimbs(testPaillierBits))...)
+	fields = append(fields, utils.ToLimbs(leaf.EncBalance, utils.NbLimbs(2*testPaillierBits))...)
+	fields = append(fields, leaf.BalanceCommitment.X.BigInt(new(big.Int)), leaf.BalanceCommitment.Y.BigInt(new(big.Int)))
+	x, y := leaf.SpendingKey.A.X.Bytes(), leaf.SpendingKey.A.Y.Bytes()
+	fields = append(fields, new(big.Int).SetBytes(x[:]), new(big.Int).SetBytes(y[:]), leaf.Nonce)
+
+	return fields
+}
+
+// applyTestTransfer applies a transfer of amount from leaf from to leaf to of
+// the tree, updating leaves and data, and returns the resulting step.
+func applyTestTransfer(assert *test.Assert, tree *merkletree.SparseMerkleTree, leaves []TestBalanceLeaf, data []UserData, from, to int, amount *big.Int) TransferStep {
+	var step TransferStep
+	oldRoot := tree.MerkleRoot()
+
+	step.OldFromLeaf = leaves[from].circuitValue()
+	step.FromIndex = from
+	step.OldFromLeafMP = sparseProofAt(assert, tree, from)
+	step.OldFromBalance = data[from].Balance
+	step.OldFromBlinding = data[from].Blinding
+
+	step.OldToLeaf = leaves[to].circuitValue()
+	step.ToIndex = to
+	step.OldToLeafMP = sparseProofAt(assert, tree, to)
+	step.OldToBalance = data[to].Balance
+	step.OldToBlinding = data[to].Blinding
+
+	amountBlinding := randomBlinding()
+	step.Amount = amount
+	step.AmountBlinding = amountBlinding
+
+	msg := nativeTransferMessage(oldRoot, leaves[to], utils.Commit(amount, amountBlinding), data[from].Nonce)
+	sig, err := data[from].SpendingKey.Sign(msg, hash.MIMC_BN254.New())
+	assert.NoError(err)
+	step.Signature.Assign(tedwards.BN254, sig)
+
+	// The sender balance is committed to under a fresh blinding
+	step.NewFromBlinding = randomBlinding()
+	data[from].Balance = new(big.Int).Sub(data[from].Balance, amount)
+	data[from].Blinding = step.NewFromBlinding.(*big.Int)
+	data[from].EncBalance = encryptBalance(&data[from].PubKey, data[from].Balance, data[from].Blinding)
+	data[from].Nonce = new(big.Int).Add(data[from].Nonce, big.NewInt(1))
+	leaves[from].EncBalance = data[from].EncBalance
+	leaves[from].BalanceCommitment = utils.Commit(data[from].Balance, data[from].Blinding)
+	leaves[from].Nonce = data[from].Nonce
+
+	// The recipient balance is opened with the sum of its old blinding and
+	// the one of the amount
+	leaves[to] = nativeReceive(&data[to].PubKey, leaves[to], amount, amountBlinding)
+	data[to].Balance = new(big.Int).Add(data[to].Balance, amount)
+	data[to].Blinding = new(big.Int).Add(data[to].Blinding, amountBlinding)
+	data[to].EncBalance = leaves[to].EncBalance
+
+	_, err = tree.UpdateLeafAt(from, leaves[from])
+	assert.NoError(err)
+	_, err = tree.UpdateLeafAt(to, leaves[to])
+	assert.NoError(err)
+
+	step.NewFromLeaf = leaves[from].circuitValue()
+	step.NewToLeaf = leaves[to].circuitValue()
+
+	return step
+}
+
+func TestBatchTransferCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth, batchSize := 3, 2
+	tree, leaves, data := GenerateRandomTree(depth)
+	circuit := NewBatchTransferCircuit(batchSize, depth, testPaillierBits)
+
+	oldRoot := tree.MerkleRoot()
+	witness := BatchTransferCircuit{Domain: testDomain, OldBalancesRoot: oldRoot}
+	hfunc := hash.MIMC_BN254.New()
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+
+	// The second transfer spends from the balance received in the first one.
+	// Each update is the index, the old leaf and the new leaf.
+	var updates [][]*big.Int
+	for _, indexes := range [][2]int{{0, 1}, {1, 2}} {
+		from, to := indexes[0], indexes[1]
+		oldFrom, oldTo := leaves[from], leaves[to]
+		witness.Transfers = append(witness.Transfers, applyTestTransfer(assert, tree, leaves, data, from, to, big.NewInt(100)))
+		witness.IntermediateRoots = append(witness.IntermediateRoots, tree.MerkleRoot())
+		updates = append(updates,
+			append(append([]*big.Int{big.NewInt(int64(from))}, nativeLeafFields(oldFrom)...), nativeLeafFields(leaves[from])...),
+			append(append([]*big.Int{big.NewInt(int64(to))}, nativeLeafFields(oldTo)...), nativeLeafFields(leaves[to])...),
+		)
+	}
+	witness.IntermediateRoots = witness.IntermediateRoots[:batchSize-1]
+	witness.NewBalancesRoot = tree.MerkleRoot()
+
+	hfunc.Write(utils.Pad32Bytes(tree.MerkleRoot()))
+	for _, update := range updates {
+		for _, field := range update {
+			hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+		}
+	}
+	witness.UpdatesHash = hfunc.Sum(nil)
+
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+
+	// The intermediate roots are bound to the leaf updates
+	intermediateRoot := witness.IntermediateRoots[0]
+	witness.IntermediateRoots[0] = oldRoot
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+	witness.IntermediateRoots[0] = intermediateRoot
+
+	// The public input commits to the leaf updates
+	witness.UpdatesHash = 1
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
@@ -0,0 +1,263 @@
+package circuits
+
+import (
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/shreyas-londhe/private-erc20-circuits/hints"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// BigInt is a non-native integer, represented by little-endian limbs of
+// utils.LimbBits bits each. The arithmetic below assumes its operands are
+// normalized, i.e. that every limb fits in utils.LimbBits bits (one extra bit
+// is tolerated on the multiplication operands). Values coming from the
+// witness must be range checked with RangeCheck before being used.
+type BigInt struct {
+	Limbs []frontend.Variable
+}
+
+// NewBigInt allocates a BigInt able to hold integers of the given bit size,
+// to be used when defining a circuit.
+func NewBigInt(nbBits int) BigInt {
+	return BigInt{Limbs: make([]frontend.Variable, utils.NbLimbs(nbBits))}
+}
+
+// BigIntValue returns the assignment of x as a BigInt of the given bit size.
+func BigIntValue(x *big.Int, nbBits int) BigInt {
+	limbs := utils.ToLimbs(x, utils.NbLimbs(nbBits))
+
+	res := BigInt{Limbs: make([]frontend.Variable, len(limbs))}
+	for i, limb := range limbs {
+		res.Limbs[i] = limb
+	}
+
+	return res
+}
+
+func bigIntConstant(x int64, nbLimbs int) BigInt {
+	res := BigInt{Limbs: make([]frontend.Variable, nbLimbs)}
+	res.Limbs[0] = x
+	for i := 1; i < nbLimbs; i++ {
+		res.Limbs[i] = 0
+	}
+
+	return res
+}
+
+// bigIntFromVariable splits a native field element into limbs.
+func bigIntFromVariable(api frontend.API, v frontend.Variable) BigInt {
+	vBits := api.ToBinary(v)
+
+	res := BigInt{Limbs: make([]frontend.Variable, utils.NbLimbs(len(vBits)))}
+	for i := range res.Limbs {
+		end := min((i+1)*utils.LimbBits, len(vBits))
+		res.Limbs[i] = api.FromBinary(vBits[i*utils.LimbBits : end]...)
+	}
+
+	return res
+}
+
+// RangeCheck asserts that every limb of a fits in utils.LimbBits bits.
+func RangeCheck(api frontend.API, a BigInt) {
+	for _, limb := range a.Limbs {
+		api.ToBinary(limb, utils.LimbBits)
+	}
+}
+
+// mulLimbs returns the coefficients of the product of the polynomials whose
+// coefficients are the limbs of a and b, without propagating the carries.
+func mulLimbs(api frontend.API, a, b []frontend.Variable) []frontend.Variable {
+	res := make([]frontend.Variable, len(a)+len(b)-1)
+	for i := range res {
+		res[i] = 0
+	}
+
+	for i := range a {
+		for j := range b {
+			res[i+j] = api.Add(res[i+j], api.Mul(a[i], b[j]))
+		}
+	}
+
+	return res
+}
+
+// mulCoefBits bounds the bit size of the coefficients returned by mulLimbs
+// when one of the operands has n limbs, leaving room for adding two such
+// products.
+func mulCoefBits(n int) int {
+	return 2*(utils.LimbBits+1) + bits.Len(uint(n)) + 1
+}
+
+// assertLimbsEqual asserts that the integers whose (possibly unnormalized)
+// limbs are lhs and rhs are equal, given that no coefficient of either side
+// exceeds coefBits bits. The carries between the limbs are provided by a hint
+// and bounded so that no equation can wrap around the field.
+func assertLimbsEqual(api frontend.API, lhs, rhs []frontend.Variable, coefBits int) {
+	n := max(len(lhs), len(rhs))
+	diff := make([]frontend.Variable, n)
+	for i := range diff {
+		var l, r frontend.Variable = 0, 0
+		if i < len(lhs) {
+			l = lhs[i]
+		}
+		if i < len(rhs) {
+			r = rhs[i]
+		}
+		diff[i] = api.Sub(l, r)
+	}
+
+	if n == 1 {
+		api.AssertIsEqual(diff[0], 0)
+		return
+	}
+
+	carries, err := api.NewHint(hints.CarryLimbsHint, n-1, diff...)
+	if err != nil {
+		panic(err)
+	}
+
+	carryBits := max(coefBits-utils.LimbBits+1, 1)
+	offset := new(big.Int).Lsh(big.NewInt(1), uint(carryBits))
+	base := new(big.Int).Lsh(big.NewInt(1), utils.LimbBits)
+
+	prev := frontend.Variable(0)
+	for i := 0; i < n-1; i++ {
+		api.ToBinary(api.Add(carries[i], offset), carryBits+1)
+		api.AssertIsEqual(api.Add(diff[i], prev), api.Mul(carries[i], base))
+		prev = carries[i]
+	}
+	api.AssertIsEqual(api.Add(diff[n-1], prev), 0)
+}
+
+// Mul returns the product of a and b.
+func Mul(api frontend.API, a, b BigInt) BigInt {
+	inputs := append([]frontend.Variable{len(a.Limbs)}, a.Limbs...)
+	inputs = append(inputs, b.Limbs...)
+	prod, err := api.NewHint(hints.MulLimbsHint, len(a.Limbs)+len(b.Limbs), inputs...)
+	if err != nil {
+		panic(err)
+	}
+
+	res := BigInt{Limbs: prod}
+	RangeCheck(api, res)
+
+	coefBits := mulCoefBits(min(len(a.Limbs), len(b.Limbs)))
+	assertLimbsEqual(api, mulLimbs(api, a.Limbs, b.Limbs), res.Limbs, coefBits)
+
+	return res
+}
+
+// MulMod returns a*b mod m. The modulus is expected to have a non-zero most
+// significant limb.
+func MulMod(api frontend.API, a, b, mod BigInt) BigInt {
+	nbQ := max(len(a.Limbs)+len(b.Limbs)-len(mod.Limbs)+1, 1)
+
+	inputs := append([]frontend.Variable{len(a.Limbs), len(b.Limbs)}, a.Limbs...)
+	inputs = append(inputs, b.Limbs...)
+	inputs = append(inputs, mod.Limbs...)
+	res, err := api.NewHint(hints.MulModLimbsHint, nbQ+len(mod.Limbs), inputs...)
+	if err != nil {
+		panic(err)
+	}
+
+	q := BigInt{Limbs: res[:nbQ]}
+	r := BigInt{Limbs: res[nbQ:]}
+	RangeCheck(api, q)
+	RangeCheck(api, r)
+
+	// a*b = q*m + r
+	rhs := mulLimbs(api, q.Limbs, mod.Limbs)
+	for i, limb := range r.Limbs {
+		rhs[i] = api.Add(rhs[i], limb)
+	}
+	coefBits := mulCoefBits(max(min(len(a.Limbs), len(b.Limbs)), min(nbQ, len(mod.Limbs))))
+	assertLimbsEqual(api, mulLimbs(api, a.Limbs, b.Limbs), rhs, coefBits)
+
+	AssertIsLess(api, r, mod)
+
+	return r
+}
+
+// SquareMod returns a^2 mod m.
+func SquareMod(api frontend.API, a, mod BigInt) BigInt {
+	return MulMod(api, a, a, mod)
+}
+
+// PowMod returns base^exp mod m. The cost of the exponentiation depends on
+// the number of limbs of exp, not on its actual value.
+func PowMod(api frontend.API, base, exp, mod BigInt) BigInt {
+	var expBits []frontend.Variable
+	for _, limb := range exp.Limbs {
+		expBits = append(expBits, api.ToBinary(limb, utils.LimbBits)...)
+	}
+
+	one := bigIntConstant(1, len(mod.Limbs))
+	baseMod := MulMod(api, base, one, mod)
+	res := one
+
+	for i := len(expBits) - 1; i >= 0; i-- {
+		res = SquareMod(api, res, mod)
+
+		temp := MulMod(api, res, baseMod, mod)
+
+		res = Select(api, expBits[i], temp, res)
+	}
+
+	return res
+}
+
+// AssertIsLess asserts that a < b.
+func AssertIsLess(api frontend.API, a, b BigInt) {
+	// b = (a + 1) + d for some d >= 0
+	aPlusOne := BigInt{Limbs: append([]frontend.Variable{api.Add(a.Limbs[0], 1)}, a.Limbs[1:]...)}
+
+	inputs := append([]frontend.Variable{len(b.Limbs)}, b.Limbs...)
+	inputs = append(inputs, aPlusOne.Limbs...)
+	diff, err := api.NewHint(hints.SubLimbsHint, len(b.Limbs), inputs...)
+	if err != nil {
+		panic(err)
+	}
+
+	d := BigInt{Limbs: diff}
+	RangeCheck(api, d)
+
+	lhs := make([]frontend.Variable, max(len(aPlusOne.Limbs), len(d.Limbs)))
+	for i := range lhs {
+		lhs[i] = 0
+		if i < len(aPlusOne.Limbs) {
+			lhs[i] = api.Add(lhs[i], aPlusOne.Limbs[i])
+		}
+		if i < len(d.Limbs) {
+			lhs[i] = api.Add(lhs[i], d.Limbs[i])
+		}
+	}
+	assertLimbsEqual(api, lhs, b.Limbs, utils.LimbBits+2)
+}
+
+// Select returns a if sel is 1 and b otherwise. Both operands must have the
+// same number of limbs.
+func Select(api frontend.API, sel frontend.Variable, a, b BigInt) BigInt {
+	res := BigInt{Limbs: make([]frontend.Variable, len(a.Limbs))}
+	for i := range res.Limbs {
+		res.Limbs[i] = api.Select(sel, a.Limbs[i], b.Limbs[i])
+	}
+
+	return res
+}
+
+// AssertIsEqual asserts that a and b hold the same limbs. Missing limbs are
+// treated as zero.
+func (a BigInt) AssertIsEqual(api frontend.API, b BigInt) {
+	for i := 0; i < max(len(a.Limbs), len(b.Limbs)); i++ {
+		var l, r frontend.Variable = 0, 0
+		if i < len(a.Limbs) {
+			l = a.Limbs[i]
+		}
+		if i < len(b.Limbs) {
+			r = b.Limbs[i]
+		}
+		api.AssertIsEqual(l, r)
+	}
+}
@@ -0,0 +1,105 @@
+package circuits
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+type TestMulModCircuit struct {
+	A      BigInt
+	B      BigInt
+	Mod    BigInt
+	Result BigInt
+}
+
+type TestAssertIsLessCircuit struct {
+	A BigInt
+	B BigInt
+}
+
+func (circuit *TestMulModCircuit) Define(api frontend.API) error {
+	RangeCheck(api, circuit.A)
+	RangeCheck(api, circuit.B)
+	RangeCheck(api, circuit.Mod)
+
+	result := MulMod(api, circuit.A, circuit.B, circuit.Mod)
+	result.AssertIsEqual(api, circuit.Result)
+
+	return nil
+}
+
+func (circuit *TestAssertIsLessCircuit) Define(api frontend.API) error {
+	AssertIsLess(api, circuit.A, circuit.B)
+
+	return nil
+}
+
+func TestMulMod(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	testCase := func(nbBits int) {
+		a := utils.RandomBigInt(nbBits)
+		b := utils.RandomBigInt(nbBits)
+		mod := utils.RandomBigInt(nbBits)
+		mod.SetBit(mod, nbBits-1, 1)
+		result := new(big.Int).Mod(new(big.Int).Mul(a, b), mod)
+
+		circuit := &TestMulModCircuit{
+			A:      NewBigInt(nbBits),
+			B:      NewBigInt(nbBits),
+			Mod:    NewBigInt(nbBits),
+			Result: NewBigInt(nbBits),
+		}
+		witness := &TestMulModCircuit{
+			A:      BigIntValue(a, nbBits),
+			B:      BigIntValue(b, nbBits),
+			Mod:    BigIntValue(mod, nbBits),
+			Result: BigIntValue(result, nbBits),
+		}
+
+		err := test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
+		assert.NoError(err)
+
+		wrongResult := new(big.Int).Mod(new(big.Int).Add(result, big.NewInt(1)), mod)
+		witness.Result = BigIntValue(wrongResult, nbBits)
+		err = test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+	}
+
+	testCase(testPaillierBits)
+	testCase(2 * utils.PaillierBits)
+}
+
+func TestAssertIsLess(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	b := utils.RandomBigInt(testPaillierBits)
+	b.SetBit(b, testPaillierBits-1, 1)
+
+	testCase := func(a *big.Int, valid bool) {
+		circuit := &TestAssertIsLessCircuit{
+			A: NewBigInt(testPaillierBits),
+			B: NewBigInt(testPaillierBits),
+		}
+		witness := &TestAssertIsLessCircuit{
+			A: BigIntValue(a, testPaillierBits),
+			B: BigIntValue(b, testPaillierBits),
+		}
+
+		err := test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
+		if valid {
+			assert.NoError(err)
+		} else {
+			assert.Error(err)
+		}
+	}
+
+	testCase(new(big.Int).Sub(b, big.NewInt(1)), true)
+	testCase(big.NewInt(0), true)
+	testCase(b, false)
+}
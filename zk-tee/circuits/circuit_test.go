@@ -7,8 +7,12 @@ import (
 	"testing"
 
 	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	cryptoEddsa "github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
 	"github.com/consensys/gnark-crypto/hash"
 	"github.com/consensys/gnark/frontend"
+	gEdwards "github.com/consensys/gnark/std/algebra/native/twistededwards"
 	"github.com/consensys/gnark/test"
 	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
 	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
@@ -16,10 +20,12 @@ import (
 )
 
 type UserData struct {
-	PubKey     paillier.PublicKey
-	Balance    *big.Int
-	EncBalance *big.Int
-	EncR       *big.Int
+	PubKey      paillier.PublicKey
+	SpendingKey *cryptoEddsa.PrivateKey
+	Nonce       *big.Int
+	Balance     *big.Int
+	Blinding    *big.Int
+	EncBalance  *big.Int
 }
 
 type TestPaillierPubKey struct {
@@ -28,19 +34,67 @@ type TestPaillierPubKey struct {
 }
 
 type TestBalanceLeaf struct {
-	PubKey     TestPaillierPubKey
-	EncBalance *big.Int
+	PubKey            TestPaillierPubKey
+	EncBalance        *big.Int
+	BalanceCommitment twistededwards.PointAffine
+	SpendingKey       cryptoEddsa.PublicKey
+	Nonce             *big.Int
 }
 
 func (t TestBalanceLeaf) CalculateHash() ([]byte, error) {
 	hfunc := hash.MIMC_BN254.New()
 	hfunc.Reset()
-	hfunc.Write(utils.Pad32Bytes(t.PubKey.N.Bytes()))
-	hfunc.Write(utils.Pad32Bytes(t.PubKey.G.Bytes()))
-	hfunc.Write(utils.Pad32Bytes(t.EncBalance.Bytes()))
+	for _, field := range nativeLeafFields(t) {
+		hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+	}
 	return hfunc.Sum(nil), nil
 }
 
+func (t TestBalanceLeaf) circuitValue() BalanceLeaf {
+	leaf := BalanceLeaf{
+		PubKey: PaillierPubKey{
+			N: BigIntValue(t.PubKey.N, testPaillierBits),
+			G: BigIntValue(t.PubKey.G, testPaillierBits),
+		},
+		EncBalance:        BigIntValue(t.EncBalance, 2*testPaillierBits),
+		BalanceCommitment: pointValue(t.BalanceCommitment),
+		Nonce:             t.Nonce,
+	}
+	leaf.SpendingKey.Assign(tedwards.BN254, t.SpendingKey.Bytes())
+	return leaf
+}
+
+// The domain of the test proofs: Scroll Sepolia and the deployed contract.
+var (
+	testChainID     = big.NewInt(534351)
+	testContract, _ = new(big.Int).SetString("9AB81C32e1D621404b253c7fE0fC9972d1645E69", 16)
+	testDomain      = Domain{ChainID: testChainID, Contract: testContract}
+)
+
+// pointValue returns the assignment of a point in the circuit witness.
+func pointValue(p twistededwards.PointAffine) gEdwards.Point {
+	return gEdwards.Point{X: p.X.String(), Y: p.Y.String()}
+}
+
+// nativeTransferMessage mirrors the message signed by the sender of a transfer.
+func nativeTransferMessage(oldRoot []byte, toLeaf TestBalanceLeaf, amountCommitment twistededwards.PointAffine, nonce *big.Int) []byte {
+	toLeafHash, err := toLeaf.CalculateHash()
+	if err != nil {
+		panic(err)
+	}
+
+	hfunc := hash.MIMC_BN254.New()
+	hfunc.Write(utils.Pad32Bytes(testChainID.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(testContract.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+	hfunc.Write(toLeafHash)
+	x, y := amountCommitment.X.Bytes(), amountCommitment.Y.Bytes()
+	hfunc.Write(x[:])
+	hfunc.Write(y[:])
+	hfunc.Write(utils.Pad32Bytes(nonce.Bytes()))
+	return hfunc.Sum(nil)
+}
+
 func (t TestBalanceLeaf) Equals(other merkletree.Content) (bool, error) {
 	tHash, err := t.CalculateHash()
 	if err != nil {
@@ -53,253 +107,330 @@ func (t TestBalanceLeaf) Equals(other merkletree.Content) (bool, error) {
 	return bytes.Equal(tHash, otherHash), nil
 }
 
-func GenerateRandomTree(depth int) (merkletree.MerkleTree, []TestBalanceLeaf, []UserData) {
+// newTestLeaf returns the leaf of an account with the given keys, encrypted
+// balance, balance commitment and nonce.
+func newTestLeaf(pubKey *paillier.PublicKey, encBalance *big.Int, commitment twistededwards.PointAffine, spendingKey cryptoEddsa.PublicKey, nonce *big.Int) TestBalanceLeaf {
+	return TestBalanceLeaf{
+		PubKey:            TestPaillierPubKey{N: pubKey.N, G: pubKey.G},
+		EncBalance:        encBalance,
+		BalanceCommitment: commitment,
+		SpendingKey:       spendingKey,
+		Nonce:             nonce,
+	}
+}
+
+// encryptBalance returns the encryption of the opening of a balance
+// commitment, see utils.BalancePlaintext.
+func encryptBalance(pubKey *paillier.PublicKey, balance *big.Int, blinding *big.Int) *big.Int {
+	encBalance, _, err := paillier.Encrypt(pubKey, utils.BalancePlaintext(balance, blinding).Bytes())
+	if err != nil {
+		panic(err)
+	}
+	return new(big.Int).SetBytes(encBalance)
+}
+
+// randomBlinding returns a random blinding of a balance commitment.
+func randomBlinding() *big.Int {
+	blinding, err := utils.RandomBlinding()
+	if err != nil {
+		panic(err)
+	}
+	return blinding
+}
+
+func GenerateRandomTree(depth int) (*merkletree.SparseMerkleTree, []TestBalanceLeaf, []UserData) {
 	numLeaves := 1 << depth
 
 	var data []UserData
 
 	// Creating data
 	keypairs := make([]*paillier.PrivateKey, numLeaves)
+	spendingKeys := make([]*cryptoEddsa.PrivateKey, numLeaves)
 	encryptedBalances := make([]*big.Int, numLeaves)
+	commitments := make([]twistededwards.PointAffine, numLeaves)
 	for i := 0; i < numLeaves; i++ {
 		var err error
-		keypairs[i], err = paillier.GenerateKey(rand.Reader, utils.PaillierBits)
+		keypairs[i], err = paillier.GenerateKey(rand.Reader, testPaillierBits)
 		if err != nil {
 			panic(err)
 		}
-
-		balanceBytes := utils.RandomBigInt(utils.PaillierBits - 1).Bytes()
-		encryptedBalanceBytes, r, err := paillier.Encrypt(&keypairs[i].PublicKey, balanceBytes)
+		spendingKeys[i], err = cryptoEddsa.GenerateKey(rand.Reader)
 		if err != nil {
 			panic(err)
 		}
-		balance := new(big.Int).SetBytes(balanceBytes)
-		encryptedBalances[i] = new(big.Int).SetBytes(encryptedBalanceBytes)
+
+		balance := utils.RandomBigInt(utils.BalanceBits - 1)
+		blinding := randomBlinding()
+		encryptedBalances[i] = encryptBalance(&keypairs[i].PublicKey, balance, blinding)
+		commitments[i] = utils.Commit(balance, blinding)
 
 		data = append(data, UserData{
-			PubKey:     keypairs[i].PublicKey,
-			Balance:    balance,
-			EncBalance: encryptedBalances[i],
-			EncR:       r,
+			PubKey:      keypairs[i].PublicKey,
+			SpendingKey: spendingKeys[i],
+			Nonce:       big.NewInt(0),
+			Balance:     balance,
+			Blinding:    blinding,
+			EncBalance:  encryptedBalances[i],
 		})
 	}
 
 	// Creating leaves
-	var leavesInTree []merkletree.Content
+	tree, err := merkletree.NewSparseTree(depth)
+	if err != nil {
+		panic(err)
+	}
 	var leaves []TestBalanceLeaf
 	for i := 0; i < numLeaves; i++ {
-		leavesInTree = append(leavesInTree, TestBalanceLeaf{
-			PubKey: TestPaillierPubKey{
-				N: keypairs[i].PublicKey.N,
-				G: keypairs[i].PublicKey.G,
-			},
-			EncBalance: encryptedBalances[i],
-		})
-		leaves = append(leaves, TestBalanceLeaf{
-			PubKey: TestPaillierPubKey{
-				N: keypairs[i].PublicKey.N,
-				G: keypairs[i].PublicKey.G,
-			},
-			EncBalance: encryptedBalances[i],
-		})
+		leaf := newTestLeaf(&keypairs[i].PublicKey, encryptedBalances[i], commitments[i], spendingKeys[i].PublicKey, big.NewInt(0))
+		if _, err := tree.UpdateLeafAt(i, leaf); err != nil {
+			panic(err)
+		}
+		leaves = append(leaves, leaf)
 	}
 
-	tree, err := merkletree.NewTree(leavesInTree)
-	if err != nil {
-		panic(err)
-	}
+	return tree, leaves, data
+}
+
+// sparseProofAt returns the assignment of the proof of the leaf at index.
+func sparseProofAt(assert *test.Assert, tree *merkletree.SparseMerkleTree, index int) utils.SparseMerkleProof {
+	proof, err := tree.ProveMembership(index)
+	assert.NoError(err)
 
-	return *tree, leaves, data
+	res := utils.SparseMerkleProof{
+		RootHash: tree.MerkleRoot(),
+		Siblings: make([]frontend.Variable, len(proof.Siblings)),
+	}
+	for i, sibling := range proof.Siblings {
+		res.Siblings[i] = sibling
+	}
+	return res
 }
 
-func GenerateTreeFromLeaves(leaves []TestBalanceLeaf) merkletree.MerkleTree {
-	var leavesInTree []merkletree.Content
+// nativeLeavesHash mirrors transferLeavesHash.
+func nativeLeavesHash(leaves ...TestBalanceLeaf) []byte {
+	hfunc := hash.MIMC_BN254.New()
 	for _, leaf := range leaves {
-		leavesInTree = append(leavesInTree, TestBalanceLeaf{
-			PubKey: TestPaillierPubKey{
-				N: leaf.PubKey.N,
-				G: leaf.PubKey.G,
-			},
-			EncBalance: leaf.EncBalance,
-		})
+		for _, field := range nativeLeafFields(leaf) {
+			hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+		}
 	}
+	return hfunc.Sum(nil)
+}
 
-	tree, err := merkletree.NewTree(leavesInTree)
-	if err != nil {
-		panic(err)
-	}
+// nativeReceive returns the recipient leaf once it receives amount with the
+// given blinding, as the server computes it.
+func nativeReceive(pubKey *paillier.PublicKey, leaf TestBalanceLeaf, amount *big.Int, blinding *big.Int) TestBalanceLeaf {
+	encAmount := encryptBalance(pubKey, amount, blinding)
+	leaf.EncBalance = new(big.Int).SetBytes(paillier.AddCipher(pubKey, leaf.EncBalance.Bytes(), encAmount.Bytes()))
+	amountCommitment := utils.Commit(amount, blinding)
+	leaf.BalanceCommitment.Add(&leaf.BalanceCommitment, &amountCommitment)
+	return leaf
+}
 
-	return *tree
+// generateTransferWitness builds a witness for a transfer of amount from leaf
+// 0 to leaf 1 of a random tree. The new sender balance is computed in the
+// scalar field, so that overspending produces a field-wrapping witness.
+func generateTransferWitness(assert *test.Assert, depth int, amount *big.Int) (PrivateCoinCircuit, PrivateCoinCircuit) {
+	// Generate random tree
+	tree, leaves, data := GenerateRandomTree(depth)
+	oldFromLeaf, oldToLeaf := leaves[0], leaves[1]
+
+	circuit := NewPrivateCoinCircuit(depth, testPaillierBits)
+
+	// Generate witness
+	var witness PrivateCoinCircuit
+	witness.Domain = testDomain
+	witness.OldBalancesRoot = tree.MerkleRoot()
+
+	// For leaf 0
+	witness.OldFromLeaf = leaves[0].circuitValue()
+	witness.FromIndex = 0
+	witness.OldFromLeafMP = sparseProofAt(assert, tree, 0)
+	witness.OldFromBalance = data[0].Balance
+	witness.OldFromBlinding = data[0].Blinding
+
+	// For leaf 1
+	witness.OldToLeaf = leaves[1].circuitValue()
+	witness.ToIndex = 1
+	witness.OldToLeafMP = sparseProofAt(assert, tree, 1)
+	witness.OldToBalance = data[1].Balance
+	witness.OldToBlinding = data[1].Blinding
+
+	// Commit to the amount
+	amountBlinding := randomBlinding()
+	witness.Amount = amount
+	witness.AmountBlinding = amountBlinding
+
+	// Sign the transfer with leaf 0's spending key
+	msg := nativeTransferMessage(tree.MerkleRoot(), leaves[1], utils.Commit(amount, amountBlinding), data[0].Nonce)
+	sig, err := data[0].SpendingKey.Sign(msg, hash.MIMC_BN254.New())
+	assert.NoError(err)
+	witness.Signature.Assign(tedwards.BN254, sig)
+
+	// Calculate new balance for leaf 0, under a fresh blinding
+	newFromBalance := new(big.Int).Sub(data[0].Balance, amount)
+	newFromBalance.Mod(newFromBalance, ecc.BN254.ScalarField())
+	newFromBlinding := randomBlinding()
+	witness.NewFromBlinding = newFromBlinding
+
+	leaves[0].EncBalance = encryptBalance(&data[0].PubKey, newFromBalance, newFromBlinding)
+	leaves[0].BalanceCommitment = utils.Commit(newFromBalance, newFromBlinding)
+	leaves[0].Nonce = new(big.Int).Add(data[0].Nonce, big.NewInt(1))
+
+	// Calculate new balance for leaf 1
+	leaves[1] = nativeReceive(&data[1].PubKey, leaves[1], amount, amountBlinding)
+
+	// Update the tree
+	_, err = tree.UpdateLeafAt(0, leaves[0])
+	assert.NoError(err)
+	_, err = tree.UpdateLeafAt(1, leaves[1])
+	assert.NoError(err)
+	witness.NewBalancesRoot = tree.MerkleRoot()
+
+	witness.NewFromLeaf = leaves[0].circuitValue()
+	witness.NewToLeaf = leaves[1].circuitValue()
+	witness.LeavesHash = nativeLeavesHash(oldFromLeaf, oldToLeaf, leaves[0], leaves[1])
+
+	return circuit, witness
 }
 
 func TestMainCircuit(t *testing.T) {
 	assert := test.NewAssert(t)
 
-	depth := 5
-
 	testCase := func() {
-		// Generate random tree
-		tree, leaves, data := GenerateRandomTree(depth)
-
-		var circuit PrivateCoinCircuit
-		circuit.OldFromLeafMP.Path = make([]frontend.Variable, depth+1)
-		circuit.OldToLeafMP.Path = make([]frontend.Variable, depth+1)
-		circuit.NewFromLeafMP.Path = make([]frontend.Variable, depth+1)
-		circuit.NewToLeafMP.Path = make([]frontend.Variable, depth+1)
-
-		// Generate witness
-		var witness PrivateCoinCircuit
-		witness.OldFromLeafMP.Path = make([]frontend.Variable, depth+1)
-		witness.OldToLeafMP.Path = make([]frontend.Variable, depth+1)
-		witness.NewFromLeafMP.Path = make([]frontend.Variable, depth+1)
-		witness.NewToLeafMP.Path = make([]frontend.Variable, depth+1)
-
-		witness.OldBalancesRoot = tree.MerkleRoot()
-
-		// For leaf 0
-		proof0, proofHelper0, err := tree.GetMerklePath(leaves[0])
-		if err != nil {
-			panic(err)
-		}
-		success, err := tree.VerifyContent(leaves[0])
-		if err != nil {
-			panic(err)
-		}
-		assert.True(success)
-
-		witness.OldFromLeaf.PubKey.N = leaves[0].PubKey.N
-		witness.OldFromLeaf.PubKey.G = leaves[0].PubKey.G
-		witness.OldFromLeaf.EncBalance = leaves[0].EncBalance
-		witness.OldFromLeafMP.RootHash = tree.MerkleRoot()
-		for i := 0; i < depth+1; i++ {
-			if i == 0 {
-				witness.OldFromLeafMP.Path[i], err = leaves[0].CalculateHash()
-				if err != nil {
-					panic(err)
-				}
-				continue
-			}
-			witness.OldFromLeafMP.Path[i] = proof0[i-1]
-		}
-		witness.OldFromLeafMPHelper = proofHelper0
-		witness.OldFromBalance = data[0].Balance
-		witness.EncOldFromBalanceR = data[0].EncR
+		circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
 
-		// For leaf 1
-		proof1, proofHelper1, err := tree.GetMerklePath(leaves[1])
-		if err != nil {
-			panic(err)
-		}
-		success, err = tree.VerifyContent(leaves[1])
-		if err != nil {
-			panic(err)
-		}
-		assert.True(success)
-
-		witness.OldToLeaf.PubKey.N = leaves[1].PubKey.N
-		witness.OldToLeaf.PubKey.G = leaves[1].PubKey.G
-		witness.OldToLeaf.EncBalance = leaves[1].EncBalance
-		witness.OldToLeafMP.RootHash = tree.MerkleRoot()
-		for i := 0; i < depth+1; i++ {
-			if i == 0 {
-				witness.OldToLeafMP.Path[i], err = leaves[1].CalculateHash()
-				if err != nil {
-					panic(err)
-				}
-				continue
-			}
-			witness.OldToLeafMP.Path[i] = proof1[i-1]
-		}
-		witness.OldToLeafMPHelper = proofHelper1
+		err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.NoError(err)
+	}
 
-		// Encrypt amount with leaf 1's public key
-		amount := big.NewInt(100) // Amount to transfer
-		witness.Amount = amount
-		encAmountBytes, r, err := paillier.Encrypt(&data[1].PubKey, amount.Bytes())
-		if err != nil {
-			panic(err)
-		}
-		witness.EncAmountR = r
+	testCase()
+}
 
-		// Calculate new balance for leaf 0
-		newFromBalance := new(big.Int).Sub(data[0].Balance, amount)
-		encNewFromBalanceBytes, r, err := paillier.Encrypt(&data[0].PubKey, newFromBalance.Bytes())
-		if err != nil {
-			panic(err)
-		}
-		witness.EncNewFromBalanceR = r
+func TestMainCircuitRangeChecks(t *testing.T) {
+	assert := test.NewAssert(t)
 
-		data[0].Balance = newFromBalance
-		data[0].EncBalance = new(big.Int).SetBytes(encNewFromBalanceBytes)
-		leaves[0].EncBalance = new(big.Int).SetBytes(encNewFromBalanceBytes)
+	testCase := func(amount *big.Int) {
+		circuit, witness := generateTransferWitness(assert, 5, amount)
 
-		// Calculate new balance for leaf 1
-		encNewToBalanceBytes := paillier.AddCipher(&data[1].PubKey, encAmountBytes, data[1].EncBalance.Bytes())
-		data[1].Balance = new(big.Int).Add(amount, data[1].Balance)
-		data[1].EncBalance = new(big.Int).SetBytes(encNewToBalanceBytes)
-		leaves[1].EncBalance = new(big.Int).SetBytes(encNewToBalanceBytes)
+		err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+	}
 
-		// Recalculate Tree
-		newTree := GenerateTreeFromLeaves(leaves)
-		witness.NewBalancesRoot = newTree.MerkleRoot()
+	// More than the sender balance, which is below 2^(BalanceBits-1)
+	testCase(new(big.Int).Lsh(big.NewInt(1), utils.BalanceBits-1))
+	// Wraps the sender balance around the scalar field
+	testCase(new(big.Int).Sub(ecc.BN254.ScalarField(), big.NewInt(1)))
+}
 
-		// For leaf 0
-		newProof0, newProofHelper0, err := newTree.GetMerklePath(leaves[0])
-		if err != nil {
-			panic(err)
-		}
-		success, err = newTree.VerifyContent(leaves[0])
-		if err != nil {
-			panic(err)
-		}
-		assert.True(success)
-
-		witness.NewFromLeaf.PubKey.N = leaves[0].PubKey.N
-		witness.NewFromLeaf.PubKey.G = leaves[0].PubKey.G
-		witness.NewFromLeaf.EncBalance = leaves[0].EncBalance
-		witness.NewFromLeafMP.RootHash = newTree.MerkleRoot()
-		for i := 0; i < depth+1; i++ {
-			if i == 0 {
-				witness.NewFromLeafMP.Path[i], err = leaves[0].CalculateHash()
-				if err != nil {
-					panic(err)
-				}
-				continue
-			}
-			witness.NewFromLeafMP.Path[i] = newProof0[i-1]
-		}
-		witness.NewFromLeafMPHelper = newProofHelper0
+func TestMainCircuitSignature(t *testing.T) {
+	assert := test.NewAssert(t)
 
-		// For leaf 1
-		newProof1, newProofHelper1, err := newTree.GetMerklePath(leaves[1])
-		if err != nil {
-			panic(err)
-		}
-		success, err = newTree.VerifyContent(leaves[1])
-		if err != nil {
-			panic(err)
-		}
-		assert.True(success)
-
-		witness.NewToLeaf.PubKey.N = leaves[1].PubKey.N
-		witness.NewToLeaf.PubKey.G = leaves[1].PubKey.G
-		witness.NewToLeaf.EncBalance = leaves[1].EncBalance
-		witness.NewToLeafMP.RootHash = newTree.MerkleRoot()
-		for i := 0; i < depth+1; i++ {
-			if i == 0 {
-				witness.NewToLeafMP.Path[i], err = leaves[1].CalculateHash()
-				if err != nil {
-					panic(err)
-				}
-				continue
-			}
-			witness.NewToLeafMP.Path[i] = newProof1[i-1]
-		}
-		witness.NewToLeafMPHelper = newProofHelper1
+	testCase := func() {
+		circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
+
+		// Forge the signature scalar
+		witness.Signature.S = 1
+
+		err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+	}
 
+	testCase()
+}
+
+func TestMainCircuitClientCustody(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	testCase := func() {
+		circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
+
+		// The sender does not know the recipient balance
+		witness.OldToBalance = 0
+		witness.OldToBlinding = 0
+
+		err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+
+		circuit.ClientCustody = true
 		err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
 		assert.NoError(err)
+
+		// The recipient leaf is still opened in the old tree
+		oldToLeaf := witness.OldToLeaf
+		witness.OldToLeaf.Nonce = 1
+		err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+		witness.OldToLeaf = oldToLeaf
+
+		// and must receive the amount
+		witness.NewToLeaf.BalanceCommitment = witness.OldToLeaf.BalanceCommitment
+		err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
 	}
 
 	testCase()
+
+	// The amount is range checked in both modes
+	for _, amount := range []*big.Int{
+		new(big.Int).Lsh(big.NewInt(1), utils.BalanceBits-1),
+		new(big.Int).Sub(ecc.BN254.ScalarField(), big.NewInt(1)),
+	} {
+		circuit, witness := generateTransferWitness(assert, 5, amount)
+		circuit.ClientCustody = true
+		witness.OldToBalance = 0
+		witness.OldToBlinding = 0
+
+		err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+		assert.Error(err)
+	}
+}
+
+func TestMainCircuitDomain(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
+
+	// The signature is bound to the domain of the proof
+	witness.Domain.ChainID = 1
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	witness.Domain = testDomain
+	witness.Domain.Contract = new(big.Int).Add(testContract, big.NewInt(1))
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	// The chain ID is not zero and the contract is an address
+	witness.Domain = Domain{ChainID: 0, Contract: testContract}
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestMainCircuitNewRoot(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
+
+	// The new root must be the old tree with both leaves updated
+	witness.NewBalancesRoot = witness.OldBalancesRoot
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestMainCircuitSenderBalance(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	circuit, witness := generateTransferWitness(assert, 5, big.NewInt(100))
+
+	// The new sender commitment opens to the old balance minus the amount,
+	// under NewFromBlinding
+	witness.NewFromBlinding = 2
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	circuit, witness = generateTransferWitness(assert, 5, big.NewInt(100))
+	witness.OldFromBalance = new(big.Int).Add(witness.OldFromBalance.(*big.Int), big.NewInt(1))
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
 }
@@ -0,0 +1,38 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// blindingGenerator returns H, the generator of the blinding of the balance
+// commitments, see utils.BlindingGenerator.
+func blindingGenerator() twistededwards.Point {
+	h := utils.BlindingGenerator()
+	return twistededwards.Point{X: h.X.String(), Y: h.Y.String()}
+}
+
+// commitBalance returns the commitment to a balance, balance*G + blinding*H,
+// see utils.Commit.
+func commitBalance(curve twistededwards.Curve, balance, blinding frontend.Variable) twistededwards.Point {
+	return curve.DoubleBaseScalarMul(generator(curve), blindingGenerator(), balance, blinding)
+}
+
+// commitPublic returns the commitment to a balance with no blinding,
+// balance*G, which adding to a commitment adds to its balance.
+func commitPublic(curve twistededwards.Curve, balance frontend.Variable) twistededwards.Point {
+	return curve.ScalarMul(generator(curve), balance)
+}
+
+// generator returns G, the generator of the curve.
+func generator(curve twistededwards.Curve) twistededwards.Point {
+	base := curve.Params().Base
+	return twistededwards.Point{X: base[0], Y: base[1]}
+}
+
+// assertPointsEqual asserts that both points are equal.
+func assertPointsEqual(api frontend.API, p, q twistededwards.Point) {
+	api.AssertIsEqual(p.X, q.X)
+	api.AssertIsEqual(p.Y, q.Y)
+}
@@ -0,0 +1,82 @@
+package circuits
+
+import (
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// DepositCircuit proves that the balance of a leaf was increased by a public
+// amount, moving tokens from the contract into the private balances. The
+// amount is added to both the balance commitment and the encrypted balance.
+// The old and new leaves are only public through the hash of their fields,
+// LeavesHash, as in PrivateCoinCircuit.
+//
+// With utils.PaillierBits keys and a tree of depth 5, it compiles to 272,190
+// constraints.
+type DepositCircuit struct {
+	// Public inputs
+	Domain          Domain            `gnark:",public"`
+	OldBalancesRoot frontend.Variable `gnark:",public"`
+	NewBalancesRoot frontend.Variable `gnark:",public"`
+	Amount          frontend.Variable `gnark:",public"`
+	LeavesHash      frontend.Variable `gnark:",public"`
+
+	// Private inputs
+	OldLeaf     BalanceLeaf
+	NewLeaf     BalanceLeaf
+	Index       frontend.Variable
+	LeafMP      utils.SparseMerkleProof
+	OldBalance  frontend.Variable
+	OldBlinding frontend.Variable
+}
+
+// NewDepositCircuit allocates a DepositCircuit for a tree of the given depth
+// and Paillier keys of the given bit size.
+func NewDepositCircuit(depth int, paillierBits int) DepositCircuit {
+	var circuit DepositCircuit
+	circuit.OldLeaf = newBalanceLeaf(paillierBits)
+	circuit.NewLeaf = newBalanceLeaf(paillierBits)
+	circuit.LeafMP.Siblings = make([]frontend.Variable, depth)
+
+	return circuit
+}
+
+func (circuit *DepositCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Domain.check(api)
+	api.AssertIsEqual(circuit.LeafMP.RootHash, circuit.OldBalancesRoot)
+	circuit.LeafMP.VerifyUpdate(api, &hFunc, circuit.OldLeaf.Hash(&hFunc), circuit.NewLeaf.Hash(&hFunc), circuit.Index, circuit.NewBalancesRoot)
+
+	// The old balance is opened to keep the new one from overflowing
+	curve, err := twistededwards.NewEdCurve(api, tedwards.BN254)
+	if err != nil {
+		return err
+	}
+	assertPointsEqual(api, commitBalance(curve, circuit.OldBalance, circuit.OldBlinding), circuit.OldLeaf.BalanceCommitment)
+
+	assertIsBalance(api, circuit.Amount)
+	assertIsBalance(api, circuit.OldBalance)
+	assertIsBalance(api, api.Add(circuit.OldBalance, circuit.Amount))
+
+	newCommitment := curve.Add(circuit.OldLeaf.BalanceCommitment, commitPublic(curve, circuit.Amount))
+	assertPointsEqual(api, newCommitment, circuit.NewLeaf.BalanceCommitment)
+
+	// The blinding is left unchanged, so the plaintext of the encrypted
+	// balance only gains the amount
+	encAmount := circuit.OldLeaf.PubKey.EncryptPublic(api, circuit.Amount)
+	newEncBalance := circuit.OldLeaf.PubKey.Add(api, circuit.OldLeaf.EncBalance, encAmount)
+	newEncBalance.AssertIsEqual(api, circuit.NewLeaf.EncBalance)
+
+	circuit.OldLeaf.assertSameOwner(api, circuit.NewLeaf)
+	api.AssertIsEqual(circuit.NewLeaf.Nonce, circuit.OldLeaf.Nonce)
+	api.AssertIsEqual(leavesHash(&hFunc, circuit.OldLeaf, circuit.NewLeaf), circuit.LeavesHash)
+
+	return nil
+}
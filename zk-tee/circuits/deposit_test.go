@@ -0,0 +1,100 @@
+package circuits
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// nativeAddPublic returns the commitment once amount is added to its balance,
+// with no blinding.
+func nativeAddPublic(commitment twistededwards.PointAffine, amount *big.Int) twistededwards.PointAffine {
+	curve := twistededwards.GetEdwardsCurve()
+	var amountCommitment twistededwards.PointAffine
+	amountCommitment.ScalarMultiplication(&curve.Base, amount)
+	return *commitment.Add(&commitment, &amountCommitment)
+}
+
+func generateDepositWitness(assert *test.Assert, depth int, index int, amount *big.Int) (DepositCircuit, DepositCircuit) {
+	tree, leaves, data := GenerateRandomTree(depth)
+
+	oldLeaf := leaves[index]
+	newLeaf := oldLeaf
+	newLeaf.EncBalance = new(big.Int).SetBytes(paillier.Add(&data[index].PubKey, oldLeaf.EncBalance.Bytes(), amount.Bytes()))
+	newLeaf.BalanceCommitment = nativeAddPublic(oldLeaf.BalanceCommitment, amount)
+
+	oldRoot := tree.MerkleRoot()
+	siblings, _, err := tree.GetMerklePathAt(index)
+	assert.NoError(err)
+	_, err = tree.UpdateLeafAt(index, newLeaf)
+	assert.NoError(err)
+
+	circuit := NewDepositCircuit(depth, testPaillierBits)
+
+	witness := DepositCircuit{
+		Domain:          testDomain,
+		OldBalancesRoot: oldRoot,
+		NewBalancesRoot: tree.MerkleRoot(),
+		Amount:          amount,
+		LeavesHash:      nativeLeavesHash(oldLeaf, newLeaf),
+		OldLeaf:         oldLeaf.circuitValue(),
+		NewLeaf:         newLeaf.circuitValue(),
+		Index:           index,
+		OldBalance:      data[index].Balance,
+		OldBlinding:     data[index].Blinding,
+	}
+	witness.LeafMP.RootHash = oldRoot
+	witness.LeafMP.Siblings = make([]frontend.Variable, len(siblings))
+	for i, sibling := range siblings {
+		witness.LeafMP.Siblings[i] = sibling
+	}
+
+	return circuit, witness
+}
+
+func TestDepositCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 3
+	circuit, witness := generateDepositWitness(assert, depth, 2, big.NewInt(1000))
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+
+	// The public amount must match the increase of the balance
+	witness.Amount = 1001
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	// The leaves are bound to their public hash
+	leavesHash := witness.LeavesHash
+	witness.Amount = 1000
+	witness.LeavesHash = 0
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+	witness.LeavesHash = leavesHash
+
+	// and the increase of the encrypted balance
+	_, other := generateDepositWitness(assert, depth, 2, big.NewInt(1000))
+	witness.Amount = 1000
+	witness.NewLeaf.EncBalance = other.NewLeaf.EncBalance
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestDepositCircuitOverflow(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// The amount is a valid balance, but adding it to a non-zero balance
+	// overflows
+	depth := 3
+	amount := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), utils.BalanceBits), big.NewInt(1))
+	circuit, witness := generateDepositWitness(assert, depth, 2, amount)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
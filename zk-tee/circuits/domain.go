@@ -0,0 +1,21 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Domain identifies the deployment a proof is for, so that it cannot be
+// submitted to another contract holding the same balances root, on the same
+// chain or another one. It leads the public inputs of every circuit.
+type Domain struct {
+	ChainID  frontend.Variable
+	Contract frontend.Variable
+}
+
+// check constrains the domain to a non-zero chain ID and a contract address.
+// A public input that appears in no constraint would not be bound to the
+// proof.
+func (d Domain) check(api frontend.API) {
+	api.AssertIsDifferent(d.ChainID, 0)
+	api.ToBinary(d.Contract, AddressBits)
+}
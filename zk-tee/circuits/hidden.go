@@ -0,0 +1,59 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+	gHash "github.com/consensys/gnark/std/hash"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// HiddenTransferCircuit proves the same transfer as PrivateCoinCircuit, but
+// keeps the leaves private so that the sender and the recipient do not show
+// on chain. Its public inputs are the domain, the balances roots and a
+// commitment to the new leaves, see transferCommitment.
+//
+// With utils.PaillierBits keys and a tree of depth 5, it compiles to 303,928
+// constraints.
+type HiddenTransferCircuit struct {
+	// Public inputs
+	Domain          Domain            `gnark:",public"`
+	OldBalancesRoot frontend.Variable `gnark:",public"`
+	NewBalancesRoot frontend.Variable `gnark:",public"`
+	Commitment      frontend.Variable `gnark:",public"`
+
+	// Private inputs
+	Transfer TransferStep
+}
+
+// NewHiddenTransferCircuit allocates a HiddenTransferCircuit for a tree of the
+// given depth and Paillier keys of the given bit size.
+func NewHiddenTransferCircuit(depth int, paillierBits int) HiddenTransferCircuit {
+	var circuit HiddenTransferCircuit
+	circuit.Transfer = newTransferStep(depth, paillierBits)
+
+	return circuit
+}
+
+// transferCommitment returns the hash of the fields of the new leaves of the
+// transfer. Both leaves hold freshly randomized ciphertexts and balance
+// commitments, which keeps the commitment from being matched against the known
+// leaves of the tree.
+func transferCommitment(hFunc gHash.FieldHasher, transfer TransferStep) frontend.Variable {
+	inputs := append(transfer.NewFromLeaf.fields(), transfer.NewToLeaf.fields()...)
+	return utils.HashInCircuit(hFunc, inputs...)
+}
+
+func (circuit *HiddenTransferCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Domain.check(api)
+	if err := circuit.Transfer.verify(api, &hFunc, circuit.Domain, circuit.OldBalancesRoot, circuit.NewBalancesRoot); err != nil {
+		return err
+	}
+	api.AssertIsEqual(transferCommitment(&hFunc, circuit.Transfer), circuit.Commitment)
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package circuits
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+)
+
+func TestHiddenTransferCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 3
+	tree, leaves, data := GenerateRandomTree(depth)
+	circuit := NewHiddenTransferCircuit(depth, testPaillierBits)
+
+	oldRoot := tree.MerkleRoot()
+	witness := HiddenTransferCircuit{
+		Domain:          testDomain,
+		OldBalancesRoot: oldRoot,
+		Transfer:        applyTestTransfer(assert, tree, leaves, data, 2, 0, big.NewInt(100)),
+		NewBalancesRoot: tree.MerkleRoot(),
+	}
+
+	witness.Commitment = nativeLeavesHash(leaves[2], leaves[0])
+
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+
+	// The new root cannot rewrite the leaves of other accounts
+	leaves[1].Nonce = big.NewInt(7)
+	_, err = tree.UpdateLeafAt(1, leaves[1])
+	assert.NoError(err)
+	witness.NewBalancesRoot = tree.MerkleRoot()
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	// The commitment binds the new leaves
+	leaves[1].Nonce = big.NewInt(0)
+	_, err = tree.UpdateLeafAt(1, leaves[1])
+	assert.NoError(err)
+	witness.NewBalancesRoot = tree.MerkleRoot()
+	witness.Commitment = oldRoot
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
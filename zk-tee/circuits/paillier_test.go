@@ -20,23 +20,38 @@ type TestDivModCircuit struct {
 }
 
 type TestPowModCircuit struct {
-	Base   frontend.Variable
-	Exp    frontend.Variable
-	Mod    frontend.Variable
-	Result frontend.Variable
+	Base   BigInt
+	Exp    BigInt
+	Mod    BigInt
+	Result BigInt
 }
 
 type TestPaillierEncryptionCircuit struct {
 	Message1  frontend.Variable
 	Message2  frontend.Variable
-	R1        frontend.Variable
-	R2        frontend.Variable
-	Cipher1   frontend.Variable
-	Cipher2   frontend.Variable
-	CipherSum frontend.Variable
+	R1        BigInt
+	R2        BigInt
+	Cipher1   BigInt
+	Cipher2   BigInt
+	CipherSum BigInt
 	PubKey    PaillierPubKey
 }
 
+type TestPaillierHomomorphicCircuit struct {
+	Cipher1      BigInt
+	Cipher2      BigInt
+	R            BigInt
+	Difference   BigInt
+	Negation     BigInt
+	Rerandomized BigInt
+	PubKey       PaillierPubKey
+}
+
+// testPaillierBits is the Paillier key size used by the circuit tests, small
+// enough to keep the emulated arithmetic fast but large enough to hold the
+// plaintexts of utils.BalancePlaintext.
+const testPaillierBits = 512
+
 func (circuit *TestDivModCircuit) Define(api frontend.API) error {
 	q, r := DivMod(api, circuit.Num, circuit.Mod)
 
@@ -48,20 +63,33 @@ func (circuit *TestDivModCircuit) Define(api frontend.API) error {
 
 func (circuit *TestPowModCircuit) Define(api frontend.API) error {
 	result := PowMod(api, circuit.Base, circuit.Exp, circuit.Mod)
-	api.AssertIsEqual(result, circuit.Result)
+	result.AssertIsEqual(api, circuit.Result)
 
 	return nil
 }
 
 func (circuit *TestPaillierEncryptionCircuit) Define(api frontend.API) error {
 	c1 := circuit.PubKey.Encrypt(api, circuit.Message1, circuit.R1)
-	api.AssertIsEqual(c1, circuit.Cipher1)
+	c1.AssertIsEqual(api, circuit.Cipher1)
 
 	c2 := circuit.PubKey.Encrypt(api, circuit.Message2, circuit.R2)
-	api.AssertIsEqual(c2, circuit.Cipher2)
+	c2.AssertIsEqual(api, circuit.Cipher2)
 
 	cSum := circuit.PubKey.Add(api, c1, c2)
-	api.AssertIsEqual(cSum, circuit.CipherSum)
+	cSum.AssertIsEqual(api, circuit.CipherSum)
+
+	return nil
+}
+
+func (circuit *TestPaillierHomomorphicCircuit) Define(api frontend.API) error {
+	diff := circuit.PubKey.SubCipher(api, circuit.Cipher1, circuit.Cipher2)
+	diff.AssertIsEqual(api, circuit.Difference)
+
+	neg := circuit.PubKey.Neg(api, circuit.Cipher2)
+	neg.AssertIsEqual(api, circuit.Negation)
+
+	fresh := circuit.PubKey.Rerandomize(api, circuit.Cipher1, circuit.R)
+	fresh.AssertIsEqual(api, circuit.Rerandomized)
 
 	return nil
 }
@@ -69,8 +97,8 @@ func (circuit *TestPaillierEncryptionCircuit) Define(api frontend.API) error {
 func TestDivMod(t *testing.T) {
 	assert := test.NewAssert(t)
 
-	num := utils.RandomBigInt(utils.PaillierBits)
-	mod := utils.RandomBigInt(utils.PaillierBits)
+	num := utils.RandomBigInt(utils.LimbBits)
+	mod := utils.RandomBigInt(utils.LimbBits)
 	quotient := new(big.Int).Quo(num, mod)
 	remainder := new(big.Int).Rem(num, mod)
 
@@ -93,19 +121,25 @@ func TestDivMod(t *testing.T) {
 func TestPowMod(t *testing.T) {
 	assert := test.NewAssert(t)
 
-	base := utils.RandomBigInt(utils.PaillierBits)
-	exp := utils.RandomBigInt(utils.PaillierBits)
-	mod := utils.RandomBigInt(utils.PaillierBits)
+	base := utils.RandomBigInt(testPaillierBits)
+	exp := utils.RandomBigInt(testPaillierBits)
+	mod := utils.RandomBigInt(testPaillierBits)
+	mod.SetBit(mod, testPaillierBits-1, 1)
 	result := new(big.Int).Exp(base, exp, mod)
 
 	testCase := func() {
 		// Create a new TestPowModCircuit instance with test values
-		circuit := &TestPowModCircuit{}
+		circuit := &TestPowModCircuit{
+			Base:   NewBigInt(testPaillierBits),
+			Exp:    NewBigInt(testPaillierBits),
+			Mod:    NewBigInt(testPaillierBits),
+			Result: NewBigInt(testPaillierBits),
+		}
 		witness := &TestPowModCircuit{
-			Base:   base,
-			Exp:    exp,
-			Mod:    mod,
-			Result: result,
+			Base:   BigIntValue(base, testPaillierBits),
+			Exp:    BigIntValue(exp, testPaillierBits),
+			Mod:    BigIntValue(mod, testPaillierBits),
+			Result: BigIntValue(result, testPaillierBits),
 		}
 
 		err := test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
@@ -117,7 +151,7 @@ func TestPowMod(t *testing.T) {
 func TestPaillierEncryption(t *testing.T) {
 	assert := test.NewAssert(t)
 
-	privKey, err := paillier.GenerateKey(rand.Reader, utils.PaillierBits)
+	privKey, err := paillier.GenerateKey(rand.Reader, testPaillierBits)
 	if err != nil {
 		t.Fatalf("Failed to generate private key: %v", err)
 	}
@@ -125,17 +159,17 @@ func TestPaillierEncryption(t *testing.T) {
 	n := privKey.PublicKey.N
 	g := privKey.PublicKey.G
 	pubKey := PaillierPubKey{
-		N: n,
-		G: g,
+		N: BigIntValue(n, testPaillierBits),
+		G: BigIntValue(g, testPaillierBits),
 	}
 
-	message1 := utils.RandomBigInt(utils.PaillierBits - 1)
+	message1 := utils.RandomBigInt(utils.BalanceBits)
 	cipher1, r1, err := paillier.Encrypt(&privKey.PublicKey, message1.Bytes())
 	if err != nil {
 		t.Fatalf("Failed to encrypt: %v", err)
 	}
 
-	message2 := utils.RandomBigInt(utils.PaillierBits - 1)
+	message2 := utils.RandomBigInt(utils.BalanceBits)
 	cipher2, r2, err := paillier.Encrypt(&privKey.PublicKey, message2.Bytes())
 	if err != nil {
 		t.Fatalf("Failed to encrypt: %v", err)
@@ -145,15 +179,22 @@ func TestPaillierEncryption(t *testing.T) {
 
 	testCase := func() {
 		// Create a new TestPowModCircuit instance with test values
-		circuit := &TestPaillierEncryptionCircuit{}
+		circuit := &TestPaillierEncryptionCircuit{
+			R1:        NewBigInt(testPaillierBits),
+			R2:        NewBigInt(testPaillierBits),
+			Cipher1:   NewPaillierCipher(testPaillierBits),
+			Cipher2:   NewPaillierCipher(testPaillierBits),
+			CipherSum: NewPaillierCipher(testPaillierBits),
+			PubKey:    NewPaillierPubKey(testPaillierBits),
+		}
 		witness := &TestPaillierEncryptionCircuit{
 			Message1:  message1,
 			Message2:  message2,
-			R1:        r1,
-			R2:        r2,
-			Cipher1:   cipher1,
-			Cipher2:   cipher2,
-			CipherSum: cipherSum,
+			R1:        BigIntValue(r1, testPaillierBits),
+			R2:        BigIntValue(r2, testPaillierBits),
+			Cipher1:   BigIntValue(new(big.Int).SetBytes(cipher1), 2*testPaillierBits),
+			Cipher2:   BigIntValue(new(big.Int).SetBytes(cipher2), 2*testPaillierBits),
+			CipherSum: BigIntValue(new(big.Int).SetBytes(cipherSum), 2*testPaillierBits),
 			PubKey:    pubKey,
 		}
 
@@ -162,3 +203,55 @@ func TestPaillierEncryption(t *testing.T) {
 	}
 	testCase()
 }
+
+func TestPaillierHomomorphic(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	privKey, err := paillier.GenerateKey(rand.Reader, testPaillierBits)
+	assert.NoError(err)
+	pubKey := &privKey.PublicKey
+
+	cipher1, _, err := paillier.Encrypt(pubKey, big.NewInt(35).Bytes())
+	assert.NoError(err)
+	cipher2, _, err := paillier.Encrypt(pubKey, big.NewInt(15).Bytes())
+	assert.NoError(err)
+	diff, err := paillier.SubCipher(pubKey, cipher1, cipher2)
+	assert.NoError(err)
+	neg, err := paillier.Neg(pubKey, cipher2)
+	assert.NoError(err)
+	fresh, r, err := paillier.Rerandomize(pubKey, cipher1)
+	assert.NoError(err)
+
+	cipherValue := func(c []byte) BigInt {
+		return BigIntValue(new(big.Int).SetBytes(c), 2*testPaillierBits)
+	}
+	circuit := &TestPaillierHomomorphicCircuit{
+		Cipher1:      NewPaillierCipher(testPaillierBits),
+		Cipher2:      NewPaillierCipher(testPaillierBits),
+		R:            NewBigInt(testPaillierBits),
+		Difference:   NewPaillierCipher(testPaillierBits),
+		Negation:     NewPaillierCipher(testPaillierBits),
+		Rerandomized: NewPaillierCipher(testPaillierBits),
+		PubKey:       NewPaillierPubKey(testPaillierBits),
+	}
+	witness := &TestPaillierHomomorphicCircuit{
+		Cipher1:      cipherValue(cipher1),
+		Cipher2:      cipherValue(cipher2),
+		R:            BigIntValue(r, testPaillierBits),
+		Difference:   cipherValue(diff),
+		Negation:     cipherValue(neg),
+		Rerandomized: cipherValue(fresh),
+		PubKey: PaillierPubKey{
+			N: BigIntValue(pubKey.N, testPaillierBits),
+			G: BigIntValue(pubKey.G, testPaillierBits),
+		},
+	}
+
+	err = test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+
+	// The difference is checked against the inverse of the second ciphertext
+	witness.Difference = cipherValue(paillier.AddCipher(pubKey, cipher1, cipher2))
+	err = test.IsSolved(circuit, witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
@@ -0,0 +1,70 @@
+package circuits
+
+import (
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// RegisterAccountCircuit proves that a previously empty leaf of the balances
+// tree was set to a new account, holding a commitment to a zero balance and a
+// zero nonce. The encrypted balance holds the opening of the commitment, see
+// utils.BalancePlaintext, which is not checked: only the owner of the account
+// can decrypt it, and the server hands the opening over to it otherwise.
+//
+// With utils.PaillierBits keys and a tree of depth 5, it compiles to 69,384
+// constraints.
+type RegisterAccountCircuit struct {
+	// Public inputs
+	Domain          Domain            `gnark:",public"`
+	OldBalancesRoot frontend.Variable `gnark:",public"`
+	NewBalancesRoot frontend.Variable `gnark:",public"`
+	Index           frontend.Variable `gnark:",public"`
+	NewLeaf         BalanceLeaf       `gnark:",public"`
+
+	// Private inputs
+	LeafMP   utils.SparseMerkleProof
+	Blinding frontend.Variable
+}
+
+// NewRegisterAccountCircuit allocates a RegisterAccountCircuit for a tree of
+// the given depth and Paillier keys of the given bit size.
+func NewRegisterAccountCircuit(depth int, paillierBits int) RegisterAccountCircuit {
+	var circuit RegisterAccountCircuit
+	circuit.NewLeaf = newBalanceLeaf(paillierBits)
+	circuit.LeafMP.Siblings = make([]frontend.Variable, depth)
+
+	return circuit
+}
+
+func (circuit *RegisterAccountCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Domain.check(api)
+
+	// The leaf was empty in the old tree and holds the new account in the
+	// new one, the rest of the tree being unchanged
+	api.AssertIsEqual(circuit.LeafMP.RootHash, circuit.OldBalancesRoot)
+	circuit.LeafMP.VerifyInsertion(api, &hFunc, circuit.NewLeaf.Hash(&hFunc), circuit.Index, circuit.NewBalancesRoot)
+
+	curve, err := twistededwards.NewEdCurve(api, tedwards.BN254)
+	if err != nil {
+		return err
+	}
+	assertPointsEqual(api, commitBalance(curve, 0, circuit.Blinding), circuit.NewLeaf.BalanceCommitment)
+
+	// The key must be usable to encrypt the balance
+	circuit.NewLeaf.PubKey.nSquared(api)
+
+	api.AssertIsEqual(circuit.NewLeaf.Nonce, 0)
+
+	// The spending key must be usable to verify signatures
+	curve.AssertIsOnCurve(circuit.NewLeaf.SpendingKey.A)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package circuits
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	cryptoEddsa "github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// generateRegisterWitness sets the leaf at index of a tree already holding
+// nbUsers accounts to a new account with a commitment to balance.
+func generateRegisterWitness(assert *test.Assert, depth int, nbUsers int, index int, balance int64) (RegisterAccountCircuit, RegisterAccountCircuit) {
+	tree, err := merkletree.NewSparseTree(depth)
+	assert.NoError(err)
+
+	_, leaves, _ := GenerateRandomTree(depth)
+	for i := 0; i < nbUsers; i++ {
+		_, err := tree.UpdateLeafAt(i, leaves[i])
+		assert.NoError(err)
+	}
+
+	keyPair, err := paillier.GenerateKey(rand.Reader, testPaillierBits)
+	assert.NoError(err)
+	spendingKey, err := cryptoEddsa.GenerateKey(rand.Reader)
+	assert.NoError(err)
+
+	blinding := randomBlinding()
+	encBalance := encryptBalance(&keyPair.PublicKey, big.NewInt(balance), blinding)
+	commitment := utils.Commit(big.NewInt(balance), blinding)
+
+	leaf := newTestLeaf(&keyPair.PublicKey, encBalance, commitment, spendingKey.PublicKey, big.NewInt(0))
+
+	oldRoot := tree.MerkleRoot()
+	siblings, _, err := tree.GetMerklePathAt(index)
+	assert.NoError(err)
+	_, err = tree.UpdateLeafAt(index, leaf)
+	assert.NoError(err)
+
+	circuit := NewRegisterAccountCircuit(depth, testPaillierBits)
+
+	witness := RegisterAccountCircuit{
+		Domain:          testDomain,
+		OldBalancesRoot: oldRoot,
+		NewBalancesRoot: tree.MerkleRoot(),
+		Index:           index,
+		NewLeaf:         leaf.circuitValue(),
+		Blinding:        blinding,
+	}
+	witness.LeafMP.RootHash = oldRoot
+	witness.LeafMP.Siblings = make([]frontend.Variable, depth)
+	for i, sibling := range siblings {
+		witness.LeafMP.Siblings[i] = sibling
+	}
+
+	return circuit, witness
+}
+
+func TestRegisterAccountCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 3
+	circuit, witness := generateRegisterWitness(assert, depth, 5, 5, 0)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+}
+
+func TestRegisterAccountCircuitOccupiedLeaf(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// Overwriting an existing account must fail
+	depth := 3
+	circuit, witness := generateRegisterWitness(assert, depth, 5, 4, 0)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestRegisterAccountCircuitNonZeroBalance(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// A new account cannot start with a balance
+	depth := 3
+	circuit, witness := generateRegisterWitness(assert, depth, 5, 5, 1)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
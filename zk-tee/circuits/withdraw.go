@@ -0,0 +1,104 @@
+package circuits
+
+import (
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/native/twistededwards"
+	gHash "github.com/consensys/gnark/std/hash"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/signature/eddsa"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// AddressBits is the bit size of the withdrawal address.
+const AddressBits = 160
+
+// withdrawMessage returns the message signed by the owner to authorize a
+// withdrawal: the hash of the domain, the old balances root, the amount, the
+// withdrawal address and the owner nonce.
+func withdrawMessage(hFunc gHash.FieldHasher, domain Domain, oldRoot, amount, recipient, nonce frontend.Variable) frontend.Variable {
+	return utils.HashInCircuit(hFunc, domain.ChainID, domain.Contract, oldRoot, amount, recipient, nonce)
+}
+
+// WithdrawCircuit proves that a public amount was taken out of the balance of
+// a leaf, to be paid by the contract to a public address. The amount is taken
+// out of both the balance commitment and the encrypted balance. The old and
+// new leaves are only public through the hash of their fields, LeavesHash, as
+// in PrivateCoinCircuit.
+//
+// With utils.PaillierBits keys and a tree of depth 5, it compiles to 321,184
+// constraints.
+type WithdrawCircuit struct {
+	// Public inputs
+	Domain          Domain            `gnark:",public"`
+	OldBalancesRoot frontend.Variable `gnark:",public"`
+	NewBalancesRoot frontend.Variable `gnark:",public"`
+	Amount          frontend.Variable `gnark:",public"`
+	Recipient       frontend.Variable `gnark:",public"`
+	LeavesHash      frontend.Variable `gnark:",public"`
+
+	// Private inputs
+	OldLeaf     BalanceLeaf
+	NewLeaf     BalanceLeaf
+	Index       frontend.Variable
+	LeafMP      utils.SparseMerkleProof
+	OldBalance  frontend.Variable
+	OldBlinding frontend.Variable
+	Signature   eddsa.Signature
+}
+
+// NewWithdrawCircuit allocates a WithdrawCircuit for a tree of the given depth
+// and Paillier keys of the given bit size.
+func NewWithdrawCircuit(depth int, paillierBits int) WithdrawCircuit {
+	var circuit WithdrawCircuit
+	circuit.OldLeaf = newBalanceLeaf(paillierBits)
+	circuit.NewLeaf = newBalanceLeaf(paillierBits)
+	circuit.LeafMP.Siblings = make([]frontend.Variable, depth)
+
+	return circuit
+}
+
+func (circuit *WithdrawCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Domain.check(api)
+	api.AssertIsEqual(circuit.LeafMP.RootHash, circuit.OldBalancesRoot)
+	circuit.LeafMP.VerifyUpdate(api, &hFunc, circuit.OldLeaf.Hash(&hFunc), circuit.NewLeaf.Hash(&hFunc), circuit.Index, circuit.NewBalancesRoot)
+
+	curve, err := twistededwards.NewEdCurve(api, tedwards.BN254)
+	if err != nil {
+		return err
+	}
+	assertPointsEqual(api, commitBalance(curve, circuit.OldBalance, circuit.OldBlinding), circuit.OldLeaf.BalanceCommitment)
+
+	// The new balance must be valid, which bounds the amount by the balance
+	assertIsBalance(api, circuit.Amount)
+	assertIsBalance(api, circuit.OldBalance)
+	assertIsBalance(api, api.Sub(circuit.OldBalance, circuit.Amount))
+
+	newCommitment := curve.Add(circuit.OldLeaf.BalanceCommitment, curve.Neg(commitPublic(curve, circuit.Amount)))
+	assertPointsEqual(api, newCommitment, circuit.NewLeaf.BalanceCommitment)
+
+	// The blinding is left unchanged, so the plaintext of the encrypted
+	// balance only loses the amount, which it holds
+	encAmount := circuit.OldLeaf.PubKey.EncryptPublic(api, circuit.Amount)
+	newEncBalance := circuit.OldLeaf.PubKey.SubCipher(api, circuit.OldLeaf.EncBalance, encAmount)
+	newEncBalance.AssertIsEqual(api, circuit.NewLeaf.EncBalance)
+
+	// The owner authorizes the withdrawal to the given address
+	api.ToBinary(circuit.Recipient, AddressBits)
+	msg := withdrawMessage(&hFunc, circuit.Domain, circuit.OldBalancesRoot, circuit.Amount, circuit.Recipient, circuit.OldLeaf.Nonce)
+	hFunc.Reset()
+	if err := eddsa.Verify(curve, circuit.Signature, msg, circuit.OldLeaf.SpendingKey, &hFunc); err != nil {
+		return err
+	}
+
+	circuit.OldLeaf.assertSameOwner(api, circuit.NewLeaf)
+	api.AssertIsEqual(circuit.NewLeaf.Nonce, api.Add(circuit.OldLeaf.Nonce, 1))
+	api.AssertIsEqual(leavesHash(&hFunc, circuit.OldLeaf, circuit.NewLeaf), circuit.LeavesHash)
+
+	return nil
+}
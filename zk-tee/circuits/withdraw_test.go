@@ -0,0 +1,123 @@
+package circuits
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark-crypto/hash"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// nativeWithdrawMessage mirrors the message signed by the owner of a withdrawal.
+func nativeWithdrawMessage(oldRoot []byte, amount, recipient, nonce *big.Int) []byte {
+	hfunc := hash.MIMC_BN254.New()
+	hfunc.Write(utils.Pad32Bytes(testChainID.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(testContract.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+	hfunc.Write(utils.Pad32Bytes(amount.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(recipient.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(nonce.Bytes()))
+	return hfunc.Sum(nil)
+}
+
+// nativeSubPublic returns the commitment once amount is taken out of its
+// balance, with no blinding.
+func nativeSubPublic(commitment twistededwards.PointAffine, amount *big.Int) twistededwards.PointAffine {
+	curve := twistededwards.GetEdwardsCurve()
+	var amountCommitment twistededwards.PointAffine
+	amountCommitment.ScalarMultiplication(&curve.Base, amount)
+	amountCommitment.Neg(&amountCommitment)
+	return *commitment.Add(&commitment, &amountCommitment)
+}
+
+func generateWithdrawWitness(assert *test.Assert, depth int, index int, amount *big.Int, recipient *big.Int) (WithdrawCircuit, WithdrawCircuit) {
+	tree, leaves, data := GenerateRandomTree(depth)
+	user := data[index]
+
+	oldRoot := tree.MerkleRoot()
+	oldLeaf := leaves[index]
+	proof := sparseProofAt(assert, tree, index)
+
+	encAmount, err := paillier.EncryptWithNonce(&user.PubKey, big.NewInt(1), amount.Bytes())
+	assert.NoError(err)
+	encNewBalance, err := paillier.SubCipher(&user.PubKey, oldLeaf.EncBalance.Bytes(), encAmount.Bytes())
+	assert.NoError(err)
+
+	newLeaf := oldLeaf
+	newLeaf.EncBalance = new(big.Int).SetBytes(encNewBalance)
+	newLeaf.BalanceCommitment = nativeSubPublic(oldLeaf.BalanceCommitment, amount)
+	newLeaf.Nonce = new(big.Int).Add(oldLeaf.Nonce, big.NewInt(1))
+	_, err = tree.UpdateLeafAt(index, newLeaf)
+	assert.NoError(err)
+
+	sig, err := user.SpendingKey.Sign(nativeWithdrawMessage(oldRoot, amount, recipient, oldLeaf.Nonce), hash.MIMC_BN254.New())
+	assert.NoError(err)
+
+	circuit := NewWithdrawCircuit(depth, testPaillierBits)
+
+	witness := WithdrawCircuit{
+		Domain:          testDomain,
+		OldBalancesRoot: oldRoot,
+		NewBalancesRoot: tree.MerkleRoot(),
+		Amount:          amount,
+		Recipient:       recipient,
+		LeavesHash:      nativeLeavesHash(oldLeaf, newLeaf),
+		OldLeaf:         oldLeaf.circuitValue(),
+		NewLeaf:         newLeaf.circuitValue(),
+		Index:           index,
+		LeafMP:          proof,
+		OldBalance:      user.Balance,
+		OldBlinding:     user.Blinding,
+	}
+	witness.Signature.Assign(tedwards.BN254, sig)
+
+	return circuit, witness
+}
+
+func TestWithdrawCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 3
+	recipient, _ := new(big.Int).SetString("d8dA6BF26964aF9D7eEd9e03E53415D37aA96045", 16)
+	circuit, witness := generateWithdrawWitness(assert, depth, 3, big.NewInt(5), recipient)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.NoError(err)
+
+	// The signature binds the withdrawal address
+	witness.Recipient = new(big.Int).Add(recipient, big.NewInt(1))
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+
+	// The leaves are bound to their public hash
+	witness.Recipient = recipient
+	witness.LeavesHash = 0
+	err = test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestWithdrawCircuitExceedsBalance(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// The balances of GenerateRandomTree are below 2^63
+	depth := 3
+	amount := new(big.Int).Lsh(big.NewInt(1), 63)
+	circuit, witness := generateWithdrawWitness(assert, depth, 3, amount, big.NewInt(1))
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
+
+func TestWithdrawCircuitRecipientRange(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// A signed recipient above 160 bits is not an address
+	depth := 3
+	recipient := new(big.Int).Lsh(big.NewInt(1), AddressBits)
+	circuit, witness := generateWithdrawWitness(assert, depth, 3, big.NewInt(5), recipient)
+	err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField())
+	assert.Error(err)
+}
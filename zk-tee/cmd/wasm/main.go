@@ -0,0 +1,287 @@
+//go:build js && wasm
+
+// Command wasm exposes the client side of the protocol to JavaScript, so that
+// the frontend generates keys, decrypts balances and proves transfers without
+// sending its secrets to the server. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o secret_spend.wasm ./cmd/wasm
+//
+// and run it with the wasm_exec.js of the Go distribution. The functions are
+// set on the global secretSpend object and all return promises:
+//
+//	generateKeys() -> {n, p, q, spendingKey, spendingPrivKey}
+//	decryptBalance({p, q}, encBalance) -> {balance, blinding}
+//	loadProver(r1cs, pk, vk) -> undefined
+//	proveTransfer(serverURL, {chainId, contract}, {index, p, q, spendingPrivKey}, toIndex, amount) -> {proof, inputs}
+//
+// Integers are decimal strings, and keys and the 0x-prefixed contract address
+// are hex strings. loadProver takes the Uint8Array contents of the
+// exports/custody_transfer.* files. proveTransfer submits the transfer it
+// proves to the server, which applies it once the proof verifies against its
+// state, and returns the proof data to settle it on the contract.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"syscall/js"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/shreyas-londhe/private-erc20-circuits/db"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+	"github.com/shreyas-londhe/private-erc20-circuits/wallet"
+)
+
+// prover is the prover of the client custody transfer circuit, set by
+// loadProver.
+var prover *db.Prover
+
+// httpClient sends the requests of the wallets to the server, through the
+// fetch API of the browser.
+var httpClient = http.DefaultClient
+
+func main() {
+	register()
+
+	// The exported functions run as long as the program does
+	select {}
+}
+
+// register sets the exported functions on the global secretSpend object.
+func register() {
+	api := map[string]interface{}{
+		"generateKeys":   js.FuncOf(generateKeys),
+		"decryptBalance": js.FuncOf(decryptBalance),
+		"loadProver":     js.FuncOf(loadProver),
+		"proveTransfer":  js.FuncOf(proveTransfer),
+	}
+	js.Global().Set("secretSpend", js.ValueOf(api))
+}
+
+func generateKeys(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		keyPair, err := paillier.GenerateKey(rand.Reader, utils.PaillierBits)
+		if err != nil {
+			return nil, err
+		}
+		spendingKey, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		p, q := keyPair.Primes()
+		return map[string]interface{}{
+			"n":               keyPair.N.String(),
+			"p":               p.String(),
+			"q":               q.String(),
+			"spendingKey":     hex.EncodeToString(spendingKey.PublicKey.Bytes()),
+			"spendingPrivKey": hex.EncodeToString(spendingKey.Bytes()),
+		}, nil
+	})
+}
+
+func decryptBalance(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		if len(args) != 2 {
+			return nil, errors.New("decryptBalance takes the keys and the encrypted balance")
+		}
+		keyPair, err := paillierKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		encBalance, err := intArg(args[1], "encBalance")
+		if err != nil {
+			return nil, err
+		}
+
+		opening, err := db.DecryptOpening(keyPair, encBalance)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"balance":  opening.Balance.String(),
+			"blinding": opening.Blinding.String(),
+		}, nil
+	})
+}
+
+func loadProver(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		if len(args) != 3 {
+			return nil, errors.New("loadProver takes the circuit, the proving key and the verifying key")
+		}
+
+		var files [3]*bytes.Reader
+		for i, arg := range args {
+			if !arg.InstanceOf(js.Global().Get("Uint8Array")) {
+				return nil, errors.New("loadProver takes Uint8Arrays")
+			}
+			data := make([]byte, arg.Length())
+			js.CopyBytesToGo(data, arg)
+			files[i] = bytes.NewReader(data)
+		}
+
+		p, err := db.ReadProver(files[0], files[1], files[2])
+		if err != nil {
+			return nil, err
+		}
+		prover = p
+
+		return js.Undefined(), nil
+	})
+}
+
+func proveTransfer(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		if len(args) != 5 {
+			return nil, errors.New("proveTransfer takes the server URL, the domain, the keys, the recipient index and the amount")
+		}
+		if prover == nil {
+			return nil, errors.New("no prover loaded, see loadProver")
+		}
+
+		domain, err := domainArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		keys := args[2]
+		if keys.Type() != js.TypeObject || keys.Get("index").Type() != js.TypeNumber {
+			return nil, errors.New("invalid index")
+		}
+		keyPair, err := paillierKey(keys)
+		if err != nil {
+			return nil, err
+		}
+		spendingKey, err := spendingKeyArg(keys.Get("spendingPrivKey"))
+		if err != nil {
+			return nil, err
+		}
+		if args[3].Type() != js.TypeNumber {
+			return nil, errors.New("invalid toIndex")
+		}
+		amount, err := intArg(args[4], "amount")
+		if err != nil {
+			return nil, err
+		}
+
+		client := wallet.NewClient(args[0].String())
+		client.HTTPClient = httpClient
+		w := wallet.New(client, prover, domain, keys.Get("index").Int(), keyPair, spendingKey)
+		proofData, err := w.SubmitTransfer(args[3].Int(), amount)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"proof":  stringSlice(proofData.Proof),
+			"inputs": stringSlice(proofData.Inputs),
+		}, nil
+	})
+}
+
+// promise returns a promise of the result of f. f runs in a goroutine, since
+// blocking calls such as HTTP requests would otherwise deadlock the event
+// loop.
+func promise(f func() (interface{}, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			defer func() {
+				if r := recover(); r != nil {
+					reject.Invoke(js.Global().Get("Error").New(fmt.Sprint(r)))
+				}
+			}()
+
+			result, err := f()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+// paillierKey returns the Paillier private key of the p and q properties of
+// the keys.
+func paillierKey(keys js.Value) (*paillier.PrivateKey, error) {
+	if keys.Type() != js.TypeObject {
+		return nil, errors.New("invalid keys")
+	}
+	p, err := intArg(keys.Get("p"), "p")
+	if err != nil {
+		return nil, err
+	}
+	q, err := intArg(keys.Get("q"), "q")
+	if err != nil {
+		return nil, err
+	}
+	return paillier.NewPrivateKey(p, q), nil
+}
+
+// domainArg returns the domain of the chainId and contract properties of v.
+func domainArg(v js.Value) (db.Domain, error) {
+	if v.Type() != js.TypeObject {
+		return db.Domain{}, errors.New("invalid domain")
+	}
+	chainID, err := intArg(v.Get("chainId"), "chainId")
+	if err != nil {
+		return db.Domain{}, err
+	}
+	if v.Get("contract").Type() != js.TypeString {
+		return db.Domain{}, errors.New("invalid contract")
+	}
+	contract, err := hex.DecodeString(strings.TrimPrefix(v.Get("contract").String(), "0x"))
+	if err != nil || len(contract) != 20 {
+		return db.Domain{}, errors.New("invalid contract")
+	}
+	return db.Domain{ChainID: chainID, Contract: new(big.Int).SetBytes(contract)}, nil
+}
+
+func spendingKeyArg(v js.Value) (*eddsa.PrivateKey, error) {
+	if v.Type() != js.TypeString {
+		return nil, errors.New("invalid spendingPrivKey")
+	}
+	privKey, err := hex.DecodeString(v.String())
+	if err != nil {
+		return nil, errors.New("invalid spendingPrivKey")
+	}
+	spendingKey := new(eddsa.PrivateKey)
+	if _, err := spendingKey.SetBytes(privKey); err != nil {
+		return nil, errors.New("invalid spendingPrivKey")
+	}
+	return spendingKey, nil
+}
+
+// intArg parses the decimal string v.
+func intArg(v js.Value, name string) (*big.Int, error) {
+	if v.Type() != js.TypeString {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	x, ok := new(big.Int).SetString(v.String(), 10)
+	if !ok || x.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return x, nil
+}
+
+func stringSlice(s []string) []interface{} {
+	values := make([]interface{}, len(s))
+	for i, v := range s {
+		values[i] = v
+	}
+	return values
+}
@@ -0,0 +1,308 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"syscall/js"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/shreyas-londhe/private-erc20-circuits/db"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// These tests run in Node, with
+//
+//	PATH="$PATH:$(go env GOROOT)/lib/wasm" GOOS=js GOARCH=wasm go test ./cmd/wasm
+
+// testDepth is the depth of the tree of the server, which the keys in
+// exportsDir are set up for.
+const testDepth = 5
+
+// exportsDir holds the keys the server writes when run with -client-custody
+// -setup, see db.LoadProvers.
+const exportsDir = "../../exports/"
+
+var testDomain = map[string]interface{}{"chainId": "534351", "contract": "0x9AB81C32e1D621404b253c7fE0fC9972d1645E69"}
+
+func init() {
+	register()
+}
+
+// call calls the exported function and waits for its promise to settle.
+func call(name string, args ...interface{}) (js.Value, error) {
+	done := make(chan struct{})
+	var result js.Value
+	var err error
+
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		result = args[0]
+		close(done)
+		return nil
+	})
+	defer onResolve.Release()
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		err = errors.New(args[0].Get("message").String())
+		close(done)
+		return nil
+	})
+	defer onReject.Release()
+
+	js.Global().Get("secretSpend").Call(name, args...).Call("then", onResolve, onReject)
+	<-done
+
+	return result, err
+}
+
+func TestDecryptBalance(t *testing.T) {
+	keys, err := call("generateKeys")
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	n, _ := new(big.Int).SetString(keys.Get("n").String(), 10)
+	if n.BitLen() == 0 || keys.Get("spendingPrivKey").String() == "" {
+		t.Fatalf("Generated keys are incomplete")
+	}
+
+	blinding, err := utils.RandomBlinding()
+	if err != nil {
+		t.Fatalf("Failed to draw blinding: %v", err)
+	}
+	encBalance, err := db.EncryptBalance(paillier.NewPublicKey(n), big.NewInt(42), blinding)
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	opening, err := call("decryptBalance", keys, encBalance.String())
+	if err != nil {
+		t.Fatalf("Failed to decrypt the balance: %v", err)
+	}
+	if balance := opening.Get("balance").String(); balance != "42" {
+		t.Errorf("Decrypted balance %s, want 42", balance)
+	}
+	if got := opening.Get("blinding").String(); got != blinding.String() {
+		t.Errorf("Decrypted blinding %s, want %s", got, blinding)
+	}
+
+	// Arguments are checked
+	if _, err := call("decryptBalance", keys, 42); err == nil {
+		t.Errorf("Decrypting a number should fail")
+	}
+	if _, err := call("decryptBalance", map[string]interface{}{"p": "1"}, "42"); err == nil {
+		t.Errorf("Decrypting with incomplete keys should fail")
+	}
+}
+
+func TestProveTransferWithoutProver(t *testing.T) {
+	if _, err := call("loadProver", "r1cs", "pk", "vk"); err == nil {
+		t.Errorf("Loading a prover from strings should fail")
+	}
+	empty := js.Global().Get("Uint8Array").New(0)
+	if _, err := call("loadProver", empty, empty, empty); err == nil {
+		t.Errorf("Loading an empty prover should fail")
+	}
+
+	keys := map[string]interface{}{"index": 0, "p": "5", "q": "7", "spendingPrivKey": ""}
+	_, err := call("proveTransfer", "http://localhost:8080", testDomain, keys, 1, "10")
+	if err == nil || err.Error() != "no prover loaded, see loadProver" {
+		t.Errorf("Proving without a prover returned %v", err)
+	}
+}
+
+// TestProveTransfer proves a transfer with the keys of the server, and checks
+// that the server applies it once the proof verifies. Proving takes a few
+// minutes under Node.
+func TestProveTransfer(t *testing.T) {
+	files := make([]interface{}, 3)
+	for i, ext := range []string{"r1cs", "pk", "vk"} {
+		path := exportsDir + db.TransferCircuitName(true) + "." + ext
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			t.Skipf("No %s, run the server with -client-custody -setup to write it", path)
+		}
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		files[i] = js.Global().Get("Uint8Array").New(len(data))
+		js.CopyBytesToJS(files[i].(js.Value), data)
+	}
+	if _, err := call("loadProver", files...); err != nil {
+		t.Fatalf("Failed to load the prover: %v", err)
+	}
+	t.Cleanup(func() { prover = nil })
+
+	database, keys := newTestDB(t)
+	httpClient = &http.Client{Transport: handlerTransport{newTestServer(database)}}
+	t.Cleanup(func() { httpClient = http.DefaultClient })
+	oldRoot := database.GetMerkleRoot()
+
+	proofData, err := call("proveTransfer", "http://localhost:8080", testDomain, keys[0], 1, "30")
+	if err != nil {
+		t.Fatalf("Failed to prove the transfer: %v", err)
+	}
+	inputs := proofData.Get("inputs")
+	if proofData.Get("proof").Length() != 8 || inputs.Length() != 5 {
+		t.Fatalf("Proof data has %d proof elements and %d inputs, want 8 and 5", proofData.Get("proof").Length(), inputs.Length())
+	}
+	if got, want := inputs.Index(2).String(), fmt.Sprintf("0x%x", new(big.Int).SetBytes(oldRoot)); got != want {
+		t.Errorf("Old root input is %s, want %s", got, want)
+	}
+	if got, want := inputs.Index(3).String(), fmt.Sprintf("0x%x", new(big.Int).SetBytes(database.GetMerkleRoot())); got != want {
+		t.Errorf("New root input is %s, want the root of the server %s", got, want)
+	}
+
+	for i, want := range []string{"70", "130"} {
+		opening, err := call("decryptBalance", keys[i], database.GetUser(i).EncBalance.String())
+		if err != nil {
+			t.Fatalf("Failed to decrypt the balance of account %d: %v", i, err)
+		}
+		if got := opening.Get("balance").String(); got != want {
+			t.Errorf("Balance of account %d is %s, want %s", i, got, want)
+		}
+	}
+}
+
+// newTestDB returns a DB in client custody of accounts holding 100 each,
+// along with their keys in the format of generateKeys. Small keys keep the
+// witness fast, see utils.BalancePlaintext.
+func newTestDB(t *testing.T) (*db.DB, []map[string]interface{}) {
+	t.Helper()
+	database, err := db.New(testDepth, nil)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	chainID, _ := new(big.Int).SetString(testDomain["chainId"].(string), 10)
+	contract, _ := hex.DecodeString(testDomain["contract"].(string)[2:])
+	database.Domain = db.Domain{ChainID: chainID, Contract: new(big.Int).SetBytes(contract)}
+
+	var users []db.UserData
+	var keys []map[string]interface{}
+	for i := 0; i < 2; i++ {
+		keyPair, err := paillier.GenerateKey(rand.Reader, 512)
+		if err != nil {
+			t.Fatalf("Failed to generate Paillier key: %v", err)
+		}
+		spendingKey, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate spending key: %v", err)
+		}
+		blinding, err := utils.RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to draw blinding: %v", err)
+		}
+		encBalance, err := db.EncryptBalance(&keyPair.PublicKey, big.NewInt(100), blinding)
+		if err != nil {
+			t.Fatalf("Failed to encrypt balance: %v", err)
+		}
+		users = append(users, db.UserData{
+			KeyPair:           keyPair,
+			SpendingKey:       spendingKey,
+			Nonce:             big.NewInt(0),
+			EncBalance:        encBalance,
+			BalanceCommitment: utils.Commit(big.NewInt(100), blinding),
+		})
+
+		p, q := keyPair.Primes()
+		keys = append(keys, map[string]interface{}{
+			"index":           i,
+			"p":               p.String(),
+			"q":               q.String(),
+			"spendingPrivKey": hex.EncodeToString(spendingKey.Bytes()),
+		})
+	}
+	if err := database.AddUsers(users); err != nil {
+		t.Fatalf("Failed to add users: %v", err)
+	}
+	database.ClientCustody = true
+
+	return database, keys
+}
+
+// handlerTransport serves the requests of the wallets with a handler, since
+// no server listens under js/wasm.
+type handlerTransport struct {
+	http.Handler
+}
+
+func (h handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Result(), nil
+}
+
+// newTestServer serves the endpoints the wallets read from the DB. Submitted
+// transfers are verified by the loaded prover.
+func newTestServer(database *db.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get-user", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		user := database.GetUser(index)
+		json.NewEncoder(w).Encode(struct {
+			KeyPair           db.PublicKeyResponse `json:"keyPair"`
+			SpendingKey       string               `json:"spendingKey"`
+			Nonce             string               `json:"nonce"`
+			EncBalance        string               `json:"encBalance"`
+			BalanceCommitment string               `json:"balanceCommitment"`
+		}{
+			KeyPair:           db.NewPublicKeyResponse(&user.KeyPair.PublicKey),
+			SpendingKey:       hex.EncodeToString(user.SpendingKey.PublicKey.Bytes()),
+			Nonce:             user.Nonce.String(),
+			EncBalance:        user.EncBalance.String(),
+			BalanceCommitment: db.EncodePoint(user.BalanceCommitment),
+		})
+	})
+	mux.HandleFunc("/get-merkle-path", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		root, siblings, err := database.GetMerklePath(index)
+		if err != nil {
+			http.Error(w, "Invalid index", http.StatusBadRequest)
+			return
+		}
+		resp := struct {
+			Root     string   `json:"root"`
+			Siblings []string `json:"siblings"`
+		}{Root: hex.EncodeToString(root)}
+		for _, sibling := range siblings {
+			resp.Siblings = append(resp.Siblings, hex.EncodeToString(sibling))
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/submit-transfer", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		fromIndex, _ := strconv.Atoi(query.Get("fromIndex"))
+		toIndex, _ := strconv.Atoi(query.Get("toIndex"))
+		newFromEncBalance, _ := new(big.Int).SetString(query.Get("newFromEncBalance"), 10)
+		newFromCommitment, _ := db.DecodePoint(query.Get("newFromCommitment"))
+		amount, _ := new(big.Int).SetString(query.Get("amount"), 10)
+		amountBlinding, _ := new(big.Int).SetString(query.Get("amountBlinding"), 10)
+		encAmountR, _ := new(big.Int).SetString(query.Get("encAmountR"), 10)
+		proof, _ := hex.DecodeString(query.Get("proof"))
+		proofData, err := database.ApplyProvenTransfer(db.ProvenTransfer{
+			FromIndex:         fromIndex,
+			ToIndex:           toIndex,
+			NewFromEncBalance: newFromEncBalance,
+			NewFromCommitment: newFromCommitment,
+			Amount:            amount,
+			AmountBlinding:    amountBlinding,
+			EncAmountR:        encAmountR,
+			Proof:             proof,
+		}, prover.Verify)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(proofData)
+	})
+	return mux
+}
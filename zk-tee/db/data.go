@@ -5,28 +5,32 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"math/bits"
 	"os"
 
-	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
 	"github.com/consensys/gnark-crypto/hash"
-	"github.com/consensys/gnark/backend"
 	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/constraint"
-	"github.com/consensys/gnark/constraint/solver"
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
+	gEdwards "github.com/consensys/gnark/std/algebra/native/twistededwards"
 	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
-	"github.com/shreyas-londhe/private-erc20-circuits/hints"
 	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
 	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
 	"github.com/shreyas-londhe/private-erc20-circuits/utils"
 )
 
+// BalanceLeaf is the leaf of an account, see circuits.BalanceLeaf.
 type BalanceLeaf struct {
-	PubKey     PaillierPubKey
-	EncBalance *big.Int
+	PubKey            PaillierPubKey
+	EncBalance        *big.Int
+	BalanceCommitment twistededwards.PointAffine
+	SpendingKey       eddsa.PublicKey
+	Nonce             *big.Int
 }
 
 type PaillierPubKey struct {
@@ -39,12 +43,261 @@ type Groth16ProofData struct {
 	Inputs []string `json:"inputs"`
 }
 
+// Domain is the deployment proofs are generated for: the chain ID and the
+// address of the verifying contract. It is signed in every intent and leads
+// the public inputs of every proof, so that neither can be replayed against
+// another deployment.
+type Domain struct {
+	ChainID  *big.Int
+	Contract *big.Int
+}
+
+// Fields returns the domain as the field elements leading the public inputs.
+func (d Domain) Fields() []*big.Int {
+	return []*big.Int{d.ChainID, d.Contract}
+}
+
+// CircuitValue returns the assignment of the domain in the circuit witness.
+func (d Domain) CircuitValue() circuits.Domain {
+	return circuits.Domain{ChainID: d.ChainID, Contract: d.Contract}
+}
+
+// valid reports whether the domain can be proven, see circuits.Domain.
+func (d Domain) valid() bool {
+	return d.ChainID != nil && d.ChainID.Sign() > 0 && d.Contract != nil && d.Contract.Sign() >= 0 && d.Contract.BitLen() <= circuits.AddressBits
+}
+
+// ErrStaleRoot is returned for an intent signed against a balances root that
+// is no longer the current one, and for a transition proven against a root
+// that another transition replaced in the meantime.
+var ErrStaleRoot = errors.New("stale balances root")
+
+var errInvalidDomain = errors.New("invalid domain: the chain ID must be positive and the contract an address")
+
+// TransferIntent is a transfer authorized by its sender. The sender commits
+// to the amount under a blinding of its choice and signs the commitment
+// together with the state it applies to, see TransferMessage. The blinding is
+// added to the one of the recipient, which recovers it by decrypting its new
+// balance.
+type TransferIntent struct {
+	// OldRoot is the balances root the intent was signed against. When set,
+	// the intent is rejected with ErrStaleRoot once the root has changed.
+	OldRoot        []byte
+	FromIndex      int
+	ToIndex        int
+	Amount         *big.Int
+	AmountBlinding *big.Int
+	Nonce          *big.Int
+	Signature      []byte
+	// FromOpening opens the sender balance when the sender account is in
+	// client custody.
+	FromOpening *Opening
+}
+
+// WithdrawIntent is a withdrawal authorized by the owner of the account. The
+// owner signs the amount and the address the contract pays it to, together
+// with the state it applies to, see WithdrawMessage.
+type WithdrawIntent struct {
+	// OldRoot is the balances root the intent was signed against. When set,
+	// the intent is rejected with ErrStaleRoot once the root has changed.
+	OldRoot   []byte
+	Index     int
+	Amount    *big.Int
+	Recipient *big.Int
+	Nonce     *big.Int
+	Signature []byte
+	// Opening opens the balance when the account is in client custody.
+	Opening *Opening
+}
+
+// Opening is the balance and the blinding of the balance commitment of an
+// account in client custody, which the server does not keep and its owner
+// supplies to spend from it. The owner recovers them by decrypting its
+// encrypted balance, see DecryptOpening.
+type Opening struct {
+	Balance  *big.Int
+	Blinding *big.Int
+}
+
+// DecryptOpening returns the opening encrypted by the encrypted balance of an
+// account, see utils.BalancePlaintext.
+func DecryptOpening(keyPair *paillier.PrivateKey, encBalance *big.Int) (Opening, error) {
+	plaintext, err := paillier.Decrypt(keyPair, encBalance.Bytes())
+	if err != nil {
+		return Opening{}, err
+	}
+	balance, blinding := utils.OpenPlaintext(new(big.Int).SetBytes(plaintext))
+	return Opening{Balance: balance, Blinding: blinding}, nil
+}
+
+// EncryptBalance returns a fresh encryption of the opening of a balance
+// commitment under the key, see utils.BalancePlaintext.
+func EncryptBalance(pubKey *paillier.PublicKey, balance *big.Int, blinding *big.Int) (*big.Int, error) {
+	encBalance, _, err := paillier.Encrypt(pubKey, utils.BalancePlaintext(balance, blinding).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(encBalance), nil
+}
+
+// openAccount returns the user with the balance and blinding of the opening,
+// which must open its balance commitment. Users whose balance is kept by the
+// server are returned as is.
+func openAccount(user UserData, opening *Opening) (UserData, error) {
+	if user.Balance != nil {
+		return user, nil
+	}
+	if opening == nil || opening.Balance == nil || opening.Blinding == nil {
+		return UserData{}, fmt.Errorf("account %d is in client custody and needs an opening of its balance", user.Index)
+	}
+	if opening.Balance.Sign() < 0 || opening.Balance.BitLen() > utils.BalanceBits || opening.Blinding.Sign() < 0 {
+		return UserData{}, errors.New("invalid opening")
+	}
+
+	commitment := utils.Commit(opening.Balance, opening.Blinding)
+	if !commitment.Equal(&user.BalanceCommitment) {
+		return UserData{}, errors.New("opening does not match the balance commitment")
+	}
+
+	user.Balance, user.Blinding = opening.Balance, opening.Blinding
+	return user, nil
+}
+
+// withoutOpening drops the balance and blinding of the user when the account
+// is in client custody, as the original user was.
+func withoutOpening(user UserData, original UserData) UserData {
+	if original.Balance == nil {
+		user.Balance, user.Blinding = nil, nil
+	}
+	return user
+}
+
+// spend returns the user once amount is taken out of its opened balance. The
+// balance left is committed to under a fresh blinding, and encrypted with
+// fresh randomness, so that the new leaf cannot be linked to the old one.
+func spend(user UserData, amount *big.Int) (UserData, error) {
+	blinding, err := utils.RandomBlinding()
+	if err != nil {
+		return UserData{}, err
+	}
+	balance := new(big.Int).Sub(user.Balance, amount)
+	encBalance, err := EncryptBalance(&user.KeyPair.PublicKey, balance, blinding)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	user.Balance, user.Blinding = balance, blinding
+	user.EncBalance = encBalance
+	user.BalanceCommitment = utils.Commit(balance, blinding)
+	return user, nil
+}
+
+// ReceiveLeaf returns the leaf once it receives amount, committed to under
+// blinding: the commitment to the amount is added to the balance commitment,
+// and the encryption of amount and blinding with randomness r to the
+// encrypted balance, see utils.BalancePlaintext. This is the recipient leaf
+// of a transfer.
+func ReceiveLeaf(leaf BalanceLeaf, amount *big.Int, blinding *big.Int, r *big.Int) (BalanceLeaf, error) {
+	pubKey := paillier.NewPublicKey(leaf.PubKey.N)
+	encAmount, err := paillier.EncryptWithNonce(pubKey, r, utils.BalancePlaintext(amount, blinding).Bytes())
+	if err != nil {
+		return BalanceLeaf{}, err
+	}
+	amountCommitment := utils.Commit(amount, blinding)
+
+	leaf.EncBalance = new(big.Int).SetBytes(paillier.AddCipher(pubKey, leaf.EncBalance.Bytes(), encAmount.Bytes()))
+	leaf.BalanceCommitment.Add(&leaf.BalanceCommitment, &amountCommitment)
+	return leaf, nil
+}
+
+// receive returns the user once it receives amount, see ReceiveLeaf. The
+// balance and blinding are only updated when the server keeps them.
+func receive(user UserData, amount *big.Int, blinding *big.Int, r *big.Int) (UserData, error) {
+	leaf, err := ReceiveLeaf(convertToLeaf(user), amount, blinding, r)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	user.EncBalance, user.BalanceCommitment = leaf.EncBalance, leaf.BalanceCommitment
+	if user.Balance != nil {
+		curve := twistededwards.GetEdwardsCurve()
+		user.Balance = new(big.Int).Add(user.Balance, amount)
+		user.Blinding = new(big.Int).Add(user.Blinding, blinding)
+		user.Blinding.Mod(user.Blinding, &curve.Order)
+	}
+	return user, nil
+}
+
+// addPublic returns the commitment once amount is added to its balance, with
+// no blinding. A negative amount is taken out of it.
+func addPublic(commitment twistededwards.PointAffine, amount *big.Int) twistededwards.PointAffine {
+	curve := twistededwards.GetEdwardsCurve()
+	var amountCommitment twistededwards.PointAffine
+	amountCommitment.ScalarMultiplication(&curve.Base, new(big.Int).Abs(amount))
+	if amount.Sign() < 0 {
+		amountCommitment.Neg(&amountCommitment)
+	}
+	return *commitment.Add(&commitment, &amountCommitment)
+}
+
+// Fields returns the leaf fields as field elements, in the order in which
+// they are hashed and exposed as public inputs by the circuit.
+func (t BalanceLeaf) Fields() []*big.Int {
+	var fields []*big.Int
+	fields = append(fields, utils.ToLimbs(t.PubKey.N, utils.NbLimbs(utils.PaillierBits))...)
+	fields = append(fields, utils.ToLimbs(t.PubKey.G, utils.NbLimbs(utils.PaillierBits))...)
+	fields = append(fields, utils.ToLimbs(t.EncBalance, utils.NbLimbs(2*utils.PaillierBits))...)
+	fields = append(fields, t.BalanceCommitment.X.BigInt(new(big.Int)), t.BalanceCommitment.Y.BigInt(new(big.Int)))
+	fields = append(fields, t.SpendingKey.A.X.BigInt(new(big.Int)), t.SpendingKey.A.Y.BigInt(new(big.Int)), t.Nonce)
+	return fields
+}
+
+// CircuitValue returns the assignment of the leaf in the circuit witness.
+func (t BalanceLeaf) CircuitValue() circuits.BalanceLeaf {
+	leaf := circuits.BalanceLeaf{
+		PubKey: circuits.PaillierPubKey{
+			N: circuits.BigIntValue(t.PubKey.N, utils.PaillierBits),
+			G: circuits.BigIntValue(t.PubKey.G, utils.PaillierBits),
+		},
+		EncBalance:        circuits.BigIntValue(t.EncBalance, 2*utils.PaillierBits),
+		BalanceCommitment: pointValue(t.BalanceCommitment),
+		Nonce:             t.Nonce,
+	}
+	leaf.SpendingKey.Assign(tedwards.BN254, t.SpendingKey.Bytes())
+	return leaf
+}
+
+// EncodePoint returns the hex encoding of the compressed point, in which the
+// API serves the balance commitments.
+func EncodePoint(p twistededwards.PointAffine) string {
+	b := p.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+// DecodePoint parses a point encoded by EncodePoint.
+func DecodePoint(s string) (twistededwards.PointAffine, error) {
+	var p twistededwards.PointAffine
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return p, err
+	}
+	if _, err := p.SetBytes(b); err != nil {
+		return p, fmt.Errorf("invalid point: %w", err)
+	}
+	return p, nil
+}
+
+// pointValue returns the assignment of a point in the circuit witness.
+func pointValue(p twistededwards.PointAffine) gEdwards.Point {
+	return gEdwards.Point{X: p.X.String(), Y: p.Y.String()}
+}
+
 func (t BalanceLeaf) CalculateHash() ([]byte, error) {
 	hfunc := hash.MIMC_BN254.New()
 	hfunc.Reset()
-	hfunc.Write(utils.Pad32Bytes(t.PubKey.N.Bytes()))
-	hfunc.Write(utils.Pad32Bytes(t.PubKey.G.Bytes()))
-	hfunc.Write(utils.Pad32Bytes(t.EncBalance.Bytes()))
+	for _, field := range t.Fields() {
+		hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+	}
 	return hfunc.Sum(nil), nil
 }
 
@@ -60,16 +313,190 @@ func (t BalanceLeaf) Equals(other merkletree.Content) (bool, error) {
 	return bytes.Equal(tHash, otherHash), nil
 }
 
+// BalanceLeafOf returns the leaf holding the user account.
+func BalanceLeafOf(user UserData) BalanceLeaf {
+	return convertToLeaf(user)
+}
+
 func convertToLeaf(user UserData) BalanceLeaf {
 	return BalanceLeaf{
 		PubKey: PaillierPubKey{
 			N: user.KeyPair.PublicKey.N,
 			G: user.KeyPair.PublicKey.G,
 		},
-		EncBalance: user.EncBalance,
+		EncBalance:        user.EncBalance,
+		BalanceCommitment: user.BalanceCommitment,
+		SpendingKey:       user.SpendingKey.PublicKey,
+		Nonce:             user.Nonce,
+	}
+}
+
+// TransferMessage returns the message the sender signs with its spending key
+// to authorize a transfer: the hash of the domain, the balances root the
+// transfer applies to, the recipient leaf, the commitment to the amount and
+// the current nonce of the sender.
+func TransferMessage(domain Domain, oldRoot []byte, toLeaf BalanceLeaf, amountCommitment twistededwards.PointAffine, nonce *big.Int) ([]byte, error) {
+	toLeafHash, err := toLeaf.CalculateHash()
+	if err != nil {
+		return nil, err
+	}
+
+	hfunc := hash.MIMC_BN254.New()
+	for _, field := range domain.Fields() {
+		hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+	}
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+	hfunc.Write(toLeafHash)
+	x, y := amountCommitment.X.Bytes(), amountCommitment.Y.Bytes()
+	hfunc.Write(x[:])
+	hfunc.Write(y[:])
+	if _, err := hfunc.Write(utils.Pad32Bytes(nonce.Bytes())); err != nil {
+		return nil, err
+	}
+	return hfunc.Sum(nil), nil
+}
+
+// NewTransferIntent commits to amount under a random blinding and signs the
+// transfer with the sender spending key, as a client would.
+func NewTransferIntent(domain Domain, oldRoot []byte, from UserData, to UserData, amount *big.Int, spendingKey *eddsa.PrivateKey) (TransferIntent, error) {
+	nonce := from.Nonce
+
+	amountBlinding, err := utils.RandomBlinding()
+	if err != nil {
+		return TransferIntent{}, err
+	}
+
+	msg, err := TransferMessage(domain, oldRoot, convertToLeaf(to), utils.Commit(amount, amountBlinding), nonce)
+	if err != nil {
+		return TransferIntent{}, err
+	}
+	sig, err := spendingKey.Sign(msg, hash.MIMC_BN254.New())
+	if err != nil {
+		return TransferIntent{}, err
+	}
+
+	return TransferIntent{
+		OldRoot:        oldRoot,
+		FromIndex:      from.Index,
+		ToIndex:        to.Index,
+		Amount:         amount,
+		AmountBlinding: amountBlinding,
+		Nonce:          nonce,
+		Signature:      sig,
+	}, nil
+}
+
+// verifyTransferIntent checks that the intent can be proven against the
+// given tree and domain: the intent must not be stale, the amount blinding
+// must be a scalar and the signature must be valid for the sender.
+func verifyTransferIntent(domain Domain, tree *merkletree.SparseMerkleTree, from UserData, to UserData, intent TransferIntent) error {
+	if intent.OldRoot != nil && !bytes.Equal(intent.OldRoot, tree.MerkleRoot()) {
+		return ErrStaleRoot
+	}
+	if intent.Nonce.Cmp(from.Nonce) != 0 {
+		return errors.New("transfer nonce does not match the sender nonce")
+	}
+	if !validBlinding(intent.AmountBlinding) {
+		return errors.New("invalid amount blinding")
+	}
+
+	msg, err := TransferMessage(domain, tree.MerkleRoot(), convertToLeaf(to), utils.Commit(intent.Amount, intent.AmountBlinding), intent.Nonce)
+	if err != nil {
+		return err
+	}
+	valid, err := from.SpendingKey.PublicKey.Verify(intent.Signature, msg, hash.MIMC_BN254.New())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid transfer signature")
+	}
+
+	return nil
+}
+
+// validBlinding reports whether the blinding is below the order of the
+// curve, as utils.RandomBlinding returns them.
+func validBlinding(blinding *big.Int) bool {
+	curve := twistededwards.GetEdwardsCurve()
+	return blinding != nil && blinding.Sign() >= 0 && blinding.Cmp(&curve.Order) < 0
+}
+
+// WithdrawMessage returns the message the owner signs with its spending key
+// to authorize a withdrawal: the hash of the domain, the balances root the
+// withdrawal applies to, the amount, the withdrawal address and the current
+// nonce of the owner.
+func WithdrawMessage(domain Domain, oldRoot []byte, amount *big.Int, recipient *big.Int, nonce *big.Int) ([]byte, error) {
+	hfunc := hash.MIMC_BN254.New()
+	for _, field := range domain.Fields() {
+		hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+	}
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+	hfunc.Write(utils.Pad32Bytes(amount.Bytes()))
+	hfunc.Write(utils.Pad32Bytes(recipient.Bytes()))
+	if _, err := hfunc.Write(utils.Pad32Bytes(nonce.Bytes())); err != nil {
+		return nil, err
+	}
+	return hfunc.Sum(nil), nil
+}
+
+// NewWithdrawIntent signs the withdrawal with the owner spending key, as a
+// client would.
+func NewWithdrawIntent(domain Domain, oldRoot []byte, user UserData, amount *big.Int, recipient *big.Int, spendingKey *eddsa.PrivateKey) (WithdrawIntent, error) {
+	msg, err := WithdrawMessage(domain, oldRoot, amount, recipient, user.Nonce)
+	if err != nil {
+		return WithdrawIntent{}, err
+	}
+	sig, err := spendingKey.Sign(msg, hash.MIMC_BN254.New())
+	if err != nil {
+		return WithdrawIntent{}, err
+	}
+
+	return WithdrawIntent{
+		OldRoot:   oldRoot,
+		Index:     user.Index,
+		Amount:    amount,
+		Recipient: recipient,
+		Nonce:     user.Nonce,
+		Signature: sig,
+	}, nil
+}
+
+// verifyWithdrawIntent checks that the intent can be proven against the given
+// tree and domain: the amount must not exceed the balance, the address must fit in
+// circuits.AddressBits bits and the signature must be valid for the owner.
+func verifyWithdrawIntent(domain Domain, tree *merkletree.SparseMerkleTree, user UserData, intent WithdrawIntent) error {
+	if intent.OldRoot != nil && !bytes.Equal(intent.OldRoot, tree.MerkleRoot()) {
+		return ErrStaleRoot
+	}
+	if intent.Amount.Sign() < 0 || intent.Amount.Cmp(user.Balance) > 0 {
+		return errors.New("amount exceeds the balance")
+	}
+	if intent.Recipient.Sign() < 0 || intent.Recipient.BitLen() > circuits.AddressBits {
+		return errors.New("invalid withdrawal address")
 	}
+	if intent.Nonce.Cmp(user.Nonce) != 0 {
+		return errors.New("withdrawal nonce does not match the account nonce")
+	}
+
+	msg, err := WithdrawMessage(domain, tree.MerkleRoot(), intent.Amount, intent.Recipient, intent.Nonce)
+	if err != nil {
+		return err
+	}
+	valid, err := user.SpendingKey.PublicKey.Verify(intent.Signature, msg, hash.MIMC_BN254.New())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid withdrawal signature")
+	}
+
+	return nil
 }
 
+// GenerateData returns n random accounts held by the server. Their balances
+// sum below 2^utils.BalanceBits, the cap of the total supply of the contract,
+// see TotalSupply.
 func GenerateData(n int) []UserData {
 	var users []UserData
 	for i := 0; i < n; i++ {
@@ -77,410 +504,735 @@ func GenerateData(n int) []UserData {
 		if err != nil {
 			panic(err)
 		}
+		spendingKey, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
 
-		balance := utils.RandomBigInt(utils.PaillierBits - 1)
-		encBalance, r, err := paillier.Encrypt(&keyPair.PublicKey, balance.Bytes())
+		balance := utils.RandomBigInt(utils.BalanceBits - bits.Len(uint(n)))
+		blinding, err := utils.RandomBlinding()
+		if err != nil {
+			panic(err)
+		}
+		encBalance, err := EncryptBalance(&keyPair.PublicKey, balance, blinding)
 		if err != nil {
 			panic(err)
 		}
 
 		user := UserData{
-			Index:      i,
-			KeyPair:    keyPair,
-			Balance:    balance,
-			EncBalance: new(big.Int).SetBytes(encBalance),
-			EncR:       r,
+			Index:             i,
+			KeyPair:           keyPair,
+			SpendingKey:       spendingKey,
+			Nonce:             big.NewInt(0),
+			Balance:           balance,
+			Blinding:          blinding,
+			EncBalance:        encBalance,
+			BalanceCommitment: utils.Commit(balance, blinding),
 		}
 		users = append(users, user)
 	}
 	return users
 }
 
-func GenerateTreeFromUserData(users []UserData) merkletree.MerkleTree {
-	var leaves []merkletree.Content
+// TotalSupply returns the sum of the balances of the users, which must all be
+// held by the server. The contract is set up with the root of the users and
+// their total supply, which it keeps below 2^utils.BalanceBits as tokens are
+// deposited, so that no balance can outgrow utils.BalanceBits.
+func TotalSupply(users []UserData) (*big.Int, error) {
+	supply := new(big.Int)
 	for _, user := range users {
-		leaf := convertToLeaf(user)
-		leaves = append(leaves, leaf)
+		if user.Balance == nil {
+			return nil, fmt.Errorf("account %d is in client custody", user.Index)
+		}
+		supply.Add(supply, user.Balance)
 	}
+	return supply, nil
+}
 
-	tree, err := merkletree.NewTree(leaves)
+func GenerateTreeFromUserData(depth int, users []UserData) *merkletree.SparseMerkleTree {
+	tree, err := merkletree.NewSparseTree(depth)
 	if err != nil {
 		panic(err)
 	}
 
-	return *tree
+	for _, user := range users {
+		if _, err := tree.UpdateLeafAt(user.Index, convertToLeaf(user)); err != nil {
+			panic(err)
+		}
+	}
+
+	return tree
+}
+
+// verifyLeaf checks that the path of the leaf at index is consistent with the
+// root of the tree.
+func verifyLeaf(tree *merkletree.SparseMerkleTree, index int) error {
+	ok, err := tree.VerifyLeafAt(index)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("failed to verify leaf %d", index)
+	}
+	return nil
+}
+
+// sparseProofValue returns the assignment of a proof of the tree of the given
+// root.
+func sparseProofValue(root []byte, proof merkletree.SparseMerkleProof) utils.SparseMerkleProof {
+	res := utils.SparseMerkleProof{
+		RootHash: root,
+		Siblings: make([]frontend.Variable, len(proof.Siblings)),
+	}
+	for i, sibling := range proof.Siblings {
+		res.Siblings[i] = sibling
+	}
+	return res
 }
 
 func GenerateTransferWitness(
 	depth int,
-	tree merkletree.MerkleTree,
+	domain Domain,
+	tree *merkletree.SparseMerkleTree,
 	users []UserData,
-	fromIndex int,
-	toIndex int,
-	amount *big.Int,
-) (circuits.PrivateCoinCircuit, [14]*big.Int, UserData, UserData, merkletree.MerkleTree, error) {
-	var pubInputs [14]*big.Int
+	intent TransferIntent,
+) (circuits.PrivateCoinCircuit, []*big.Int, UserData, UserData, error) {
+	if !domain.valid() {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, errInvalidDomain
+	}
+	fromIndex, toIndex, amount := intent.FromIndex, intent.ToIndex, intent.Amount
+	if fromIndex < 0 || fromIndex >= len(users) || toIndex < 0 || toIndex >= len(users) || fromIndex == toIndex {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, errors.New("invalid transfer indexes")
+	}
+	sender, err := openAccount(users[fromIndex], intent.FromOpening)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
+	}
+	if amount.Sign() < 0 || amount.Cmp(sender.Balance) > 0 {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, errors.New("amount exceeds the sender balance")
+	}
+	// Only the owner of a recipient account in client custody knows its
+	// balance, which the circuit then leaves unchecked
+	clientCustody := users[toIndex].Balance == nil
+	if !clientCustody && new(big.Int).Add(users[toIndex].Balance, amount).BitLen() > utils.BalanceBits {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, errors.New("recipient balance would overflow")
+	}
+	if err := verifyTransferIntent(domain, tree, users[fromIndex], users[toIndex], intent); err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
+	}
 
-	var circuit circuits.PrivateCoinCircuit
-	circuit.OldFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.OldToLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.NewFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.NewToLeafMP.Path = make([]frontend.Variable, depth+1)
+	// The server encrypts the amount and its blinding for the recipient
+	encAmountR, err := rand.Int(rand.Reader, users[toIndex].KeyPair.N)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
+	}
 
 	var witness circuits.PrivateCoinCircuit
-	witness.OldFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	witness.OldToLeafMP.Path = make([]frontend.Variable, depth+1)
-	witness.NewFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	witness.NewToLeafMP.Path = make([]frontend.Variable, depth+1)
-
-	witness.OldBalancesRoot = tree.MerkleRoot()
-	pubInputs[0] = new(big.Int).SetBytes(tree.MerkleRoot())
+	witness.ClientCustody = clientCustody
+	oldRoot := tree.MerkleRoot()
+	witness.Domain = domain.CircuitValue()
+	witness.OldBalancesRoot = oldRoot
 
 	// For leaf fromIndex
-	leaf0 := users[fromIndex]
-	content0 := convertToLeaf(leaf0)
-	proof0, proofHelper0, err := tree.GetMerklePath(content0)
+	oldContent0 := convertToLeaf(sender)
+	proof0, err := tree.ProveMembership(fromIndex)
 	if err != nil {
-		panic(err)
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	success, err := tree.VerifyContent(content0)
-	if err != nil {
-		panic(err)
-	}
-	if !success {
-		panic("failed to verify content")
-	}
-
-	witness.OldFromLeaf.PubKey.N = content0.PubKey.N
-	pubInputs[2] = new(big.Int).SetBytes(content0.PubKey.N.Bytes())
-	witness.OldFromLeaf.PubKey.G = content0.PubKey.G
-	pubInputs[3] = new(big.Int).SetBytes(content0.PubKey.G.Bytes())
-	witness.OldFromLeaf.EncBalance = content0.EncBalance
-	pubInputs[4] = new(big.Int).SetBytes(content0.EncBalance.Bytes())
-	witness.OldFromLeafMP.RootHash = tree.MerkleRoot()
-	for i := 0; i < depth+1; i++ {
-		if i == 0 {
-			witness.OldFromLeafMP.Path[i], err = content0.CalculateHash()
-			if err != nil {
-				panic(err)
-			}
-			continue
-		}
-		witness.OldFromLeafMP.Path[i] = proof0[i-1]
+	if err := verifyLeaf(tree, fromIndex); err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	witness.OldFromLeafMPHelper = proofHelper0
-	witness.OldFromBalance = leaf0.Balance
-	witness.EncOldFromBalanceR = leaf0.EncR
+
+	witness.OldFromLeaf = oldContent0.CircuitValue()
+	witness.FromIndex = fromIndex
+	witness.OldFromLeafMP = sparseProofValue(oldRoot, proof0)
+	witness.OldFromBalance = sender.Balance
+	witness.OldFromBlinding = sender.Blinding
 
 	// For leaf toIndex
-	leaf1 := users[toIndex]
-	content1 := convertToLeaf(leaf1)
-	proof1, proofHelper1, err := tree.GetMerklePath(content1)
+	recipient := users[toIndex]
+	oldContent1 := convertToLeaf(recipient)
+	proof1, err := tree.ProveMembership(toIndex)
 	if err != nil {
-		panic(err)
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	success, err = tree.VerifyContent(content1)
-	if err != nil {
-		panic(err)
+	if err := verifyLeaf(tree, toIndex); err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	if !success {
-		panic("failed to verify content")
-	}
-
-	witness.OldToLeaf.PubKey.N = content1.PubKey.N
-	pubInputs[5] = new(big.Int).SetBytes(content1.PubKey.N.Bytes())
-	witness.OldToLeaf.PubKey.G = content1.PubKey.G
-	pubInputs[6] = new(big.Int).SetBytes(content1.PubKey.G.Bytes())
-	witness.OldToLeaf.EncBalance = content1.EncBalance
-	pubInputs[7] = new(big.Int).SetBytes(content1.EncBalance.Bytes())
-	witness.OldToLeafMP.RootHash = tree.MerkleRoot()
-	for i := 0; i < depth+1; i++ {
-		if i == 0 {
-			witness.OldToLeafMP.Path[i], err = content1.CalculateHash()
-			if err != nil {
-				panic(err)
-			}
-			continue
-		}
-		witness.OldToLeafMP.Path[i] = proof1[i-1]
+
+	witness.OldToLeaf = oldContent1.CircuitValue()
+	witness.ToIndex = toIndex
+	witness.OldToLeafMP = sparseProofValue(oldRoot, proof1)
+	if clientCustody {
+		witness.OldToBalance = 0
+		witness.OldToBlinding = 0
+	} else {
+		witness.OldToBalance = recipient.Balance
+		witness.OldToBlinding = recipient.Blinding
 	}
-	witness.OldToLeafMPHelper = proofHelper1
 
 	// For Amount
 	witness.Amount = amount
-	encAmountBytes, r, err := paillier.Encrypt(&leaf1.KeyPair.PublicKey, amount.Bytes())
-	if err != nil {
-		panic(err)
-	}
-	witness.EncAmountR = r
+	witness.AmountBlinding = intent.AmountBlinding
+	witness.Signature.Assign(tedwards.BN254, intent.Signature)
 
 	// Calculate new balance for leaf fromIndex
-	newFromBalance := new(big.Int).Sub(leaf0.Balance, amount)
-	encNewFromBalanceBytes, r, err := paillier.Encrypt(&leaf0.KeyPair.PublicKey, newFromBalance.Bytes())
+	leaf0, err := spend(sender, amount)
 	if err != nil {
-		panic(err)
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	witness.EncNewFromBalanceR = r
+	leaf0.Nonce = new(big.Int).Add(leaf0.Nonce, big.NewInt(1))
+	witness.NewFromBlinding = leaf0.Blinding
+	content0 := convertToLeaf(leaf0)
 
-	leaf0.Balance = newFromBalance
-	leaf0.EncBalance = new(big.Int).SetBytes(encNewFromBalanceBytes)
-	leaf0.EncR = r
-	content0 = convertToLeaf(leaf0)
-	err = tree.ModifyLeafAt(fromIndex, content0)
+	// Calculate new balance for leaf toIndex
+	leaf1, err := receive(recipient, amount, intent.AmountBlinding, encAmountR)
 	if err != nil {
-		panic(err)
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
 	}
+	content1 := convertToLeaf(leaf1)
 
-	// Calculate new balance for leaf toIndex
-	encNewToBalanceBytes := paillier.AddCipher(&leaf1.KeyPair.PublicKey, encAmountBytes, leaf1.EncBalance.Bytes())
-	leaf1.Balance.Add(leaf1.Balance, amount)
-	leaf1.EncBalance = new(big.Int).SetBytes(encNewToBalanceBytes)
-	leaf1.EncR = r
-	content1 = convertToLeaf(leaf1)
-	err = tree.ModifyLeafAt(toIndex, content1)
-	if err != nil {
-		panic(err)
+	// Both leaves are updated, or none
+	if _, err := tree.UpdateLeafAt(fromIndex, content0); err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, err
+	}
+	if _, err := tree.UpdateLeafAt(toIndex, content1); err != nil {
+		_, revertErr := tree.UpdateLeafAt(fromIndex, oldContent0)
+		return circuits.PrivateCoinCircuit{}, nil, UserData{}, UserData{}, errors.Join(err, revertErr)
 	}
 
 	witness.NewBalancesRoot = tree.MerkleRoot()
-	pubInputs[1] = new(big.Int).SetBytes(tree.MerkleRoot())
 
-	// For leaf fromIndex after transfer
-	newProof0, newProofHelper0, err := tree.GetMerklePath(content0)
-	if err != nil {
-		panic(err)
+	witness.NewFromLeaf = content0.CircuitValue()
+	witness.NewToLeaf = content1.CircuitValue()
+	leavesHash := TransferLeavesHash(oldContent0, oldContent1, content0, content1)
+	witness.LeavesHash = leavesHash
+
+	// Public inputs, in the order in which the circuit declares them
+	pubInputs := append(domain.Fields(),
+		new(big.Int).SetBytes(oldRoot),
+		new(big.Int).SetBytes(tree.MerkleRoot()),
+		new(big.Int).SetBytes(leavesHash),
+	)
+
+	return witness, pubInputs, withoutOpening(leaf0, users[fromIndex]), leaf1, nil
+}
+
+// TransferLeavesHash returns the public input of a transfer committing to its
+// leaves: the hash of the fields of the old sender, old recipient, new sender
+// and new recipient leaves.
+func TransferLeavesHash(oldFromLeaf, oldToLeaf, newFromLeaf, newToLeaf BalanceLeaf) []byte {
+	return hashLeaves(oldFromLeaf, oldToLeaf, newFromLeaf, newToLeaf)
+}
+
+// LeafUpdateHash returns the public input of a deposit or a withdrawal
+// committing to its leaves: the hash of the fields of the old and new leaves.
+func LeafUpdateHash(oldLeaf, newLeaf BalanceLeaf) []byte {
+	return hashLeaves(oldLeaf, newLeaf)
+}
+
+// TransferCommitment returns the public commitment of a hidden transfer: the
+// hash of the fields of the new sender and recipient leaves.
+func TransferCommitment(newFromLeaf BalanceLeaf, newToLeaf BalanceLeaf) []byte {
+	return hashLeaves(newFromLeaf, newToLeaf)
+}
+
+func hashLeaves(leaves ...BalanceLeaf) []byte {
+	hfunc := hash.MIMC_BN254.New()
+	for _, leaf := range leaves {
+		for _, field := range leaf.Fields() {
+			hfunc.Write(utils.Pad32Bytes(field.Bytes()))
+		}
 	}
-	success, err = tree.VerifyContent(content0)
+	return hfunc.Sum(nil)
+}
+
+// GenerateHiddenTransferWitness is GenerateTransferWitness for the hidden
+// mode, where only the domain, the balances roots and the commitment to the
+// new leaves are public.
+func GenerateHiddenTransferWitness(
+	depth int,
+	domain Domain,
+	tree *merkletree.SparseMerkleTree,
+	users []UserData,
+	intent TransferIntent,
+) (circuits.HiddenTransferCircuit, []*big.Int, UserData, UserData, error) {
+	transfer, transferInputs, from, to, err := GenerateTransferWitness(depth, domain, tree, users, intent)
 	if err != nil {
-		panic(err)
+		return circuits.HiddenTransferCircuit{}, nil, UserData{}, UserData{}, err
 	}
-	if !success {
-		panic("failed to verify content")
-	}
-
-	witness.NewFromLeaf.PubKey.N = content0.PubKey.N
-	pubInputs[8] = new(big.Int).SetBytes(content0.PubKey.N.Bytes())
-	witness.NewFromLeaf.PubKey.G = content0.PubKey.G
-	pubInputs[9] = new(big.Int).SetBytes(content0.PubKey.G.Bytes())
-	witness.NewFromLeaf.EncBalance = content0.EncBalance
-	pubInputs[10] = new(big.Int).SetBytes(content0.EncBalance.Bytes())
-	witness.NewFromLeafMP.RootHash = tree.MerkleRoot()
-	for i := 0; i < depth+1; i++ {
-		if i == 0 {
-			witness.NewFromLeafMP.Path[i], err = content0.CalculateHash()
-			if err != nil {
-				panic(err)
-			}
-			continue
+
+	commitment := TransferCommitment(convertToLeaf(from), convertToLeaf(to))
+	witness := circuits.HiddenTransferCircuit{
+		Domain:          transfer.Domain,
+		OldBalancesRoot: transfer.OldBalancesRoot,
+		NewBalancesRoot: transfer.NewBalancesRoot,
+		Commitment:      commitment,
+		Transfer:        transfer.TransferStep,
+	}
+	// The domain and the roots lead the public inputs of both circuits
+	pubInputs := append(transferInputs[:4:4], new(big.Int).SetBytes(commitment))
+
+	return witness, pubInputs, from, to, nil
+}
+
+// BatchUpdate is the update of a leaf by a transfer of a batch.
+type BatchUpdate struct {
+	Index   int
+	OldLeaf BalanceLeaf
+	NewLeaf BalanceLeaf
+}
+
+// BatchUpdatesHash returns the public input of a batch of transfers: the
+// hash of the old and new balances roots followed by the leaf updates of every
+// transfer, sender first. Each update is hashed as its index, the fields of
+// the old leaf and the fields of the new leaf.
+func BatchUpdatesHash(oldRoot []byte, newRoot []byte, updates []BatchUpdate) []byte {
+	hfunc := hash.MIMC_BN254.New()
+	hfunc.Write(utils.Pad32Bytes(oldRoot))
+	hfunc.Write(utils.Pad32Bytes(newRoot))
+	for _, update := range updates {
+		hfunc.Write(utils.Pad32Bytes(big.NewInt(int64(update.Index)).Bytes()))
+		for _, field := range append(update.OldLeaf.Fields(), update.NewLeaf.Fields()...) {
+			hfunc.Write(utils.Pad32Bytes(field.Bytes()))
 		}
-		witness.NewFromLeafMP.Path[i] = newProof0[i-1]
 	}
-	witness.NewFromLeafMPHelper = newProofHelper0
+	return hfunc.Sum(nil)
+}
 
-	// For leaf toIndex after transfer
-	newProof1, newProofHelper1, err := tree.GetMerklePath(content1)
-	if err != nil {
-		panic(err)
+// TransferQueue holds transfers to be proven together by BatchWitness and
+// committed by DB.ApplyBatch. The queue applies them to its own copy of the
+// tree as they come, so that each intent is signed against the state left by
+// the previous ones, see Root, while the state of the DB is left unchanged.
+type TransferQueue struct {
+	depth     int
+	domain    Domain
+	tree      *merkletree.SparseMerkleTree
+	users     []UserData
+	roots     [][]byte
+	transfers []circuits.TransferStep
+	updates   []BatchUpdate
+}
+
+// NewTransferQueue returns an empty queue of transfers of the domain, starting
+// from the state of the given users.
+func NewTransferQueue(depth int, domain Domain, users []UserData) *TransferQueue {
+	tree := GenerateTreeFromUserData(depth, users)
+	return &TransferQueue{
+		depth:  depth,
+		domain: domain,
+		tree:   tree,
+		users:  append([]UserData(nil), users...),
+		roots:  [][]byte{tree.MerkleRoot()},
 	}
-	success, err = tree.VerifyContent(content1)
+}
+
+// Len returns the number of queued transfers.
+func (q *TransferQueue) Len() int {
+	return len(q.transfers)
+}
+
+// Root returns the balances root left by the queued transfers, which the next
+// intent is signed against.
+func (q *TransferQueue) Root() []byte {
+	return q.roots[len(q.roots)-1]
+}
+
+// Apply applies the transfer to the state of the queue and queues it.
+func (q *TransferQueue) Apply(intent TransferIntent) (UserData, UserData, error) {
+	// The batch circuit opens the recipient balances, which the server does
+	// not know for accounts in client custody
+	if intent.ToIndex >= 0 && intent.ToIndex < len(q.users) && q.users[intent.ToIndex].Balance == nil {
+		return UserData{}, UserData{}, errors.New("transfers to accounts in client custody cannot be batched")
+	}
+
+	witness, _, from, to, err := GenerateTransferWitness(q.depth, q.domain, q.tree, q.users, intent)
 	if err != nil {
-		panic(err)
+		return UserData{}, UserData{}, err
 	}
-	if !success {
-		panic("failed to verify content")
-	}
-
-	witness.NewToLeaf.PubKey.N = content1.PubKey.N
-	pubInputs[11] = new(big.Int).SetBytes(content1.PubKey.N.Bytes())
-	witness.NewToLeaf.PubKey.G = content1.PubKey.G
-	pubInputs[12] = new(big.Int).SetBytes(content1.PubKey.G.Bytes())
-	witness.NewToLeaf.EncBalance = content1.EncBalance
-	pubInputs[13] = new(big.Int).SetBytes(content1.EncBalance.Bytes())
-	witness.NewToLeafMP.RootHash = tree.MerkleRoot()
-	for i := 0; i < depth+1; i++ {
-		if i == 0 {
-			witness.NewToLeafMP.Path[i], err = content1.CalculateHash()
-			if err != nil {
-				panic(err)
-			}
-			continue
+
+	q.roots = append(q.roots, q.tree.MerkleRoot())
+	q.transfers = append(q.transfers, witness.TransferStep)
+	q.updates = append(q.updates,
+		BatchUpdate{Index: from.Index, OldLeaf: convertToLeaf(q.users[from.Index]), NewLeaf: convertToLeaf(from)},
+		BatchUpdate{Index: to.Index, OldLeaf: convertToLeaf(q.users[to.Index]), NewLeaf: convertToLeaf(to)},
+	)
+	q.users[from.Index], q.users[to.Index] = from, to
+
+	return from, to, nil
+}
+
+// updatedUsers returns the users updated by the queued transfers, in their
+// final state.
+func (q *TransferQueue) updatedUsers() []UserData {
+	var updated []UserData
+	seen := make(map[int]bool)
+	for _, update := range q.updates {
+		if !seen[update.Index] {
+			seen[update.Index] = true
+			updated = append(updated, q.users[update.Index])
 		}
-		witness.NewToLeafMP.Path[i] = newProof1[i-1]
 	}
-	witness.NewToLeafMPHelper = newProofHelper1
+	return updated
+}
 
-	return witness, pubInputs, leaf0, leaf1, tree, nil
+// BatchWitness returns the witness and public inputs proving the queued
+// transfers.
+func (q *TransferQueue) BatchWitness() (circuits.BatchTransferCircuit, []*big.Int, error) {
+	if len(q.transfers) == 0 {
+		return circuits.BatchTransferCircuit{}, nil, errors.New("no queued transfers")
+	}
+
+	oldRoot, newRoot := q.roots[0], q.Root()
+	witness := circuits.BatchTransferCircuit{
+		Domain:          q.domain.CircuitValue(),
+		OldBalancesRoot: oldRoot,
+		NewBalancesRoot: newRoot,
+		Transfers:       q.transfers,
+	}
+	for _, root := range q.roots[1 : len(q.roots)-1] {
+		witness.IntermediateRoots = append(witness.IntermediateRoots, root)
+	}
+	updatesHash := BatchUpdatesHash(oldRoot, newRoot, q.updates)
+	witness.UpdatesHash = updatesHash
+
+	return witness, append(q.domain.Fields(), new(big.Int).SetBytes(updatesHash)), nil
 }
 
-func GenerateProofFromTransferWitness(witness circuits.PrivateCoinCircuit, pInputs [14]*big.Int, depth int) error {
-	var circuit circuits.PrivateCoinCircuit
-	circuit.OldFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.OldToLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.NewFromLeafMP.Path = make([]frontend.Variable, depth+1)
-	circuit.NewToLeafMP.Path = make([]frontend.Variable, depth+1)
+// GenerateRegisterWitness registers an account for the given public keys in
+// the first free leaf of the tree, at index len(users), and returns the
+// witness and public inputs of the registration along with the new user.
+// The server only learns the public keys of the user, the balance starts at
+// a commitment to zero under a random blinding, which the encrypted balance
+// hands over to the user.
+func GenerateRegisterWitness(
+	depth int,
+	domain Domain,
+	tree *merkletree.SparseMerkleTree,
+	users []UserData,
+	pubKey *paillier.PublicKey,
+	spendingKey eddsa.PublicKey,
+) (circuits.RegisterAccountCircuit, []*big.Int, UserData, error) {
+	if !domain.valid() {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, errInvalidDomain
+	}
+	index := len(users)
+	if index >= tree.Capacity() {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, errors.New("balances tree is full")
+	}
+	if pubKey.N.BitLen() != utils.PaillierBits || pubKey.G.Cmp(new(big.Int).Add(pubKey.N, big.NewInt(1))) != 0 {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, errors.New("invalid paillier public key")
+	}
 
-	CreateNewKeys := false
-	var ccs constraint.ConstraintSystem
-	var pk groth16.ProvingKey
-	var vk groth16.VerifyingKey
+	proof, err := tree.ProveNonMembership(index)
+	if err != nil {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, err
+	}
 
-	if CreateNewKeys {
-		var err error
+	blinding, err := utils.RandomBlinding()
+	if err != nil {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, err
+	}
+	encBalance, err := EncryptBalance(pubKey, big.NewInt(0), blinding)
+	if err != nil {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, err
+	}
 
-		ccs, err = frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
-		if err != nil {
-			return err
-		}
+	user := UserData{
+		Index:             index,
+		KeyPair:           &paillier.PrivateKey{PublicKey: *pubKey},
+		SpendingKey:       &eddsa.PrivateKey{PublicKey: spendingKey},
+		Nonce:             big.NewInt(0),
+		Balance:           big.NewInt(0),
+		Blinding:          blinding,
+		EncBalance:        encBalance,
+		BalanceCommitment: utils.Commit(big.NewInt(0), blinding),
+	}
+	leaf := convertToLeaf(user)
 
-		pk, vk, err = groth16.Setup(ccs)
-		if err != nil {
-			return err
-		}
+	oldRoot := tree.MerkleRoot()
+	if _, err := tree.UpdateLeafAt(index, leaf); err != nil {
+		return circuits.RegisterAccountCircuit{}, nil, UserData{}, err
+	}
 
-		{
-			f, err := os.Create("exports/circuit.r1cs")
-			if err != nil {
-				return err
-			}
-			_, err = ccs.WriteTo(f)
-			if err != nil {
-				return err
-			}
-		}
-		{
-			f, err := os.Create("exports/circuit.vk")
-			if err != nil {
-				return err
-			}
-			_, err = vk.WriteRawTo(f)
-			if err != nil {
-				return err
-			}
-		}
-		{
-			f, err := os.Create("exports/circuit.pk")
-			if err != nil {
-				return err
-			}
-			_, err = pk.WriteRawTo(f)
-			if err != nil {
-				return err
-			}
-		}
-		{
-			f, err := os.Create("exports/verifier.sol")
-			if err != nil {
-				return err
-			}
-			err = vk.ExportSolidity(f)
-			if err != nil {
-				return err
-			}
-		}
-		fmt.Println("Wrote keys to exports/circuit.pk and exports/circuit.vk")
-	} else {
-		ccs = groth16.NewCS(ecc.BN254)
-		fmt.Println("Reading circuit from exports/circuit.r1cs")
-		{
-			f, err := os.Open("exports/circuit.r1cs")
-			if err != nil {
-				return err
-			}
-			_, err = ccs.ReadFrom(f)
-			if err != nil {
-				return err
-			}
-		}
-		pk = groth16.NewProvingKey(ecc.BN254)
-		fmt.Println("Reading proving key from exports/circuit.pk")
-		{
-			f, _ := os.Open("exports/circuit.pk")
-			_, err := pk.ReadFrom(f)
-			f.Close()
-			if err != nil {
-				return err
-			}
-		}
-		vk = groth16.NewVerifyingKey(ecc.BN254)
-		fmt.Println("Reading verifying key from exports/circuit.vk")
-		{
-			f, _ := os.Open("exports/circuit.vk")
-			_, err := vk.ReadFrom(f)
-			f.Close()
-			if err != nil {
-				return err
-			}
-		}
-		{
-			f, err := os.Create("exports/verifier.sol")
-			if err != nil {
-				return err
-			}
-			err = vk.ExportSolidity(f)
-			if err != nil {
-				return err
-			}
-		}
-		println("Read keys from exports/circuit.pk and exports/circuit.vk")
+	witness := circuits.RegisterAccountCircuit{
+		Domain:          domain.CircuitValue(),
+		OldBalancesRoot: oldRoot,
+		NewBalancesRoot: tree.MerkleRoot(),
+		Index:           index,
+		NewLeaf:         leaf.CircuitValue(),
+		Blinding:        blinding,
 	}
+	witness.LeafMP = sparseProofValue(oldRoot, proof)
+
+	// Public inputs, in the order in which the circuit declares them
+	pubInputs := append(domain.Fields(),
+		new(big.Int).SetBytes(oldRoot),
+		new(big.Int).SetBytes(tree.MerkleRoot()),
+		big.NewInt(int64(index)),
+	)
+	pubInputs = append(pubInputs, leaf.Fields()...)
 
-	validWitness, err := frontend.NewWitness(&witness, ecc.BN254.ScalarField())
+	return witness, pubInputs, user, nil
+}
+
+// GenerateDepositWitness adds a public amount to the balance of the user at
+// index, updating the tree, and returns the witness and public
+// inputs of the deposit along with the updated user.
+func GenerateDepositWitness(
+	depth int,
+	domain Domain,
+	tree *merkletree.SparseMerkleTree,
+	users []UserData,
+	index int,
+	amount *big.Int,
+	opening *Opening,
+) (circuits.DepositCircuit, []*big.Int, UserData, error) {
+	if !domain.valid() {
+		return circuits.DepositCircuit{}, nil, UserData{}, errInvalidDomain
+	}
+	if index < 0 || index >= len(users) {
+		return circuits.DepositCircuit{}, nil, UserData{}, errors.New("index out of bounds")
+	}
+	user, err := openAccount(users[index], opening)
 	if err != nil {
-		return err
+		return circuits.DepositCircuit{}, nil, UserData{}, err
+	}
+	if amount.Sign() < 0 || new(big.Int).Add(user.Balance, amount).BitLen() > utils.BalanceBits {
+		return circuits.DepositCircuit{}, nil, UserData{}, errors.New("balance would overflow")
 	}
 
-	validPublicWitness, err := validWitness.Public()
+	proof, err := tree.ProveMembership(index)
 	if err != nil {
-		return err
+		return circuits.DepositCircuit{}, nil, UserData{}, err
 	}
+	oldRoot := tree.MerkleRoot()
+	oldContent := convertToLeaf(user)
 
-	proof, err := groth16.Prove(ccs, pk, validWitness, backend.WithSolverOptions(solver.WithHints(hints.DivModHint)))
+	var witness circuits.DepositCircuit
+	witness.Domain = domain.CircuitValue()
+	witness.OldBalancesRoot = oldRoot
+	witness.Amount = amount
+	witness.OldLeaf = oldContent.CircuitValue()
+	witness.Index = index
+	witness.OldBalance = user.Balance
+	witness.OldBlinding = user.Blinding
+	witness.LeafMP = sparseProofValue(oldRoot, proof)
+
+	// The blinding is unchanged, and adding g^amount leaves the randomness
+	// of the ciphertext unchanged
+	user.Balance = new(big.Int).Add(user.Balance, amount)
+	user.EncBalance = new(big.Int).SetBytes(paillier.Add(&user.KeyPair.PublicKey, user.EncBalance.Bytes(), amount.Bytes()))
+	user.BalanceCommitment = addPublic(user.BalanceCommitment, amount)
+	content := convertToLeaf(user)
+	if _, err := tree.UpdateLeafAt(index, content); err != nil {
+		return circuits.DepositCircuit{}, nil, UserData{}, err
+	}
+
+	witness.NewBalancesRoot = tree.MerkleRoot()
+	witness.NewLeaf = content.CircuitValue()
+	leavesHash := LeafUpdateHash(oldContent, content)
+	witness.LeavesHash = leavesHash
+
+	// Public inputs, in the order in which the circuit declares them
+	pubInputs := append(domain.Fields(),
+		new(big.Int).SetBytes(oldRoot),
+		new(big.Int).SetBytes(tree.MerkleRoot()),
+		amount,
+		new(big.Int).SetBytes(leavesHash),
+	)
+
+	return witness, pubInputs, withoutOpening(user, users[index]), nil
+}
+
+// GenerateWithdrawWitness takes the amount of the intent out of the balance of
+// its owner, updating the tree, and returns the witness and public
+// inputs of the withdrawal along with the updated user.
+func GenerateWithdrawWitness(
+	depth int,
+	domain Domain,
+	tree *merkletree.SparseMerkleTree,
+	users []UserData,
+	intent WithdrawIntent,
+) (circuits.WithdrawCircuit, []*big.Int, UserData, error) {
+	if !domain.valid() {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, errInvalidDomain
+	}
+	index := intent.Index
+	if index < 0 || index >= len(users) {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, errors.New("index out of bounds")
+	}
+	user, err := openAccount(users[index], intent.Opening)
 	if err != nil {
-		return err
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
+	}
+	if err := verifyWithdrawIntent(domain, tree, user, intent); err != nil {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
 	}
-	fmt.Println("Proving done.")
 
-	err = groth16.Verify(proof, vk, validPublicWitness)
+	proof, err := tree.ProveMembership(index)
 	if err != nil {
-		return err
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
 	}
-	fmt.Println("Verifying done.")
+	oldRoot := tree.MerkleRoot()
+	oldContent := convertToLeaf(user)
 
-	GenerateProofData(proof, pInputs, 14)
+	var witness circuits.WithdrawCircuit
+	witness.Domain = domain.CircuitValue()
+	witness.OldBalancesRoot = oldRoot
+	witness.Amount = intent.Amount
+	witness.Recipient = intent.Recipient
+	witness.OldLeaf = oldContent.CircuitValue()
+	witness.Index = index
+	witness.LeafMP = sparseProofValue(oldRoot, proof)
+	witness.OldBalance = user.Balance
+	witness.OldBlinding = user.Blinding
+	witness.Signature.Assign(tedwards.BN254, intent.Signature)
 
-	return nil
+	// The blinding is unchanged, and taking g^amount off the ciphertext
+	// leaves its randomness unchanged
+	encAmount, err := paillier.EncryptWithNonce(&user.KeyPair.PublicKey, big.NewInt(1), intent.Amount.Bytes())
+	if err != nil {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
+	}
+	encNewBalance, err := paillier.SubCipher(&user.KeyPair.PublicKey, user.EncBalance.Bytes(), encAmount.Bytes())
+	if err != nil {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
+	}
+
+	user.Balance = new(big.Int).Sub(user.Balance, intent.Amount)
+	user.EncBalance = new(big.Int).SetBytes(encNewBalance)
+	user.BalanceCommitment = addPublic(user.BalanceCommitment, new(big.Int).Neg(intent.Amount))
+	user.Nonce = new(big.Int).Add(user.Nonce, big.NewInt(1))
+	content := convertToLeaf(user)
+	if _, err := tree.UpdateLeafAt(index, content); err != nil {
+		return circuits.WithdrawCircuit{}, nil, UserData{}, err
+	}
+
+	witness.NewBalancesRoot = tree.MerkleRoot()
+	witness.NewLeaf = content.CircuitValue()
+	leavesHash := LeafUpdateHash(oldContent, content)
+	witness.LeavesHash = leavesHash
+
+	// Public inputs, in the order in which the circuit declares them
+	pubInputs := append(domain.Fields(),
+		new(big.Int).SetBytes(oldRoot),
+		new(big.Int).SetBytes(tree.MerkleRoot()),
+		intent.Amount,
+		intent.Recipient,
+		new(big.Int).SetBytes(leavesHash),
+	)
+
+	return witness, pubInputs, withoutOpening(user, users[index]), nil
+}
+
+// TransferCircuitName returns the name of the keys of the transfer circuit,
+// compiled for a recipient in client custody or not.
+func TransferCircuitName(clientCustody bool) string {
+	if clientCustody {
+		return "custody_transfer"
+	}
+	return "transfer"
+}
+
+// HiddenTransferCircuitName is TransferCircuitName for the hidden transfer
+// circuit.
+func HiddenTransferCircuitName(clientCustody bool) string {
+	if clientCustody {
+		return "custody_hidden_transfer"
+	}
+	return "hidden_transfer"
+}
+
+// BatchTransferCircuitName returns the name of the keys of the batch transfer
+// circuit of batchSize transfers.
+func BatchTransferCircuitName(batchSize int) string {
+	return fmt.Sprintf("batch_transfer_%d", batchSize)
 }
 
-func GenerateProofData(proof groth16.Proof, pubInputs [14]*big.Int, pubInputLen int) {
+// Provers holds the provers of the circuits of the server, for a tree of a
+// given depth and one custody mode.
+type Provers struct {
+	Transfer       *Prover
+	HiddenTransfer *Prover
+	Register       *Prover
+	Deposit        *Prover
+	Withdraw       *Prover
+}
+
+// LoadProvers reads the provers of the circuits of the server from exports/,
+// see LoadProver. With setup, it runs a new setup of every circuit instead,
+// see SetupProver.
+func LoadProvers(depth int, clientCustody bool, setup bool) (*Provers, error) {
+	transfer := circuits.NewPrivateCoinCircuit(depth, utils.PaillierBits)
+	transfer.ClientCustody = clientCustody
+	hiddenTransfer := circuits.NewHiddenTransferCircuit(depth, utils.PaillierBits)
+	hiddenTransfer.Transfer.ClientCustody = clientCustody
+	register := circuits.NewRegisterAccountCircuit(depth, utils.PaillierBits)
+	deposit := circuits.NewDepositCircuit(depth, utils.PaillierBits)
+	withdraw := circuits.NewWithdrawCircuit(depth, utils.PaillierBits)
+
+	var provers Provers
+	for _, c := range []struct {
+		prover  **Prover
+		name    string
+		circuit frontend.Circuit
+	}{
+		{&provers.Transfer, TransferCircuitName(clientCustody), &transfer},
+		{&provers.HiddenTransfer, HiddenTransferCircuitName(clientCustody), &hiddenTransfer},
+		{&provers.Register, "register", &register},
+		{&provers.Deposit, "deposit", &deposit},
+		{&provers.Withdraw, "withdraw", &withdraw},
+	} {
+		var err error
+		if setup {
+			*c.prover, err = SetupProver(c.name, c.circuit)
+		} else {
+			*c.prover, err = LoadProver(c.name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("circuit %s: %w", c.name, err)
+		}
+	}
+
+	return &provers, nil
+}
+
+// GenerateProofData returns the proof and its public inputs in the format of
+// the calldata of the Solidity verifier.
+func GenerateProofData(proof groth16.Proof, pubInputs []*big.Int) (Groth16ProofData, error) {
 	const fpSize = 4 * 8
 	var buf bytes.Buffer
-	proof.WriteRawTo(&buf)
+	if _, err := proof.WriteRawTo(&buf); err != nil {
+		return Groth16ProofData{}, err
+	}
 	proofBytes := buf.Bytes()
+	if len(proofBytes) < 8*fpSize {
+		return Groth16ProofData{}, errors.New("proof is too short")
+	}
 
 	proofs := make([]string, 8)
 	for i := 0; i < 8; i++ {
 		proofs[i] = "0x" + hex.EncodeToString(proofBytes[i*fpSize:(i+1)*fpSize])
 	}
 
-	inputs := make([]string, pubInputLen)
-	for i := 0; i < pubInputLen; i++ {
+	inputs := make([]string, len(pubInputs))
+	for i := 0; i < len(pubInputs); i++ {
 		inputs[i] = "0x" + fmt.Sprintf("%x", pubInputs[i])
 	}
 
-	// Create the data struct and populate it
-	data := Groth16ProofData{
+	return Groth16ProofData{
 		Proof:  proofs,
 		Inputs: inputs,
-	}
+	}, nil
+}
 
+// WriteFile writes the proof data as indented JSON to path.
+func (data Groth16ProofData) WriteFile(path string) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		fmt.Println("Error marshalling to JSON:", err)
-		return
+		return err
 	}
 
-	err = os.WriteFile("exports/proof_data.json", jsonData, 0o644)
-	if err != nil {
-		fmt.Println("Error writing to file:", err)
-	}
+	return os.WriteFile(path, jsonData, 0o644)
 }
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// builtWitness is the output of a witness builder along with the circuit it
+// proves.
+type builtWitness struct {
+	name    string
+	circuit frontend.Circuit
+	witness frontend.Circuit
+	pInputs []*big.Int
+}
+
+// buildWitnesses runs every witness builder in turn against the same tree,
+// as the server does.
+func buildWitnesses(t *testing.T) []builtWitness {
+	t.Helper()
+	users := newTestUsers(t, 4)
+	for i := range users {
+		users[i].Index = i
+	}
+	tree := GenerateTreeFromUserData(testDepth, users)
+	var built []builtWitness
+
+	intent, err := NewTransferIntent(testDomain, tree.MerkleRoot(), users[0], users[1], big.NewInt(10), users[0].SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign transfer: %v", err)
+	}
+	transfer, pInputs, from, to, err := GenerateTransferWitness(testDepth, testDomain, tree, users, intent)
+	if err != nil {
+		t.Fatalf("Failed to build transfer witness: %v", err)
+	}
+	users[from.Index], users[to.Index] = from, to
+	transferCircuit := circuits.NewPrivateCoinCircuit(testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"transfer", &transferCircuit, &transfer, pInputs})
+
+	intent, err = NewTransferIntent(testDomain, tree.MerkleRoot(), users[1], users[2], big.NewInt(4), users[1].SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign transfer: %v", err)
+	}
+	hidden, pInputs, from, to, err := GenerateHiddenTransferWitness(testDepth, testDomain, tree, users, intent)
+	if err != nil {
+		t.Fatalf("Failed to build hidden transfer witness: %v", err)
+	}
+	users[from.Index], users[to.Index] = from, to
+	hiddenCircuit := circuits.NewHiddenTransferCircuit(testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"hidden", &hiddenCircuit, &hidden, pInputs})
+
+	// The second transfer of the batch spends from the first
+	queue := NewTransferQueue(testDepth, testDomain, users)
+	for _, step := range [][2]int{{2, 3}, {3, 0}} {
+		intent, err := NewTransferIntent(testDomain, queue.Root(), users[step[0]], users[step[1]], big.NewInt(3), users[step[0]].SpendingKey)
+		if err != nil {
+			t.Fatalf("Failed to sign transfer: %v", err)
+		}
+		from, to, err := queue.Apply(intent)
+		if err != nil {
+			t.Fatalf("Failed to queue transfer: %v", err)
+		}
+		users[from.Index], users[to.Index] = from, to
+	}
+	batch, pInputs, err := queue.BatchWitness()
+	if err != nil {
+		t.Fatalf("Failed to build batch witness: %v", err)
+	}
+	for _, user := range queue.updatedUsers() {
+		if _, err := tree.UpdateLeafAt(user.Index, convertToLeaf(user)); err != nil {
+			t.Fatalf("Failed to apply batch: %v", err)
+		}
+	}
+	batchCircuit := circuits.NewBatchTransferCircuit(2, testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"batch", &batchCircuit, &batch, pInputs})
+
+	deposit, pInputs, user, err := GenerateDepositWitness(testDepth, testDomain, tree, users, 1, big.NewInt(25), nil)
+	if err != nil {
+		t.Fatalf("Failed to build deposit witness: %v", err)
+	}
+	users[user.Index] = user
+	depositCircuit := circuits.NewDepositCircuit(testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"deposit", &depositCircuit, &deposit, pInputs})
+
+	withdrawIntent, err := NewWithdrawIntent(testDomain, tree.MerkleRoot(), users[2], big.NewInt(7), big.NewInt(0xbeef), users[2].SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign withdrawal: %v", err)
+	}
+	withdraw, pInputs, user, err := GenerateWithdrawWitness(testDepth, testDomain, tree, users, withdrawIntent)
+	if err != nil {
+		t.Fatalf("Failed to build withdraw witness: %v", err)
+	}
+	users[user.Index] = user
+	withdrawCircuit := circuits.NewWithdrawCircuit(testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"withdraw", &withdrawCircuit, &withdraw, pInputs})
+
+	// Registration only accepts keys of the circuit size
+	keyPair, err := paillier.GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate Paillier key: %v", err)
+	}
+	register, pInputs, _, err := GenerateRegisterWitness(testDepth, testDomain, tree, users, &keyPair.PublicKey, users[0].SpendingKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to build register witness: %v", err)
+	}
+	registerCircuit := circuits.NewRegisterAccountCircuit(testDepth, utils.PaillierBits)
+	built = append(built, builtWitness{"register", &registerCircuit, &register, pInputs})
+
+	return built
+}
+
+// TestWitnessPublicInputs checks that the public inputs returned by each
+// builder are those of its witness, in the order the circuit declares them.
+func TestWitnessPublicInputs(t *testing.T) {
+	for _, built := range buildWitnesses(t) {
+		witness, err := frontend.NewWitness(built.witness, ecc.BN254.ScalarField(), frontend.PublicOnly())
+		if err != nil {
+			t.Fatalf("%s: failed to build public witness: %v", built.name, err)
+		}
+		public := witness.Vector().(fr.Vector)
+		if len(public) != len(built.pInputs) {
+			t.Errorf("%s: %d public inputs, want %d", built.name, len(built.pInputs), len(public))
+			continue
+		}
+		for i := range public {
+			want := public[i].BigInt(new(big.Int))
+			if got := new(big.Int).Mod(built.pInputs[i], ecc.BN254.ScalarField()); got.Cmp(want) != 0 {
+				t.Errorf("%s: public input %d is %s, want %s", built.name, i, got, want)
+			}
+		}
+	}
+}
+
+// TestWitnessesSolveCircuits checks the witnesses of the builders against
+// their circuits at the size the server proves them.
+func TestWitnessesSolveCircuits(t *testing.T) {
+	for _, built := range buildWitnesses(t) {
+		// The transfer witness is solved as the steps of the batch
+		if built.name == "transfer" {
+			continue
+		}
+		t.Run(built.name, func(t *testing.T) {
+			if err := test.IsSolved(built.circuit, built.witness, ecc.BN254.ScalarField()); err != nil {
+				t.Errorf("Witness does not solve the circuit: %v", err)
+			}
+		})
+	}
+}
+
+func TestTotalSupply(t *testing.T) {
+	users := newTestUsers(t, 3)
+	supply, err := TotalSupply(users)
+	if err != nil {
+		t.Fatalf("Failed to sum balances: %v", err)
+	}
+	if supply.Cmp(big.NewInt(3000)) != 0 {
+		t.Errorf("Total supply is %s, want 3000", supply)
+	}
+
+	// The supply of accounts in client custody is unknown to the server
+	users[1].Balance = nil
+	if _, err := TotalSupply(users); err == nil {
+		t.Errorf("Summing balances in client custody should fail")
+	}
+}
@@ -1,41 +1,147 @@
 package db
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/consensys/gnark/frontend"
+	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
 	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
 	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
 )
 
 type UserData struct {
-	Index      int
-	KeyPair    *paillier.PrivateKey
-	Balance    *big.Int
-	EncBalance *big.Int
-	EncR       *big.Int
+	Index       int
+	KeyPair     *paillier.PrivateKey
+	SpendingKey *eddsa.PrivateKey
+	Nonce       *big.Int
+	// Balance and Blinding open BalanceCommitment, and EncBalance encrypts
+	// them, see utils.BalancePlaintext. Both are nil for accounts in client
+	// custody, see Opening.
+	Balance           *big.Int
+	Blinding          *big.Int
+	EncBalance        *big.Int
+	BalanceCommitment twistededwards.PointAffine
 }
 
 type UserResponse struct {
-	Index      int                 `json:"index"`
-	KeyPair    *paillier.PublicKey `json:"keyPair"`
-	Balance    string              `json:"balance"`
-	EncBalance string              `json:"encBalance"`
-	EncR       string              `json:"encR"`
+	Index       int               `json:"index"`
+	KeyPair     PublicKeyResponse `json:"keyPair"`
+	SpendingKey string            `json:"spendingKey"`
+	Nonce       string            `json:"nonce"`
+	Balance     string            `json:"balance,omitempty"`
+	EncBalance  string            `json:"encBalance"`
+	// BalanceCommitment is the compressed point, hex encoded.
+	BalanceCommitment string `json:"balanceCommitment"`
+	Blinding          string `json:"blinding,omitempty"`
+}
+
+// PublicKeyResponse is the Paillier public key of an account in the API
+// responses, in the shape the API has always served rather than the versioned
+// encoding of paillier.PublicKey. S is only set for Damgard-Jurik keys.
+type PublicKeyResponse struct {
+	N        *big.Int `json:"N"`
+	G        *big.Int `json:"G"`
+	NSquared *big.Int `json:"NSquared"`
+	S        int      `json:"S,omitempty"`
+}
+
+// NewPublicKeyResponse returns the response for the public key.
+func NewPublicKeyResponse(pubKey *paillier.PublicKey) PublicKeyResponse {
+	resp := PublicKeyResponse{N: pubKey.N, G: pubKey.G, NSquared: pubKey.NSquared}
+	if pubKey.S > 1 {
+		resp.S = pubKey.S
+	}
+	return resp
+}
+
+// PublicKey returns the public key of the response, checking that g = n + 1.
+func (resp PublicKeyResponse) PublicKey() (*paillier.PublicKey, error) {
+	if resp.N == nil || resp.N.Sign() <= 0 {
+		return nil, errors.New("missing paillier modulus")
+	}
+	pubKey := paillier.NewPublicKey(resp.N)
+	if resp.S > 1 {
+		pubKey = paillier.NewDamgardJurikPublicKey(resp.N, resp.S)
+	}
+	if resp.G != nil && resp.G.Cmp(pubKey.G) != 0 {
+		return nil, errors.New("paillier generator is not n + 1")
+	}
+	return pubKey, nil
 }
 
 type DB struct {
 	sync.RWMutex
 	Users      []UserData
-	MerkleTree *merkletree.MerkleTree
+	MerkleTree *merkletree.SparseMerkleTree
+	Roots      [][]byte
+	// ClientCustody registers accounts without keeping their balance and
+	// blinding, which their owners supply to spend from them.
+	ClientCustody bool
+	// Domain is the deployment the proofs of the DB are generated for.
+	Domain Domain
+
+	store Store
+}
+
+// New returns a DB over a balances tree of the given depth, recovering its
+// state from the store. The DB only lives in memory if the store is nil.
+func New(depth int, store Store) (*DB, error) {
+	db := &DB{
+		Users:      make([]UserData, 0),
+		MerkleTree: GenerateTreeFromUserData(depth, nil),
+		store:      store,
+	}
+	if store == nil {
+		return db, nil
+	}
+
+	state, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for i, user := range state.Users {
+		if user.Index != i {
+			return nil, fmt.Errorf("stored user %d has index %d", i, user.Index)
+		}
+		if _, err := db.MerkleTree.UpdateLeafAt(i, convertToLeaf(user)); err != nil {
+			return nil, err
+		}
+
+		// The stored hashes catch users that do not match the leaves
+		// they were saved with
+		leafHash, err := convertToLeaf(user).CalculateHash()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(leafHash, state.LeafHashes[i]) {
+			return nil, fmt.Errorf("stored user %d does not match its leaf hash", i)
+		}
+	}
+	if len(state.Roots) > 0 && !bytes.Equal(state.Roots[len(state.Roots)-1], db.MerkleTree.MerkleRoot()) {
+		return nil, errors.New("recovered tree does not match the stored root")
+	}
+	db.Users = state.Users
+	db.Roots = state.Roots
+
+	return db, nil
 }
 
-func New() *DB {
-	return &DB{
-		Users: make([]UserData, 0),
+// Close closes the store of the DB.
+func (db *DB) Close() error {
+	if db.store == nil {
+		return nil
 	}
+	return db.store.Close()
 }
 
+// StoreUser appends the user without updating the tree nor the store, see
+// AddUsers.
 func (db *DB) StoreUser(user UserData) {
 	db.Lock()
 	defer db.Unlock()
@@ -48,7 +154,7 @@ func (db *DB) StoreUserAtIndex(user UserData, index int) {
 	db.Users[index] = user
 }
 
-func (db *DB) StoreMerkleTree(tree *merkletree.MerkleTree) {
+func (db *DB) StoreMerkleTree(tree *merkletree.SparseMerkleTree) {
 	db.Lock()
 	db.MerkleTree = tree
 	db.Unlock()
@@ -71,38 +177,452 @@ func (db *DB) GetUser(index int) UserData {
 	return db.Users[index]
 }
 
-func (db *DB) GetMerkleTree() *merkletree.MerkleTree {
+// GetRootHistory returns the balances roots of all the state transitions,
+// oldest first.
+func (db *DB) GetRootHistory() [][]byte {
+	db.RLock()
+	defer db.RUnlock()
+
+	roots := make([][]byte, len(db.Roots))
+	copy(roots, db.Roots)
+
+	return roots
+}
+
+// GetMerkleTree returns the balances tree. The tree is updated in place by the
+// state transitions, use GetMerkleRoot and GetMerkleProof to read it
+// concurrently with them.
+func (db *DB) GetMerkleTree() *merkletree.SparseMerkleTree {
 	db.RLock()
 	tree := db.MerkleTree
 	db.RUnlock()
 	return tree
 }
 
+// GetMerkleRoot returns the current balances root, or nil if there is no
+// tree yet.
 func (db *DB) GetMerkleRoot() []byte {
 	db.RLock()
-	tree := db.MerkleTree
-	db.RUnlock()
-	return tree.MerkleRoot()
+	defer db.RUnlock()
+
+	if db.MerkleTree == nil {
+		return nil
+	}
+	return db.MerkleTree.MerkleRoot()
+}
+
+// GetMerklePath returns the current balances root along with the siblings of
+// the leaf at index, ordered from the leaves up, so that both match.
+func (db *DB) GetMerklePath(index int) ([]byte, [][]byte, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	siblings, _, err := db.MerkleTree.GetMerklePathAt(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.MerkleTree.MerkleRoot(), siblings, nil
+}
+
+// TransferMessage returns the message signed by the sender of a transfer of
+// the amount committed to by amountCommitment, see the package-level
+// TransferMessage, along with the root and the sender nonce it covers. All
+// three are read from the same state.
+func (db *DB) TransferMessage(fromIndex int, toIndex int, amountCommitment twistededwards.PointAffine) ([]byte, []byte, *big.Int, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if fromIndex < 0 || fromIndex >= len(db.Users) || toIndex < 0 || toIndex >= len(db.Users) {
+		return nil, nil, nil, errors.New("invalid transfer indexes")
+	}
+	root := db.MerkleTree.MerkleRoot()
+	nonce := db.Users[fromIndex].Nonce
+	msg, err := TransferMessage(db.Domain, root, convertToLeaf(db.Users[toIndex]), amountCommitment, nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return msg, root, nonce, nil
+}
+
+// WithdrawMessage returns the message signed by the owner of a withdrawal,
+// see the package-level WithdrawMessage, along with the root and the owner
+// nonce it covers. All three are read from the same state.
+func (db *DB) WithdrawMessage(index int, amount *big.Int, recipient *big.Int) ([]byte, []byte, *big.Int, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if index < 0 || index >= len(db.Users) {
+		return nil, nil, nil, errors.New("invalid index")
+	}
+	root := db.MerkleTree.MerkleRoot()
+	nonce := db.Users[index].Nonce
+	msg, err := WithdrawMessage(db.Domain, root, amount, recipient, nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return msg, root, nonce, nil
 }
 
 func (db *DB) GetMerkleProof(index int) ([][]byte, big.Int, error) {
 	db.RLock()
+	defer db.RUnlock()
+
 	tree := db.MerkleTree
-	db.RUnlock()
-	leaf := db.Users[index]
-	content := convertToLeaf(leaf)
-	proof, proofHelper, err := tree.GetMerklePath(content)
+	proof, proofHelper, err := tree.GetMerklePathAt(index)
 	if err != nil {
 		return nil, big.Int{}, err
 	}
 
-	success, err := tree.VerifyContent(content)
+	success, err := tree.VerifyLeafAt(index)
 	if err != nil {
 		return nil, big.Int{}, err
 	}
 	if !success {
-		return nil, big.Int{}, err
+		return nil, big.Int{}, errors.New("failed to verify merkle proof")
 	}
 
 	return proof, proofHelper, nil
 }
+
+// ProveFunc proves the witness of a state transition along with its public
+// inputs, see Prover.ProofData.
+type ProveFunc func(witness frontend.Circuit, pInputs []*big.Int) (Groth16ProofData, error)
+
+// update applies a state transition and persists it. The transition updates
+// the tree in place and returns the updated users, which are appended when
+// their index is the next free one. It must leave the tree unchanged when it
+// fails. It runs under the write lock, and is then reverted while prove, if
+// not nil, proves it without the lock, so that readers and other transitions
+// are not held up by the proof. The transition is only committed once both the
+// proof and the store succeed, and if no other transition was committed in
+// the meantime, otherwise update returns ErrStaleRoot.
+func (db *DB) update(transition func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error), prove func() error) error {
+	db.Lock()
+	oldRoot := db.MerkleTree.MerkleRoot()
+	updated, err := transition(db.MerkleTree, db.Users)
+	if err != nil {
+		db.Unlock()
+		return err
+	}
+	if prove == nil {
+		defer db.Unlock()
+		return db.commit(updated)
+	}
+	newRoot := db.MerkleTree.MerkleRoot()
+	err = db.revert(updated)
+	db.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := prove(); err != nil {
+		return fmt.Errorf("proof: %w", err)
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if !bytes.Equal(db.MerkleTree.MerkleRoot(), oldRoot) {
+		return ErrStaleRoot
+	}
+	for _, user := range updated {
+		if _, err := db.MerkleTree.UpdateLeafAt(user.Index, convertToLeaf(user)); err != nil {
+			return errors.Join(err, db.revert(updated))
+		}
+	}
+	if !bytes.Equal(db.MerkleTree.MerkleRoot(), newRoot) {
+		return errors.Join(errors.New("updated users do not match the proven root"), db.revert(updated))
+	}
+
+	return db.commit(updated)
+}
+
+// commit persists the transition left in the tree and applies it to the
+// users, or reverts it if the store fails. It must be called under the write
+// lock.
+func (db *DB) commit(updated []UserData) error {
+	root := db.MerkleTree.MerkleRoot()
+	if db.store != nil {
+		if err := db.store.Save(updated, root); err != nil {
+			return errors.Join(err, db.revert(updated))
+		}
+	}
+	db.Roots = append(db.Roots, root)
+
+	for _, user := range updated {
+		if user.Index == len(db.Users) {
+			db.Users = append(db.Users, user)
+		} else {
+			db.Users[user.Index] = user
+		}
+	}
+
+	return nil
+}
+
+// revert restores the leaves of the updated users from the committed ones.
+// The tree only depends on its leaves, so this reverts a transition.
+func (db *DB) revert(updated []UserData) error {
+	for _, user := range updated {
+		var leaf merkletree.Content
+		if user.Index < len(db.Users) {
+			leaf = convertToLeaf(db.Users[user.Index])
+		}
+		if _, err := db.MerkleTree.UpdateLeafAt(user.Index, leaf); err != nil {
+			return fmt.Errorf("reverting leaf %d: %w", user.Index, err)
+		}
+	}
+	return nil
+}
+
+// ApplyTransfer applies the transfer to the tree and to both users as a single
+// transaction, and returns its proof. The transfer is only committed once
+// proven. An intent signed against an older root is rejected with
+// ErrStaleRoot.
+func (db *DB) ApplyTransfer(depth int, intent TransferIntent, prove ProveFunc) (Groth16ProofData, error) {
+	var witness circuits.PrivateCoinCircuit
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		var from, to UserData
+		var err error
+		witness, pInputs, from, to, err = GenerateTransferWitness(depth, db.Domain, tree, users, intent)
+		return []UserData{from, to}, err
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// ApplyHiddenTransfer is ApplyTransfer for the hidden transfer mode.
+func (db *DB) ApplyHiddenTransfer(depth int, intent TransferIntent, prove ProveFunc) (Groth16ProofData, error) {
+	var witness circuits.HiddenTransferCircuit
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		var from, to UserData
+		var err error
+		witness, pInputs, from, to, err = GenerateHiddenTransferWitness(depth, db.Domain, tree, users, intent)
+		return []UserData{from, to}, err
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// ApplyBatch commits the transfers of the queue as a single transaction, and
+// returns the proof of the batch. The queue must start from the current state,
+// otherwise ApplyBatch returns ErrStaleRoot.
+func (db *DB) ApplyBatch(queue *TransferQueue, prove ProveFunc) (Groth16ProofData, error) {
+	witness, pInputs, err := queue.BatchWitness()
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	var proofData Groth16ProofData
+	err = db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		if !bytes.Equal(tree.MerkleRoot(), queue.roots[0]) {
+			return nil, ErrStaleRoot
+		}
+		updated := queue.updatedUsers()
+		for i, user := range updated {
+			if _, err := tree.UpdateLeafAt(user.Index, convertToLeaf(user)); err != nil {
+				return nil, errors.Join(err, db.revert(updated[:i]))
+			}
+		}
+		return updated, nil
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// ProvenTransfer is a transfer proven by the wallet of the sender with the
+// client custody transfer circuit: the new encrypted balance and balance
+// commitment of the sender, the amount with the blinding and the Paillier
+// randomness it was sent with, see ReceiveLeaf, and the proof, encoded by
+// groth16.Proof.WriteTo.
+type ProvenTransfer struct {
+	FromIndex         int
+	ToIndex           int
+	NewFromEncBalance *big.Int
+	NewFromCommitment twistededwards.PointAffine
+	Amount            *big.Int
+	AmountBlinding    *big.Int
+	EncAmountR        *big.Int
+	Proof             []byte
+}
+
+// VerifyFunc verifies a proof against the public inputs of a state transition,
+// see Prover.Verify.
+type VerifyFunc func(proof []byte, pInputs []*big.Int) (Groth16ProofData, error)
+
+// ApplyProvenTransfer applies a transfer proven by the wallet of the sender as
+// a single transaction, and returns its proof data. The public inputs are
+// derived from the current root and leaves, so the transfer is only committed
+// if it was proven against them. The new recipient leaf is derived from the
+// amount, so that the recipient can decrypt its new balance. It is only
+// accepted in client custody, where the server keeps neither balance.
+func (db *DB) ApplyProvenTransfer(transfer ProvenTransfer, verify VerifyFunc) (Groth16ProofData, error) {
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		if !db.ClientCustody {
+			return nil, errors.New("transfers proven by wallets are only accepted in client custody")
+		}
+		fromIndex, toIndex := transfer.FromIndex, transfer.ToIndex
+		if fromIndex < 0 || fromIndex >= len(users) || toIndex < 0 || toIndex >= len(users) || fromIndex == toIndex {
+			return nil, errors.New("invalid transfer indexes")
+		}
+		if transfer.NewFromEncBalance == nil || transfer.Amount == nil || transfer.Amount.Sign() < 0 || !validBlinding(transfer.AmountBlinding) || transfer.EncAmountR == nil {
+			return nil, errors.New("missing encrypted balance or amount")
+		}
+
+		oldRoot := tree.MerkleRoot()
+		from, to := users[fromIndex], users[toIndex]
+		oldFromLeaf, oldToLeaf := convertToLeaf(from), convertToLeaf(to)
+		from.EncBalance = transfer.NewFromEncBalance
+		from.BalanceCommitment = transfer.NewFromCommitment
+		from.Nonce = new(big.Int).Add(from.Nonce, big.NewInt(1))
+		from.Balance, from.Blinding = nil, nil
+		to.Balance, to.Blinding = nil, nil
+		to, err := receive(to, transfer.Amount, transfer.AmountBlinding, transfer.EncAmountR)
+		if err != nil {
+			return nil, err
+		}
+		newFromLeaf, newToLeaf := convertToLeaf(from), convertToLeaf(to)
+
+		// Both leaves are updated, or none
+		if _, err := tree.UpdateLeafAt(fromIndex, newFromLeaf); err != nil {
+			return nil, err
+		}
+		if _, err := tree.UpdateLeafAt(toIndex, newToLeaf); err != nil {
+			_, revertErr := tree.UpdateLeafAt(fromIndex, oldFromLeaf)
+			return nil, errors.Join(err, revertErr)
+		}
+
+		// Public inputs, in the order in which the circuit declares them
+		pInputs = append(db.Domain.Fields(),
+			new(big.Int).SetBytes(oldRoot),
+			new(big.Int).SetBytes(tree.MerkleRoot()),
+			new(big.Int).SetBytes(TransferLeavesHash(oldFromLeaf, oldToLeaf, newFromLeaf, newToLeaf)),
+		)
+		return []UserData{from, to}, nil
+	}, func() (err error) {
+		proofData, err = verify(transfer.Proof, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// ApplyDeposit applies the deposit to the tree and to the user as a single
+// transaction, and returns its proof. The opening is only needed for accounts
+// in client custody.
+func (db *DB) ApplyDeposit(depth int, index int, amount *big.Int, opening *Opening, prove ProveFunc) (Groth16ProofData, error) {
+	var witness circuits.DepositCircuit
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		var user UserData
+		var err error
+		witness, pInputs, user, err = GenerateDepositWitness(depth, db.Domain, tree, users, index, amount, opening)
+		return []UserData{user}, err
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// ApplyWithdraw applies the withdrawal to the tree and to the user as a single
+// transaction, and returns its proof. An intent signed against an older root
+// is rejected with ErrStaleRoot.
+func (db *DB) ApplyWithdraw(depth int, intent WithdrawIntent, prove ProveFunc) (Groth16ProofData, error) {
+	var witness circuits.WithdrawCircuit
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		var user UserData
+		var err error
+		witness, pInputs, user, err = GenerateWithdrawWitness(depth, db.Domain, tree, users, intent)
+		return []UserData{user}, err
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return proofData, nil
+}
+
+// RegisterAccount inserts the account in the next free leaf of the tree as a
+// single transaction, and returns the proof of the registration and the new
+// user. In client custody, the balance and blinding of the user are dropped
+// once the witness is built.
+func (db *DB) RegisterAccount(depth int, pubKey *paillier.PublicKey, spendingKey eddsa.PublicKey, prove ProveFunc) (Groth16ProofData, UserData, error) {
+	var witness circuits.RegisterAccountCircuit
+	var pInputs []*big.Int
+	var proofData Groth16ProofData
+	var user UserData
+	err := db.update(func(tree *merkletree.SparseMerkleTree, users []UserData) ([]UserData, error) {
+		var err error
+		witness, pInputs, user, err = GenerateRegisterWitness(depth, db.Domain, tree, users, pubKey, spendingKey)
+		if db.ClientCustody {
+			user.Balance, user.Blinding = nil, nil
+		}
+		return []UserData{user}, err
+	}, func() (err error) {
+		proofData, err = prove(&witness, pInputs)
+		return err
+	})
+	if err != nil {
+		return Groth16ProofData{}, UserData{}, err
+	}
+
+	return proofData, user, nil
+}
+
+// AddUsers inserts the users in the next free leaves of the tree as a single
+// transaction, setting their indexes.
+func (db *DB) AddUsers(users []UserData) error {
+	return db.update(func(tree *merkletree.SparseMerkleTree, current []UserData) ([]UserData, error) {
+		if len(current)+len(users) > tree.Capacity() {
+			return nil, errors.New("balances tree is full")
+		}
+
+		added := make([]UserData, len(users))
+		for i, user := range users {
+			user.Index = len(current) + i
+			if _, err := tree.UpdateLeafAt(user.Index, convertToLeaf(user)); err != nil {
+				return nil, errors.Join(err, db.revert(added[:i]))
+			}
+			added[i] = user
+		}
+
+		return added, nil
+	}, nil)
+}
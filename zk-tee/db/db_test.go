@@ -0,0 +1,391 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/consensys/gnark/frontend"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+const testDepth = 3
+
+// testPaillierBits keeps key generation fast while holding the plaintexts of
+// utils.BalancePlaintext. The witnesses are only built, never proven, so the
+// keys need not match utils.PaillierBits.
+const testPaillierBits = 512
+
+var testDomain = Domain{ChainID: big.NewInt(31337), Contract: big.NewInt(0xc0ffee)}
+
+var errTestProve = errors.New("prove failed")
+
+// testStore is an in-memory Store whose Save fails when fail is set.
+type testStore struct {
+	fail  bool
+	saves int
+}
+
+func (s *testStore) Load() (State, error) { return State{}, nil }
+
+func (s *testStore) Save(users []UserData, root []byte) error {
+	if s.fail {
+		return errors.New("save failed")
+	}
+	s.saves++
+	return nil
+}
+
+func (s *testStore) Close() error { return nil }
+
+func newTestUsers(t *testing.T, n int) []UserData {
+	t.Helper()
+	var users []UserData
+	for i := 0; i < n; i++ {
+		keyPair, err := paillier.GenerateKey(rand.Reader, testPaillierBits)
+		if err != nil {
+			t.Fatalf("Failed to generate Paillier key: %v", err)
+		}
+		spendingKey, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate spending key: %v", err)
+		}
+
+		balance := big.NewInt(1000)
+		blinding, err := utils.RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to draw blinding: %v", err)
+		}
+		encBalance, err := EncryptBalance(&keyPair.PublicKey, balance, blinding)
+		if err != nil {
+			t.Fatalf("Failed to encrypt balance: %v", err)
+		}
+		users = append(users, UserData{
+			KeyPair:           keyPair,
+			SpendingKey:       spendingKey,
+			Nonce:             big.NewInt(0),
+			Balance:           balance,
+			Blinding:          blinding,
+			EncBalance:        encBalance,
+			BalanceCommitment: utils.Commit(balance, blinding),
+		})
+	}
+	return users
+}
+
+func newTestDB(t *testing.T, store Store) *DB {
+	t.Helper()
+	database, err := New(testDepth, store)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	database.Domain = testDomain
+	if err := database.AddUsers(newTestUsers(t, 3)); err != nil {
+		t.Fatalf("Failed to add users: %v", err)
+	}
+	return database
+}
+
+func proveOK(witness frontend.Circuit, pInputs []*big.Int) (Groth16ProofData, error) {
+	return Groth16ProofData{Inputs: []string{"ok"}}, nil
+}
+
+func proveFail(witness frontend.Circuit, pInputs []*big.Int) (Groth16ProofData, error) {
+	return Groth16ProofData{}, errTestProve
+}
+
+func newTestTransfer(t *testing.T, database *DB, from int, to int) TransferIntent {
+	t.Helper()
+	users := database.GetAllUsers()
+	intent, err := NewTransferIntent(database.Domain, database.GetMerkleRoot(), users[from], users[to], big.NewInt(10), users[from].SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign transfer: %v", err)
+	}
+	return intent
+}
+
+// assertUnchanged checks that the DB is back to the given root, root history
+// and users, and that its tree matches its users.
+func assertUnchanged(t *testing.T, database *DB, root []byte, nbRoots int, users []UserData) {
+	t.Helper()
+	if !bytes.Equal(database.GetMerkleRoot(), root) {
+		t.Errorf("Root changed")
+	}
+	if got := len(database.GetRootHistory()); got != nbRoots {
+		t.Errorf("Root history has %d roots, want %d", got, nbRoots)
+	}
+	for i, user := range database.GetAllUsers() {
+		if user.Nonce.Cmp(users[i].Nonce) != 0 || user.EncBalance.Cmp(users[i].EncBalance) != 0 {
+			t.Errorf("User %d changed", i)
+		}
+	}
+	rebuilt := GenerateTreeFromUserData(testDepth, database.GetAllUsers())
+	if !bytes.Equal(rebuilt.MerkleRoot(), database.GetMerkleRoot()) {
+		t.Errorf("Tree does not match the users")
+	}
+}
+
+func TestApplyTransferStaleRoot(t *testing.T) {
+	database := newTestDB(t, nil)
+	intent := newTestTransfer(t, database, 0, 1)
+
+	if _, err := database.ApplyDeposit(testDepth, 2, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+
+	if _, err := database.ApplyTransfer(testDepth, intent, proveOK); !errors.Is(err, ErrStaleRoot) {
+		t.Fatalf("Applying a stale transfer returned %v, want ErrStaleRoot", err)
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+}
+
+func TestApplyTransferConcurrentTransition(t *testing.T) {
+	database := newTestDB(t, nil)
+	root := database.GetMerkleRoot()
+	intent := newTestTransfer(t, database, 0, 1)
+
+	// The state can be read and updated while the transfer is proven, and
+	// the transfer is then stale
+	var depositRoot []byte
+	var users []UserData
+	prove := func(witness frontend.Circuit, pInputs []*big.Int) (Groth16ProofData, error) {
+		if !bytes.Equal(database.GetMerkleRoot(), root) {
+			t.Errorf("The transfer being proven is visible to readers")
+		}
+		if _, err := database.ApplyDeposit(testDepth, 2, big.NewInt(5), nil, proveOK); err != nil {
+			t.Errorf("Failed to apply deposit: %v", err)
+		}
+		depositRoot = database.GetMerkleRoot()
+		users = database.GetAllUsers()
+		return proveOK(witness, pInputs)
+	}
+	if _, err := database.ApplyTransfer(testDepth, intent, prove); !errors.Is(err, ErrStaleRoot) {
+		t.Fatalf("Applying a transfer overtaken by a deposit returned %v, want ErrStaleRoot", err)
+	}
+	assertUnchanged(t, database, depositRoot, 2, users)
+}
+
+// queueTestTransfers queues a transfer of 10 for each pair of indexes, each
+// one signed against the state left by the previous ones.
+func queueTestTransfers(t *testing.T, database *DB, steps [][2]int) *TransferQueue {
+	t.Helper()
+	users := database.GetAllUsers()
+	queue := NewTransferQueue(testDepth, database.Domain, users)
+	for _, step := range steps {
+		intent, err := NewTransferIntent(database.Domain, queue.Root(), users[step[0]], users[step[1]], big.NewInt(10), users[step[0]].SpendingKey)
+		if err != nil {
+			t.Fatalf("Failed to sign transfer: %v", err)
+		}
+		from, to, err := queue.Apply(intent)
+		if err != nil {
+			t.Fatalf("Failed to queue transfer: %v", err)
+		}
+		users[from.Index], users[to.Index] = from, to
+	}
+	return queue
+}
+
+func TestApplyBatch(t *testing.T) {
+	database := newTestDB(t, nil)
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+
+	// Queuing leaves the DB unchanged until the batch is committed
+	queue := queueTestTransfers(t, database, [][2]int{{0, 1}, {1, 2}})
+	assertUnchanged(t, database, root, nbRoots, users)
+
+	if _, err := database.ApplyBatch(queue, proveOK); err != nil {
+		t.Fatalf("Failed to apply batch: %v", err)
+	}
+	if !bytes.Equal(database.GetMerkleRoot(), queue.Root()) {
+		t.Errorf("Root is not the one left by the batch")
+	}
+	if got := len(database.GetRootHistory()); got != nbRoots+1 {
+		t.Errorf("Root history has %d roots, want %d", got, nbRoots+1)
+	}
+	for i, want := range []int64{990, 1000, 1010} {
+		if got := database.GetUser(i).Balance; got.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("Balance of user %d is %s, want %d", i, got, want)
+		}
+	}
+	rebuilt := GenerateTreeFromUserData(testDepth, database.GetAllUsers())
+	if !bytes.Equal(rebuilt.MerkleRoot(), database.GetMerkleRoot()) {
+		t.Errorf("Tree does not match the users")
+	}
+}
+
+func TestApplyBatchStaleRoot(t *testing.T) {
+	database := newTestDB(t, nil)
+	queue := queueTestTransfers(t, database, [][2]int{{0, 1}})
+
+	if _, err := database.ApplyDeposit(testDepth, 2, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+
+	if _, err := database.ApplyBatch(queue, proveOK); !errors.Is(err, ErrStaleRoot) {
+		t.Fatalf("Applying a stale batch returned %v, want ErrStaleRoot", err)
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+}
+
+func TestApplyWithdrawStaleRoot(t *testing.T) {
+	database := newTestDB(t, nil)
+	user := database.GetUser(0)
+	intent, err := NewWithdrawIntent(database.Domain, database.GetMerkleRoot(), user, big.NewInt(3), big.NewInt(12345), user.SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign withdrawal: %v", err)
+	}
+
+	if _, err := database.ApplyDeposit(testDepth, 2, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+
+	if _, err := database.ApplyWithdraw(testDepth, intent, proveOK); !errors.Is(err, ErrStaleRoot) {
+		t.Fatalf("Applying a stale withdrawal returned %v, want ErrStaleRoot", err)
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+}
+
+func TestApplyTransferProveFailure(t *testing.T) {
+	database := newTestDB(t, nil)
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+	intent := newTestTransfer(t, database, 0, 1)
+
+	if _, err := database.ApplyTransfer(testDepth, intent, proveFail); !errors.Is(err, errTestProve) {
+		t.Fatalf("Applying a transfer that fails to prove returned %v", err)
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+
+	// The rolled back intent still applies against the same state
+	proofData, err := database.ApplyTransfer(testDepth, intent, proveOK)
+	if err != nil {
+		t.Fatalf("Failed to apply transfer: %v", err)
+	}
+	if len(proofData.Inputs) != 1 {
+		t.Errorf("Proof data not returned")
+	}
+	if got := database.GetUser(0).Nonce; got.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("Sender nonce is %s, want 1", got)
+	}
+
+	// So do the other transitions
+	withdraw, err := NewWithdrawIntent(database.Domain, database.GetMerkleRoot(), database.GetUser(2), big.NewInt(3), big.NewInt(12345), database.GetUser(2).SpendingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign withdrawal: %v", err)
+	}
+	root = database.GetMerkleRoot()
+	nbRoots = len(database.GetRootHistory())
+	users = database.GetAllUsers()
+	if _, err := database.ApplyWithdraw(testDepth, withdraw, proveFail); !errors.Is(err, errTestProve) {
+		t.Fatalf("Applying a withdrawal that fails to prove returned %v", err)
+	}
+	if _, err := database.ApplyDeposit(testDepth, 1, big.NewInt(5), nil, proveFail); !errors.Is(err, errTestProve) {
+		t.Fatalf("Applying a deposit that fails to prove returned %v", err)
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+
+	// Registration only accepts keys of the circuit size
+	keyPair, err := paillier.GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate Paillier key: %v", err)
+	}
+	spendingKey := users[0].SpendingKey.PublicKey
+	if _, _, err := database.RegisterAccount(testDepth, &keyPair.PublicKey, spendingKey, proveFail); !errors.Is(err, errTestProve) {
+		t.Fatalf("Registering an account that fails to prove returned %v", err)
+	}
+	if got := len(database.GetAllUsers()); got != len(users) {
+		t.Errorf("Registration failing to prove added a user")
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+}
+
+func TestApplyTransferSaveFailure(t *testing.T) {
+	store := &testStore{}
+	database := newTestDB(t, store)
+	root := database.GetMerkleRoot()
+	nbRoots := len(database.GetRootHistory())
+	users := database.GetAllUsers()
+	intent := newTestTransfer(t, database, 1, 2)
+
+	store.fail = true
+	if _, err := database.ApplyTransfer(testDepth, intent, proveOK); err == nil {
+		t.Fatalf("Applying a transfer that fails to save should fail")
+	}
+	assertUnchanged(t, database, root, nbRoots, users)
+
+	store.fail = false
+	if _, err := database.ApplyTransfer(testDepth, intent, proveOK); err != nil {
+		t.Fatalf("Failed to apply transfer: %v", err)
+	}
+	if store.saves != 2 {
+		t.Errorf("Store saved %d transitions, want 2", store.saves)
+	}
+	if got := len(database.GetRootHistory()); got != nbRoots+1 {
+		t.Errorf("Root history has %d roots, want %d", got, nbRoots+1)
+	}
+}
+
+// TestPublicKeyResponse checks that the API serves the public keys in their
+// original {"N", "G", "NSquared"} shape.
+func TestPublicKeyResponse(t *testing.T) {
+	keyPair, err := paillier.GenerateKey(rand.Reader, testPaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate Paillier key: %v", err)
+	}
+
+	data, err := json.Marshal(NewPublicKeyResponse(&keyPair.PublicKey))
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal public key: %v", err)
+	}
+	if len(fields) != 3 || string(fields["N"]) != keyPair.N.String() || string(fields["G"]) != keyPair.G.String() || string(fields["NSquared"]) != keyPair.NSquared.String() {
+		t.Errorf("Public key served as %s", data)
+	}
+
+	var resp PublicKeyResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Failed to unmarshal public key: %v", err)
+	}
+	pubKey, err := resp.PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to read public key: %v", err)
+	}
+	if pubKey.N.Cmp(keyPair.N) != 0 || pubKey.NSquared.Cmp(keyPair.NSquared) != 0 {
+		t.Errorf("Public key does not round trip")
+	}
+
+	resp.G = keyPair.N
+	if _, err := resp.PublicKey(); err == nil {
+		t.Errorf("A generator other than n + 1 should be rejected")
+	}
+
+	// Damgard-Jurik keys also carry their degree
+	djResp := NewPublicKeyResponse(paillier.NewDamgardJurikPublicKey(keyPair.N, 2))
+	djKey, err := djResp.PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to read Damgard-Jurik public key: %v", err)
+	}
+	if djKey.S != 2 {
+		t.Errorf("Damgard-Jurik public key has degree %d, want 2", djKey.S)
+	}
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/shreyas-londhe/private-erc20-circuits/hints"
+)
+
+// Prover holds the constraint system and the keys of a circuit, so that they
+// are read once rather than on every proof. They are never modified once
+// loaded, which makes a Prover safe for concurrent use.
+type Prover struct {
+	ccs constraint.ConstraintSystem
+	pk  groth16.ProvingKey
+	vk  groth16.VerifyingKey
+}
+
+// LoadProver reads the constraint system and the keys of the named circuit
+// from exports/<name>.r1cs, exports/<name>.pk and exports/<name>.vk.
+func LoadProver(name string) (*Prover, error) {
+	p := newProver()
+
+	log.Printf("Reading circuit from exports/%s.r1cs", name)
+	if err := readFile("exports/"+name+".r1cs", p.ccs); err != nil {
+		return nil, err
+	}
+	log.Printf("Reading proving key from exports/%s.pk", name)
+	if err := readFile("exports/"+name+".pk", p.pk); err != nil {
+		return nil, err
+	}
+	log.Printf("Reading verifying key from exports/%s.vk", name)
+	if err := readFile("exports/"+name+".vk", p.vk); err != nil {
+		return nil, err
+	}
+	log.Printf("Read keys from exports/%s.pk and exports/%s.vk", name, name)
+
+	return p, nil
+}
+
+// ReadProver reads the constraint system and the keys of a circuit, in the
+// format of the files written by SetupProver.
+func ReadProver(ccs io.Reader, pk io.Reader, vk io.Reader) (*Prover, error) {
+	p := newProver()
+	if _, err := p.ccs.ReadFrom(ccs); err != nil {
+		return nil, fmt.Errorf("reading circuit: %w", err)
+	}
+	if _, err := p.pk.ReadFrom(pk); err != nil {
+		return nil, fmt.Errorf("reading proving key: %w", err)
+	}
+	if _, err := p.vk.ReadFrom(vk); err != nil {
+		return nil, fmt.Errorf("reading verifying key: %w", err)
+	}
+
+	return p, nil
+}
+
+func newProver() *Prover {
+	return &Prover{
+		ccs: groth16.NewCS(ecc.BN254),
+		pk:  groth16.NewProvingKey(ecc.BN254),
+		vk:  groth16.NewVerifyingKey(ecc.BN254),
+	}
+}
+
+// SetupProver compiles the circuit and runs a new setup for it, writing the
+// constraint system, the keys and the Solidity verifier of the named circuit
+// to exports/.
+func SetupProver(name string, circuit frontend.Circuit) (*Prover, error) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		return nil, err
+	}
+	p := &Prover{ccs: ccs, pk: pk, vk: vk}
+
+	if err := os.MkdirAll("exports", 0o755); err != nil {
+		return nil, err
+	}
+	if err := writeFile("exports/"+name+".r1cs", ccs.WriteTo); err != nil {
+		return nil, err
+	}
+	if err := writeFile("exports/"+name+".vk", vk.WriteRawTo); err != nil {
+		return nil, err
+	}
+	if err := writeFile("exports/"+name+".pk", pk.WriteRawTo); err != nil {
+		return nil, err
+	}
+	if err := p.ExportSolidity("exports/" + name + "_verifier.sol"); err != nil {
+		return nil, err
+	}
+	log.Printf("Wrote keys to exports/%s.pk and exports/%s.vk", name, name)
+
+	return p, nil
+}
+
+// NbPublicInputs returns the number of public inputs of the circuit, which is
+// the size of the input array of its Solidity verifier.
+func (p *Prover) NbPublicInputs() int {
+	// The first public variable is the constant one
+	return p.ccs.GetNbPublicVariables() - 1
+}
+
+// ExportSolidity writes the Solidity verifier of the circuit to path.
+func (p *Prover) ExportSolidity(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.vk.ExportSolidity(f)
+}
+
+// Prove proves the witness and checks the proof against the verifying key.
+func (p *Prover) Prove(witness frontend.Circuit) (groth16.Proof, error) {
+	validWitness, err := frontend.NewWitness(witness, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+
+	validPublicWitness, err := validWitness.Public()
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := groth16.Prove(p.ccs, p.pk, validWitness, backend.WithSolverOptions(solver.WithHints(hints.GetHints()...)))
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Proving done.")
+
+	err = groth16.Verify(proof, p.vk, validPublicWitness)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Verifying done.")
+
+	return proof, nil
+}
+
+// ProofData proves the witness and returns the proof along with its public
+// inputs, which must be those of the witness, in the format of the calldata of
+// the Solidity verifier.
+func (p *Prover) ProofData(witness frontend.Circuit, pInputs []*big.Int) (Groth16ProofData, error) {
+	if len(pInputs) != p.NbPublicInputs() {
+		return Groth16ProofData{}, fmt.Errorf("circuit has %d public inputs, got %d", p.NbPublicInputs(), len(pInputs))
+	}
+
+	proof, err := p.Prove(witness)
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return GenerateProofData(proof, pInputs)
+}
+
+// Verify checks a proof, encoded by groth16.Proof.WriteTo, against the public
+// inputs, and returns them in the format of the calldata of the Solidity
+// verifier.
+func (p *Prover) Verify(proofBytes []byte, pInputs []*big.Int) (Groth16ProofData, error) {
+	if len(pInputs) != p.NbPublicInputs() {
+		return Groth16ProofData{}, fmt.Errorf("circuit has %d public inputs, got %d", p.NbPublicInputs(), len(pInputs))
+	}
+
+	proof := groth16.NewProof(ecc.BN254)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	publicWitness, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return Groth16ProofData{}, err
+	}
+	values := make(chan any, len(pInputs))
+	for _, input := range pInputs {
+		values <- input
+	}
+	close(values)
+	if err := publicWitness.Fill(len(pInputs), 0, values); err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	if err := groth16.Verify(proof, p.vk, publicWitness); err != nil {
+		return Groth16ProofData{}, err
+	}
+
+	return GenerateProofData(proof, pInputs)
+}
+
+func readFile(path string, r io.ReaderFrom) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = r.ReadFrom(f)
+	return err
+}
+
+func writeFile(path string, write func(io.Writer) (int64, error)) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = write(f)
+	return err
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
+)
+
+// TestSolidityVerifierInputs checks that the contracts take as many public
+// inputs as the circuits they settle declare. Their number does not depend on
+// the size of the Paillier keys, which keeps the circuits small enough to
+// compile.
+func TestSolidityVerifierInputs(t *testing.T) {
+	const depth, paillierBits = 2, 64
+
+	var transfers []frontend.Circuit
+	for _, clientCustody := range []bool{false, true} {
+		transfer := circuits.NewPrivateCoinCircuit(depth, paillierBits)
+		transfer.ClientCustody = clientCustody
+		hidden := circuits.NewHiddenTransferCircuit(depth, paillierBits)
+		hidden.Transfer.ClientCustody = clientCustody
+		transfers = append(transfers, &transfer, &hidden)
+	}
+	deposit := circuits.NewDepositCircuit(depth, paillierBits)
+	withdraw := circuits.NewWithdrawCircuit(depth, paillierBits)
+
+	for _, c := range []struct {
+		verifier string
+		proof    string
+		circuits []frontend.Circuit
+	}{
+		{"Verifier.sol", "ZkProof", transfers},
+		{"DepositVerifier.sol", "DepositProof", []frontend.Circuit{&deposit}},
+		{"WithdrawVerifier.sol", "WithdrawProof", []frontend.Circuit{&withdraw}},
+	} {
+		verifierInputs := solidityArraySize(t, "../../contracts/contracts/"+c.verifier, `uint256\[(\d+)\] calldata input`)
+		contractInputs := solidityArraySize(t, "../../contracts/contracts/SecretSpend.sol", `struct `+c.proof+` \{\s*uint256\[8\] proof;\s*uint256\[(\d+)\] input;`)
+
+		for _, circuit := range c.circuits {
+			ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nbInputs := (&Prover{ccs: ccs}).NbPublicInputs()
+			if nbInputs != verifierInputs || nbInputs != contractInputs {
+				t.Errorf("%T has %d public inputs, %s takes %d and %s %d", circuit, nbInputs, c.verifier, verifierInputs, c.proof, contractInputs)
+			}
+		}
+	}
+}
+
+// solidityArraySize returns the size of the arrays matched by pattern in the
+// Solidity file, which must all have the same size.
+func solidityArraySize(t *testing.T, path string, pattern string) int {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := -1
+	for _, match := range regexp.MustCompile(pattern).FindAllSubmatch(source, -1) {
+		n, err := strconv.Atoi(string(match[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != -1 && n != size {
+			t.Fatalf("%s declares inputs of sizes %d and %d", path, size, n)
+		}
+		size = n
+	}
+	if size == -1 {
+		t.Fatalf("%s declares no inputs", path)
+	}
+	return size
+}
+
+type squareCircuit struct {
+	X frontend.Variable `gnark:",public"`
+	Y frontend.Variable
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.X, api.Mul(c.Y, c.Y))
+	return nil
+}
+
+// TestProverVerify checks proofs submitted in their encoded form against the
+// public inputs.
+func TestProverVerify(t *testing.T) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Prover{ccs: ccs, pk: pk, vk: vk}
+
+	proof, err := p.Prove(&squareCircuit{X: 9, Y: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	proofData, err := p.Verify(buf.Bytes(), []*big.Int{big.NewInt(9)})
+	if err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	if len(proofData.Proof) != 8 || len(proofData.Inputs) != 1 {
+		t.Errorf("Proof data has %d proof elements and %d inputs", len(proofData.Proof), len(proofData.Inputs))
+	}
+
+	if _, err := p.Verify(buf.Bytes(), []*big.Int{big.NewInt(16)}); err == nil {
+		t.Errorf("Verifying a proof against other inputs should fail")
+	}
+	if _, err := p.Verify(buf.Bytes(), nil); err == nil {
+		t.Errorf("Verifying a proof without its inputs should fail")
+	}
+	if _, err := p.Verify(buf.Bytes()[:10], []*big.Int{big.NewInt(9)}); err == nil {
+		t.Errorf("Verifying a truncated proof should fail")
+	}
+}
@@ -0,0 +1,531 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+)
+
+// Store persists the state of a DB across restarts.
+type Store interface {
+	// Load returns the persisted state, which is empty for a new store.
+	Load() (State, error)
+	// Save persists the users updated by a state transition and the
+	// balances root it resulted in.
+	Save(users []UserData, root []byte) error
+	Close() error
+}
+
+// State is the persisted state of a DB: the users ordered by index, the hashes
+// of their leaves and the history of the balances roots, oldest first.
+type State struct {
+	Users      []UserData
+	LeafHashes [][]byte
+	Roots      [][]byte
+}
+
+// storedUser is the JSON encoding of a UserData and of the hash of its leaf.
+// The Paillier and spending private keys are only stored when the server
+// holds them, sealed under the key of the store, see FileStore.
+type storedUser struct {
+	Index           int    `json:"index"`
+	N               string `json:"n"`
+	PrivKey         string `json:"privKey,omitempty"`
+	SpendingKey     string `json:"spendingKey"`
+	SpendingPrivKey string `json:"spendingPrivKey,omitempty"`
+	Nonce           string `json:"nonce"`
+	Balance         string `json:"balance,omitempty"`
+	Blinding        string `json:"blinding,omitempty"`
+	EncBalance      string `json:"encBalance"`
+	Commitment      string `json:"commitment"`
+	LeafHash        string `json:"leafHash"`
+}
+
+// logEntry is a state transition in the log. Seq is the position of Root in
+// the root history, which makes replaying an entry already covered by the
+// snapshot a no-op.
+type logEntry struct {
+	Seq   int          `json:"seq"`
+	Users []storedUser `json:"users"`
+	Root  string       `json:"root"`
+}
+
+type snapshot struct {
+	Users []storedUser `json:"users"`
+	Roots []string     `json:"roots"`
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+	saltFile     = "salt"
+
+	// DefaultSnapshotInterval is the number of log entries after which a
+	// FileStore writes a new snapshot and truncates its log.
+	DefaultSnapshotInterval = 100
+)
+
+// ErrNoPassphrase is returned when saving or loading private keys in a
+// FileStore opened without a passphrase.
+var ErrNoPassphrase = errors.New("private keys are only stored under a passphrase")
+
+// FileStore is a Store keeping an append-only log of the state transitions in
+// a directory, compacted into a snapshot every SnapshotInterval entries. The
+// files are only readable by their owner, and the private keys are sealed with
+// AES-256-GCM under a key derived from the passphrase of the store with
+// scrypt, see paillier.PassphraseAEAD.
+type FileStore struct {
+	SnapshotInterval int
+
+	dir       string
+	aead      cipher.AEAD
+	log       *os.File
+	nbEntries int
+	users     []storedUser
+	roots     []string
+}
+
+// OpenFileStore opens the store in dir, creating it if needed, and recovers
+// its state from the snapshot and the log. A truncated last log entry, left by
+// a crash during a write, is dropped. Without a passphrase, the store only
+// holds accounts whose private keys the server does not know.
+func OpenFileStore(dir string, passphrase []byte) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	// MkdirAll keeps the permissions of an existing directory
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		SnapshotInterval: DefaultSnapshotInterval,
+		dir:              dir,
+	}
+	if len(passphrase) > 0 {
+		salt, err := s.salt()
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = paillier.PassphraseAEAD(passphrase, salt); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		s.users, s.roots = snap.Users, snap.Roots
+	}
+
+	s.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// salt returns the salt of the key derivation, generated on the first use of
+// the store.
+func (s *FileStore) salt() ([]byte, error) {
+	path := filepath.Join(s.dir, saltFile)
+	salt, err := os.ReadFile(path)
+	if err == nil {
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	salt = make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err := writePrivateFile(path, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// replay applies the complete entries of the log and truncates it after the
+// last one.
+func (s *FileStore) replay() error {
+	reader := bufio.NewReader(s.log)
+	var end int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("reading log entry %d: %w", s.nbEntries, err)
+		}
+		if err := s.apply(entry); err != nil {
+			return err
+		}
+		end += int64(len(line))
+		s.nbEntries++
+	}
+
+	if err := s.log.Truncate(end); err != nil {
+		return err
+	}
+	_, err := s.log.Seek(end, io.SeekStart)
+	return err
+}
+
+func (s *FileStore) apply(entry logEntry) error {
+	if entry.Seq < len(s.roots) {
+		return nil
+	}
+	users, err := s.applied(entry)
+	if err != nil {
+		return err
+	}
+	s.users = users
+	s.roots = append(s.roots, entry.Root)
+
+	return nil
+}
+
+// applied returns the users after the entry, which must follow the last root,
+// without updating the state of the store.
+func (s *FileStore) applied(entry logEntry) ([]storedUser, error) {
+	if entry.Seq != len(s.roots) {
+		return nil, fmt.Errorf("missing log entries before entry %d", entry.Seq)
+	}
+
+	users := append([]storedUser(nil), s.users...)
+	for _, user := range entry.Users {
+		switch {
+		case user.Index < len(users):
+			users[user.Index] = user
+		case user.Index == len(users):
+			users = append(users, user)
+		default:
+			return nil, fmt.Errorf("log entry %d skips user %d", entry.Seq, len(users))
+		}
+	}
+
+	return users, nil
+}
+
+func (s *FileStore) Load() (State, error) {
+	var state State
+	for _, stored := range s.users {
+		user, err := s.userData(stored)
+		if err != nil {
+			return State{}, fmt.Errorf("user %d: %w", stored.Index, err)
+		}
+		leafHash, err := hex.DecodeString(stored.LeafHash)
+		if err != nil {
+			return State{}, fmt.Errorf("user %d: %w", stored.Index, err)
+		}
+		state.Users = append(state.Users, user)
+		state.LeafHashes = append(state.LeafHashes, leafHash)
+	}
+	for _, root := range s.roots {
+		decoded, err := hex.DecodeString(root)
+		if err != nil {
+			return State{}, err
+		}
+		state.Roots = append(state.Roots, decoded)
+	}
+
+	return state, nil
+}
+
+// Save appends the transition to the log and syncs it before returning. The
+// transition is checked against the state of the store first, so that the log
+// never holds an entry which fails to replay.
+func (s *FileStore) Save(users []UserData, root []byte) error {
+	entry := logEntry{
+		Seq:  len(s.roots),
+		Root: hex.EncodeToString(root),
+	}
+	for _, user := range users {
+		stored, err := s.storedUser(user)
+		if err != nil {
+			return err
+		}
+		entry.Users = append(entry.Users, stored)
+	}
+
+	applied, err := s.applied(entry)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := s.appendLog(line); err != nil {
+		return err
+	}
+	s.users = applied
+	s.roots = append(s.roots, entry.Root)
+
+	// The transition is persisted by the log, a failed snapshot is retried
+	// on the next one
+	s.nbEntries++
+	if s.nbEntries >= s.SnapshotInterval {
+		if err := s.snapshot(); err != nil {
+			log.Println("Error writing snapshot:", err)
+		}
+	}
+
+	return nil
+}
+
+// appendLog appends the line to the log and syncs it. A failed write is
+// truncated, so that the log never holds a partial entry followed by others.
+func (s *FileStore) appendLog(line []byte) error {
+	end, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.log.Write(append(line, '\n'))
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		s.log.Truncate(end)
+		s.log.Seek(end, io.SeekStart)
+		return err
+	}
+
+	return nil
+}
+
+// snapshot atomically replaces the snapshot with the current state and then
+// truncates the log.
+func (s *FileStore) snapshot() error {
+	data, err := json.Marshal(snapshot{Users: s.users, Roots: s.roots})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writePrivateFile(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.nbEntries = 0
+
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
+
+// writePrivateFile writes the data to a new file only readable by its owner and
+// syncs it.
+func writePrivateFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// seal encrypts the private key of the account of modulus n. The modulus is
+// authenticated along with the key, which binds it to the account.
+func (s *FileStore) seal(plainText []byte, n *big.Int) (string, error) {
+	if s.aead == nil {
+		return "", ErrNoPassphrase
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(s.aead.Seal(nonce, nonce, plainText, n.Bytes())), nil
+}
+
+// open decrypts a private key sealed by seal.
+func (s *FileStore) open(sealed string, n *big.Int) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrNoPassphrase
+	}
+	data, err := hex.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("invalid sealed private key")
+	}
+	plainText, err := s.aead.Open(nil, data[:s.aead.NonceSize()], data[s.aead.NonceSize():], n.Bytes())
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted private key")
+	}
+	return plainText, nil
+}
+
+func (s *FileStore) storedUser(user UserData) (storedUser, error) {
+	leafHash, err := convertToLeaf(user).CalculateHash()
+	if err != nil {
+		return storedUser{}, err
+	}
+
+	stored := storedUser{
+		Index:       user.Index,
+		N:           user.KeyPair.N.String(),
+		SpendingKey: hex.EncodeToString(user.SpendingKey.PublicKey.Bytes()),
+		Nonce:       user.Nonce.String(),
+		EncBalance:  user.EncBalance.String(),
+		Commitment:  EncodePoint(user.BalanceCommitment),
+		LeafHash:    hex.EncodeToString(leafHash),
+	}
+	if p, _ := user.KeyPair.Primes(); p != nil {
+		privKey, err := user.KeyPair.MarshalBinary()
+		if err != nil {
+			return storedUser{}, err
+		}
+		if stored.PrivKey, err = s.seal(privKey, user.KeyPair.N); err != nil {
+			return storedUser{}, err
+		}
+	}
+	if hasSpendingPrivKey(user.SpendingKey) {
+		if stored.SpendingPrivKey, err = s.seal(user.SpendingKey.Bytes(), user.KeyPair.N); err != nil {
+			return storedUser{}, err
+		}
+	}
+	if user.Balance != nil {
+		stored.Balance = user.Balance.String()
+	}
+	if user.Blinding != nil {
+		stored.Blinding = user.Blinding.String()
+	}
+
+	return stored, nil
+}
+
+// hasSpendingPrivKey reports whether the key holds a private scalar, rather
+// than only the public key of an account registered by its owner.
+func hasSpendingPrivKey(key *eddsa.PrivateKey) bool {
+	var pub eddsa.PublicKey
+	size := len(pub.Bytes())
+	scalar := key.Bytes()[size : 2*size]
+	return !bytes.Equal(scalar, make([]byte, len(scalar)))
+}
+
+func (s *FileStore) userData(stored storedUser) (UserData, error) {
+	user := UserData{Index: stored.Index}
+
+	n, ok := new(big.Int).SetString(stored.N, 10)
+	if !ok {
+		return UserData{}, errors.New("invalid n")
+	}
+	if stored.PrivKey != "" {
+		data, err := s.open(stored.PrivKey, n)
+		if err != nil {
+			return UserData{}, err
+		}
+		user.KeyPair = new(paillier.PrivateKey)
+		if err := user.KeyPair.UnmarshalBinary(data); err != nil {
+			return UserData{}, err
+		}
+		if user.KeyPair.N.Cmp(n) != 0 {
+			return UserData{}, errors.New("paillier private key does not match n")
+		}
+	} else {
+		user.KeyPair = &paillier.PrivateKey{PublicKey: *paillier.NewPublicKey(n)}
+	}
+
+	user.SpendingKey = new(eddsa.PrivateKey)
+	if stored.SpendingPrivKey != "" {
+		privKey, err := s.open(stored.SpendingPrivKey, n)
+		if err != nil {
+			return UserData{}, err
+		}
+		if _, err := user.SpendingKey.SetBytes(privKey); err != nil {
+			return UserData{}, err
+		}
+	} else {
+		pubKey, err := hex.DecodeString(stored.SpendingKey)
+		if err != nil {
+			return UserData{}, err
+		}
+		if _, err := user.SpendingKey.PublicKey.SetBytes(pubKey); err != nil {
+			return UserData{}, err
+		}
+	}
+
+	var err error
+	if user.Nonce, err = parseInt(stored.Nonce); err != nil {
+		return UserData{}, err
+	}
+	if user.EncBalance, err = parseInt(stored.EncBalance); err != nil {
+		return UserData{}, err
+	}
+	if stored.Balance != "" {
+		if user.Balance, err = parseInt(stored.Balance); err != nil {
+			return UserData{}, err
+		}
+	}
+	if stored.Blinding != "" {
+		if user.Blinding, err = parseInt(stored.Blinding); err != nil {
+			return UserData{}, err
+		}
+	}
+	if user.BalanceCommitment, err = DecodePoint(stored.Commitment); err != nil {
+		return UserData{}, err
+	}
+
+	return user, nil
+}
+
+func parseInt(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", s)
+	}
+	return v, nil
+}
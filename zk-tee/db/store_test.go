@@ -0,0 +1,301 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+func openTestStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	store, err := OpenFileStore(dir, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	return store
+}
+
+// reopen closes the DB and recovers a new one from the same directory.
+func reopen(t *testing.T, database *DB, dir string) *DB {
+	t.Helper()
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+	recovered, err := New(testDepth, openTestStore(t, dir))
+	if err != nil {
+		t.Fatalf("Failed to recover DB: %v", err)
+	}
+	recovered.Domain = testDomain
+	return recovered
+}
+
+func assertRecovered(t *testing.T, recovered *DB, database *DB) {
+	t.Helper()
+	if !bytes.Equal(recovered.GetMerkleRoot(), database.GetMerkleRoot()) {
+		t.Errorf("Recovered root does not match")
+	}
+	if got, want := len(recovered.GetRootHistory()), len(database.GetRootHistory()); got != want {
+		t.Errorf("Recovered %d roots, want %d", got, want)
+	}
+	users := database.GetAllUsers()
+	recoveredUsers := recovered.GetAllUsers()
+	if len(recoveredUsers) != len(users) {
+		t.Fatalf("Recovered %d users, want %d", len(recoveredUsers), len(users))
+	}
+	for i, user := range recoveredUsers {
+		if user.Nonce.Cmp(users[i].Nonce) != 0 || user.Balance.Cmp(users[i].Balance) != 0 || user.EncBalance.Cmp(users[i].EncBalance) != 0 || user.Blinding.Cmp(users[i].Blinding) != 0 || !user.BalanceCommitment.Equal(&users[i].BalanceCommitment) {
+			t.Errorf("Recovered user %d does not match", i)
+		}
+		if p, _ := user.KeyPair.Primes(); p == nil || user.KeyPair.N.Cmp(users[i].KeyPair.N) != 0 {
+			t.Errorf("Recovered Paillier key of user %d does not match", i)
+		}
+		if !bytes.Equal(user.SpendingKey.Bytes(), users[i].SpendingKey.Bytes()) {
+			t.Errorf("Recovered spending key of user %d does not match", i)
+		}
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	database := newTestDB(t, openTestStore(t, dir))
+	if _, err := database.ApplyDeposit(testDepth, 1, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	intent := newTestTransfer(t, database, 1, 2)
+	if _, err := database.ApplyTransfer(testDepth, intent, proveOK); err != nil {
+		t.Fatalf("Failed to apply transfer: %v", err)
+	}
+
+	recovered := reopen(t, database, dir)
+	defer recovered.Close()
+	assertRecovered(t, recovered, database)
+
+	// The recovered DB keeps applying transitions
+	intent = newTestTransfer(t, recovered, 2, 0)
+	if _, err := recovered.ApplyTransfer(testDepth, intent, proveOK); err != nil {
+		t.Fatalf("Failed to apply transfer after recovery: %v", err)
+	}
+}
+
+func TestFileStoreTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	database := newTestDB(t, openTestStore(t, dir))
+	if _, err := database.ApplyDeposit(testDepth, 0, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+
+	// A crash during a write leaves a partial last entry
+	logPath := filepath.Join(dir, logFile)
+	complete, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if err := os.WriteFile(logPath, append(bytes.Clone(complete), `{"seq":2,"users":[{"ind`...), 0o600); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	recovered := reopen(t, database, dir)
+	assertRecovered(t, recovered, database)
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !bytes.Equal(data, complete) {
+		t.Errorf("Partial entry was not truncated")
+	}
+
+	// The next entry follows the last complete one
+	if _, err := recovered.ApplyDeposit(testDepth, 0, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit after recovery: %v", err)
+	}
+	again := reopen(t, recovered, dir)
+	defer again.Close()
+	assertRecovered(t, again, recovered)
+}
+
+func TestFileStoreRejectedSave(t *testing.T) {
+	dir := t.TempDir()
+	database := newTestDB(t, openTestStore(t, dir))
+	logPath := filepath.Join(dir, logFile)
+	complete, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+
+	// A transition skipping a user is rejected before it reaches the log
+	user := newTestUsers(t, 1)[0]
+	user.Index = len(database.GetAllUsers()) + 1
+	if err := database.store.Save([]UserData{user}, database.GetMerkleRoot()); err == nil {
+		t.Fatalf("Saving a transition skipping a user should fail")
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !bytes.Equal(data, complete) {
+		t.Errorf("Rejected transition was appended to the log")
+	}
+
+	// The store keeps saving and recovering the valid transitions
+	if _, err := database.ApplyDeposit(testDepth, 0, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	recovered := reopen(t, database, dir)
+	defer recovered.Close()
+	assertRecovered(t, recovered, database)
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	store.SnapshotInterval = 2
+	database := newTestDB(t, store)
+	for i := 0; i < 2; i++ {
+		if _, err := database.ApplyDeposit(testDepth, i, big.NewInt(5), nil, proveOK); err != nil {
+			t.Fatalf("Failed to apply deposit: %v", err)
+		}
+	}
+
+	// The users and the first deposit were compacted, the second deposit
+	// starts the new log
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("Snapshot not written: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if got := bytes.Count(data, []byte("\n")); got != 1 {
+		t.Errorf("Log holds %d entries after the snapshot, want 1", got)
+	}
+
+	recovered := reopen(t, database, dir)
+	defer recovered.Close()
+	assertRecovered(t, recovered, database)
+}
+
+func TestFileStoreSecrets(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	store.SnapshotInterval = 2
+	database := newTestDB(t, store)
+	if _, err := database.ApplyDeposit(testDepth, 0, big.NewInt(5), nil, proveOK); err != nil {
+		t.Fatalf("Failed to apply deposit: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close DB: %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Failed to stat store: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		t.Errorf("Store directory has permissions %o, want 700", perm)
+	}
+	for _, name := range []string{logFile, saltFile, snapshotFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("%s has permissions %o, want 600", name, perm)
+		}
+	}
+
+	// The private keys are not stored in the clear
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	for _, user := range database.GetAllUsers() {
+		p, _ := user.KeyPair.Primes()
+		if strings.Contains(string(data), p.String()) {
+			t.Errorf("Snapshot holds the Paillier prime of user %d", user.Index)
+		}
+		if strings.Contains(string(data), hex.EncodeToString(user.SpendingKey.Bytes())) {
+			t.Errorf("Snapshot holds the spending key of user %d", user.Index)
+		}
+	}
+
+	store, err = OpenFileStore(dir, []byte("wrong passphrase"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, err := New(testDepth, store); err == nil {
+		t.Errorf("Recovering the store under a wrong passphrase should fail")
+	}
+	store.Close()
+
+	// Without a passphrase, only public keys can be stored
+	store, err = OpenFileStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	withoutPassphrase, err := New(testDepth, store)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := withoutPassphrase.AddUsers(newTestUsers(t, 1)); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Storing private keys without a passphrase returned %v, want ErrNoPassphrase", err)
+	}
+}
+
+// TestNewChecksStoredState checks that the DB refuses to recover users that do
+// not match their stored leaf hashes, or a tree that does not match the
+// stored root.
+func TestNewChecksStoredState(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		tamper func(entry map[string]any)
+	}{
+		{"leaf", func(entry map[string]any) {
+			user := entry["users"].([]any)[0].(map[string]any)
+			user["nonce"] = "7"
+		}},
+		{"root", func(entry map[string]any) {
+			entry["root"] = strings.Repeat("00", 32)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			database := newTestDB(t, openTestStore(t, dir))
+			if err := database.Close(); err != nil {
+				t.Fatalf("Failed to close DB: %v", err)
+			}
+
+			logPath := filepath.Join(dir, logFile)
+			data, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			var entry map[string]any
+			if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+				t.Fatalf("Failed to parse log entry: %v", err)
+			}
+			test.tamper(entry)
+			data, err = json.Marshal(entry)
+			if err != nil {
+				t.Fatalf("Failed to encode log entry: %v", err)
+			}
+			if err := os.WriteFile(logPath, append(data, '\n'), 0o600); err != nil {
+				t.Fatalf("Failed to write log: %v", err)
+			}
+
+			store := openTestStore(t, dir)
+			defer store.Close()
+			if _, err := New(testDepth, store); err == nil {
+				t.Errorf("Recovering a tampered store should fail")
+			}
+		})
+	}
+}
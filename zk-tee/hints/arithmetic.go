@@ -1,6 +1,25 @@
 package hints
 
-import "math/big"
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// GetHints returns all the hints used by the circuits, to be passed to the
+// solver when proving.
+func GetHints() []solver.Hint {
+	return []solver.Hint{
+		DivModHint,
+		MulLimbsHint,
+		MulModLimbsHint,
+		SubLimbsHint,
+		CarryLimbsHint,
+		ModInverseLimbsHint,
+	}
+}
 
 func DivModHint(_ *big.Int, inputs, outputs []*big.Int) error {
 	quotient := new(big.Int)
@@ -14,3 +33,106 @@ func DivModHint(_ *big.Int, inputs, outputs []*big.Int) error {
 
 	return nil
 }
+
+// MulLimbsHint computes the product of two integers given as little-endian
+// limbs. The inputs are laid out as [len(a), a..., b...] and the product is
+// returned in len(outputs) limbs.
+func MulLimbsHint(_ *big.Int, inputs, outputs []*big.Int) error {
+	nbA := int(inputs[0].Int64())
+	a := utils.FromLimbs(inputs[1 : 1+nbA])
+	b := utils.FromLimbs(inputs[1+nbA:])
+
+	return setLimbs(outputs, new(big.Int).Mul(a, b))
+}
+
+// MulModLimbsHint computes the quotient and the remainder of a*b by m, where
+// all the operands are given as little-endian limbs. The inputs are laid out
+// as [len(a), len(b), a..., b..., m...] and the outputs as [q..., r...], with
+// r taking len(m) limbs and q the remaining ones.
+func MulModLimbsHint(_ *big.Int, inputs, outputs []*big.Int) error {
+	nbA := int(inputs[0].Int64())
+	nbB := int(inputs[1].Int64())
+	a := utils.FromLimbs(inputs[2 : 2+nbA])
+	b := utils.FromLimbs(inputs[2+nbA : 2+nbA+nbB])
+	mLimbs := inputs[2+nbA+nbB:]
+	m := utils.FromLimbs(mLimbs)
+	if m.Sign() == 0 {
+		return errors.New("hints: modulus is zero")
+	}
+
+	q, r := new(big.Int).QuoRem(new(big.Int).Mul(a, b), m, new(big.Int))
+
+	nbQ := len(outputs) - len(mLimbs)
+	if err := setLimbs(outputs[:nbQ], q); err != nil {
+		return err
+	}
+	return setLimbs(outputs[nbQ:], r)
+}
+
+// ModInverseLimbsHint computes the inverse of a modulo m, where both operands
+// are given as little-endian limbs laid out as [len(a), a..., m...]. The
+// inverse is returned in len(m) limbs.
+func ModInverseLimbsHint(_ *big.Int, inputs, outputs []*big.Int) error {
+	nbA := int(inputs[0].Int64())
+	a := utils.FromLimbs(inputs[1 : 1+nbA])
+	m := utils.FromLimbs(inputs[1+nbA:])
+	if m.Sign() == 0 {
+		return errors.New("hints: modulus is zero")
+	}
+
+	inv := new(big.Int).ModInverse(a, m)
+	if inv == nil {
+		return errors.New("hints: operand is not invertible")
+	}
+
+	return setLimbs(outputs, inv)
+}
+
+// SubLimbsHint computes a-b, where both operands are given as little-endian
+// limbs laid out as [len(a), a..., b...]. The difference must not be negative.
+func SubLimbsHint(_ *big.Int, inputs, outputs []*big.Int) error {
+	nbA := int(inputs[0].Int64())
+	a := utils.FromLimbs(inputs[1 : 1+nbA])
+	b := utils.FromLimbs(inputs[1+nbA:])
+
+	diff := new(big.Int).Sub(a, b)
+	if diff.Sign() < 0 {
+		return errors.New("hints: negative difference")
+	}
+
+	return setLimbs(outputs, diff)
+}
+
+// CarryLimbsHint computes the carries needed to show that the signed
+// coefficients in inputs, taken as limbs, sum up to zero. Negative values are
+// represented by their opposite modulo the field.
+func CarryLimbsHint(field *big.Int, inputs, outputs []*big.Int) error {
+	half := new(big.Int).Rsh(field, 1)
+	base := new(big.Int).Lsh(big.NewInt(1), utils.LimbBits)
+
+	carry := new(big.Int)
+	for i := range outputs {
+		coeff := new(big.Int).Set(inputs[i])
+		if coeff.Cmp(half) > 0 {
+			coeff.Sub(coeff, field)
+		}
+		carry.Add(carry, coeff)
+		carry.Div(carry, base)
+
+		outputs[i].Mod(carry, field)
+	}
+
+	return nil
+}
+
+func setLimbs(outputs []*big.Int, x *big.Int) error {
+	if x.BitLen() > len(outputs)*utils.LimbBits {
+		return errors.New("hints: result does not fit in the given number of limbs")
+	}
+
+	for i, limb := range utils.ToLimbs(x, len(outputs)) {
+		outputs[i].Set(limb)
+	}
+
+	return nil
+}
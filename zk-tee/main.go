@@ -1,23 +1,36 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
 	"github.com/shreyas-londhe/private-erc20-circuits/db"
 	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
 )
 
 var database *db.DB
 
+// exportProofs also writes every proof to exports/proof_data.json
+var exportProofs bool
+
+// Provers of the circuits of the server, loaded once at startup. The server
+// runs with the provers of either the server or the client custody mode, see
+// db.TransferCircuitName.
+var provers *db.Provers
+
 const (
 	depth    int = 5
-	numUsers int = 1 << depth
+	numUsers int = 1 << (depth - 1) // leaves room for accounts registered through /accounts
 )
 
 func corsMiddleware(next http.Handler) http.Handler {
@@ -44,11 +57,14 @@ func getAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 	var response []db.UserResponse
 	for _, user := range users {
 		response = append(response, db.UserResponse{
-			KeyPair:    &user.KeyPair.PublicKey,
-			Balance:    user.Balance.String(),
-			Index:      user.Index,
-			EncBalance: user.EncBalance.String(),
-			EncR:       user.EncR.String(),
+			KeyPair:           db.NewPublicKeyResponse(&user.KeyPair.PublicKey),
+			SpendingKey:       hex.EncodeToString(user.SpendingKey.PublicKey.Bytes()),
+			Nonce:             user.Nonce.String(),
+			Balance:           optionalString(user.Balance),
+			Index:             user.Index,
+			EncBalance:        user.EncBalance.String(),
+			BalanceCommitment: db.EncodePoint(user.BalanceCommitment),
+			Blinding:          optionalString(user.Blinding),
 		})
 	}
 
@@ -64,24 +80,31 @@ func getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := database.GetUser(index)
-	if user.Balance == nil {
+	if index < 0 || index >= len(database.GetAllUsers()) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	user := database.GetUser(index)
 
 	type response struct {
-		KeyPair    *paillier.PublicKey `json:"keyPair"`
-		Balance    string              `json:"balance"`
-		EncBalance string              `json:"encBalance"`
-		EncR       string              `json:"encR"`
+		KeyPair     db.PublicKeyResponse `json:"keyPair"`
+		SpendingKey string               `json:"spendingKey"`
+		Nonce       string               `json:"nonce"`
+		Balance     string               `json:"balance,omitempty"`
+		EncBalance  string               `json:"encBalance"`
+		// BalanceCommitment is the compressed point, hex encoded.
+		BalanceCommitment string `json:"balanceCommitment"`
+		Blinding          string `json:"blinding,omitempty"`
 	}
 
 	resp := response{
-		KeyPair:    &user.KeyPair.PublicKey,
-		Balance:    user.Balance.String(),
-		EncBalance: user.EncBalance.String(),
-		EncR:       user.EncR.String(),
+		KeyPair:           db.NewPublicKeyResponse(&user.KeyPair.PublicKey),
+		SpendingKey:       hex.EncodeToString(user.SpendingKey.PublicKey.Bytes()),
+		Nonce:             user.Nonce.String(),
+		Balance:           optionalString(user.Balance),
+		EncBalance:        user.EncBalance.String(),
+		BalanceCommitment: db.EncodePoint(user.BalanceCommitment),
+		Blinding:          optionalString(user.Blinding),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -89,8 +112,8 @@ func getUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getMerkleRootHandler(w http.ResponseWriter, r *http.Request) {
-	tree := database.GetMerkleTree()
-	if tree == nil {
+	root := database.GetMerkleRoot()
+	if root == nil {
 		http.Error(w, "Merkle tree not found", http.StatusNotFound)
 		return
 	}
@@ -100,23 +123,101 @@ func getMerkleRootHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := response{
-		MerkleRoot: tree.MerkleRoot(),
+		MerkleRoot: root,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func transferFundsHandler(w http.ResponseWriter, r *http.Request) {
+func getMerklePathHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request parameters
-	fromIndex, err := strconv.Atoi(r.URL.Query().Get("fromIndex"))
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	root, siblings, err := database.GetMerklePath(index)
 	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	type response struct {
+		Root     string   `json:"root"`
+		Siblings []string `json:"siblings"`
+	}
+
+	resp := response{
+		Root: hex.EncodeToString(root),
+	}
+	for _, sibling := range siblings {
+		resp.Siblings = append(resp.Siblings, hex.EncodeToString(sibling))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func transferMessageHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	numRegistered := len(database.GetAllUsers())
+
+	fromIndex, err := strconv.Atoi(r.URL.Query().Get("fromIndex"))
+	if err != nil || fromIndex < 0 || fromIndex >= numRegistered {
 		http.Error(w, "Invalid fromIndex", http.StatusBadRequest)
 		return
 	}
 
 	toIndex, err := strconv.Atoi(r.URL.Query().Get("toIndex"))
+	if err != nil || toIndex < 0 || toIndex >= numRegistered {
+		http.Error(w, "Invalid toIndex", http.StatusBadRequest)
+		return
+	}
+
+	// The sender commits to the amount, see db.TransferMessage
+	amountCommitment, err := db.DecodePoint(r.URL.Query().Get("amountCommitment"))
+	if err != nil {
+		http.Error(w, "Invalid amountCommitment", http.StatusBadRequest)
+		return
+	}
+
+	// The message covers the current root and nonce of the sender
+	msg, root, nonce, err := database.TransferMessage(fromIndex, toIndex, amountCommitment)
 	if err != nil {
+		http.Error(w, "Error computing message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Message string `json:"message"`
+		Nonce   string `json:"nonce"`
+		Root    string `json:"root"`
+	}
+
+	resp := response{
+		Message: hex.EncodeToString(msg),
+		Nonce:   nonce.String(),
+		Root:    hex.EncodeToString(root),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func transferFundsHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	numRegistered := len(database.GetAllUsers())
+
+	fromIndex, err := strconv.Atoi(r.URL.Query().Get("fromIndex"))
+	if err != nil || fromIndex < 0 || fromIndex >= numRegistered {
+		http.Error(w, "Invalid fromIndex", http.StatusBadRequest)
+		return
+	}
+
+	toIndex, err := strconv.Atoi(r.URL.Query().Get("toIndex"))
+	if err != nil || toIndex < 0 || toIndex >= numRegistered {
 		http.Error(w, "Invalid toIndex", http.StatusBadRequest)
 		return
 	}
@@ -133,54 +234,466 @@ func transferFundsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tree := database.GetMerkleTree()
-	users := database.GetAllUsers()
+	// The sender commits to the amount with amountBlinding and signs the
+	// message returned by /transfer-message with its spending key
+	amountBlinding, ok := new(big.Int).SetString(r.URL.Query().Get("amountBlinding"), 10)
+	if !ok {
+		http.Error(w, "Invalid amountBlinding", http.StatusBadRequest)
+		return
+	}
+
+	nonce, ok := new(big.Int).SetString(r.URL.Query().Get("nonce"), 10)
+	if !ok {
+		http.Error(w, "Invalid nonce", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	// The root returned by /transfer-message, which the transfer must still
+	// apply to
+	root, err := hex.DecodeString(r.URL.Query().Get("root"))
+	if err != nil || len(root) == 0 {
+		http.Error(w, "Invalid root", http.StatusBadRequest)
+		return
+	}
+
+	opening, ok := parseOpening(r)
+	if !ok {
+		http.Error(w, "Invalid opening", http.StatusBadRequest)
+		return
+	}
 
-	witness, pInputs, fromUser, toUser, newTree, err := db.GenerateTransferWitness(depth, *tree, users, fromIndex, toIndex, amount)
+	// The server only holds the prover of the transfer circuit of its mode,
+	// which depends on the custody of the recipient account
+	if (database.GetUser(toIndex).Balance == nil) != database.ClientCustody {
+		http.Error(w, "Recipient account custody does not match the server mode", http.StatusBadRequest)
+		return
+	}
+
+	intent := db.TransferIntent{
+		OldRoot:        root,
+		FromIndex:      fromIndex,
+		ToIndex:        toIndex,
+		Amount:         amount,
+		AmountBlinding: amountBlinding,
+		Nonce:          nonce,
+		Signature:      signature,
+		FromOpening:    opening,
+	}
+
+	var proofData db.Groth16ProofData
+	// In hidden mode the leaves are private and only the roots and a
+	// commitment to the new leaves are published
+	if r.URL.Query().Get("hidden") == "true" {
+		proofData, err = database.ApplyHiddenTransfer(depth, intent, provers.HiddenTransfer.ProofData)
+		if err != nil {
+			writeTransitionError(w, "transfer", err, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		proofData, err = database.ApplyTransfer(depth, intent, provers.Transfer.ProofData)
+		if err != nil {
+			writeTransitionError(w, "transfer", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeProof(w, proofData)
+}
+
+// submitTransferHandler applies a transfer proven by the wallet of the sender,
+// see wallet.Wallet.SubmitTransfer. The server never learns the balances, and
+// only checks the proof against the current state. It learns the amount, with
+// which it derives the new leaf of the recipient, see db.ReceiveLeaf.
+func submitTransferHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	fromIndex, err := strconv.Atoi(r.URL.Query().Get("fromIndex"))
 	if err != nil {
-		http.Error(w, "Error generating witness: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid fromIndex", http.StatusBadRequest)
+		return
+	}
+
+	toIndex, err := strconv.Atoi(r.URL.Query().Get("toIndex"))
+	if err != nil {
+		http.Error(w, "Invalid toIndex", http.StatusBadRequest)
+		return
+	}
+
+	newFromEncBalance, ok := new(big.Int).SetString(r.URL.Query().Get("newFromEncBalance"), 10)
+	if !ok {
+		http.Error(w, "Invalid newFromEncBalance", http.StatusBadRequest)
 		return
 	}
-	database.StoreUserAtIndex(fromUser, fromIndex)
-	database.StoreUserAtIndex(toUser, toIndex)
-	database.StoreMerkleTree(&newTree)
 
-	err = db.GenerateProofFromTransferWitness(witness, pInputs, depth)
+	newFromCommitment, err := db.DecodePoint(r.URL.Query().Get("newFromCommitment"))
 	if err != nil {
-		http.Error(w, "Error generating proof: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid newFromCommitment", http.StatusBadRequest)
 		return
 	}
 
-	// Assuming the proof JSON is saved in "exports/proof_data.json"
-	proofJSON, err := os.ReadFile("exports/proof_data.json")
+	amount, ok := new(big.Int).SetString(r.URL.Query().Get("amount"), 10)
+	if !ok {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	amountBlinding, ok := new(big.Int).SetString(r.URL.Query().Get("amountBlinding"), 10)
+	if !ok {
+		http.Error(w, "Invalid amountBlinding", http.StatusBadRequest)
+		return
+	}
+
+	encAmountR, ok := new(big.Int).SetString(r.URL.Query().Get("encAmountR"), 10)
+	if !ok {
+		http.Error(w, "Invalid encAmountR", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := hex.DecodeString(r.URL.Query().Get("proof"))
+	if err != nil || len(proof) == 0 {
+		http.Error(w, "Invalid proof", http.StatusBadRequest)
+		return
+	}
+
+	transfer := db.ProvenTransfer{
+		FromIndex:         fromIndex,
+		ToIndex:           toIndex,
+		NewFromEncBalance: newFromEncBalance,
+		NewFromCommitment: newFromCommitment,
+		Amount:            amount,
+		AmountBlinding:    amountBlinding,
+		EncAmountR:        encAmountR,
+		Proof:             proof,
+	}
+	proofData, err := database.ApplyProvenTransfer(transfer, provers.Transfer.Verify)
 	if err != nil {
-		http.Error(w, "Error reading proof file: "+err.Error(), http.StatusInternalServerError)
+		writeTransitionError(w, "transfer", err, http.StatusBadRequest)
+		return
+	}
+
+	writeProof(w, proofData)
+}
+
+func depositHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	numRegistered := len(database.GetAllUsers())
+
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 || index >= numRegistered {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	amount, ok := new(big.Int).SetString(r.URL.Query().Get("amount"), 10)
+	if !ok {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	opening, ok := parseOpening(r)
+	if !ok {
+		http.Error(w, "Invalid opening", http.StatusBadRequest)
 		return
 	}
 
-	// Send the proof JSON as the response
+	proofData, err := database.ApplyDeposit(depth, index, amount, opening, provers.Deposit.ProofData)
+	if err != nil {
+		writeTransitionError(w, "deposit", err, http.StatusBadRequest)
+		return
+	}
+
+	writeProof(w, proofData)
+}
+
+// parseOpening parses the optional balance and blinding parameters, with which
+// the owner of an account in client custody opens its balance commitment. It
+// returns nil when both are missing.
+func parseOpening(r *http.Request) (*db.Opening, bool) {
+	balanceStr, blindingStr := r.URL.Query().Get("balance"), r.URL.Query().Get("blinding")
+	if balanceStr == "" && blindingStr == "" {
+		return nil, true
+	}
+
+	balance, ok := new(big.Int).SetString(balanceStr, 10)
+	if !ok {
+		return nil, false
+	}
+	blinding, ok := new(big.Int).SetString(blindingStr, 10)
+	if !ok {
+		return nil, false
+	}
+	return &db.Opening{Balance: balance, Blinding: blinding}, true
+}
+
+// optionalString formats v, or returns an empty string if v is nil.
+func optionalString(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+	return v.String()
+}
+
+// parseAddress parses a 0x-prefixed hex Ethereum address.
+func parseAddress(s string) (*big.Int, bool) {
+	addr, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(addr) != 20 {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(addr), true
+}
+
+func withdrawMessageHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	numRegistered := len(database.GetAllUsers())
+
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 || index >= numRegistered {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	amount, ok := new(big.Int).SetString(r.URL.Query().Get("amount"), 10)
+	if !ok {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	recipient, ok := parseAddress(r.URL.Query().Get("recipient"))
+	if !ok {
+		http.Error(w, "Invalid recipient", http.StatusBadRequest)
+		return
+	}
+
+	// The message covers the current root and nonce of the owner
+	msg, root, nonce, err := database.WithdrawMessage(index, amount, recipient)
+	if err != nil {
+		http.Error(w, "Error computing message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Message string `json:"message"`
+		Nonce   string `json:"nonce"`
+		Root    string `json:"root"`
+	}
+
+	resp := response{
+		Message: hex.EncodeToString(msg),
+		Nonce:   nonce.String(),
+		Root:    hex.EncodeToString(root),
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(proofJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func withdrawHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request parameters
+	numRegistered := len(database.GetAllUsers())
+
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 || index >= numRegistered {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	amount, ok := new(big.Int).SetString(r.URL.Query().Get("amount"), 10)
+	if !ok {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	// The owner signs the message returned by /withdraw-message with its
+	// spending key
+	recipient, ok := parseAddress(r.URL.Query().Get("recipient"))
+	if !ok {
+		http.Error(w, "Invalid recipient", http.StatusBadRequest)
+		return
+	}
+
+	nonce, ok := new(big.Int).SetString(r.URL.Query().Get("nonce"), 10)
+	if !ok {
+		http.Error(w, "Invalid nonce", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	// The root returned by /withdraw-message, which the withdrawal must still
+	// apply to
+	root, err := hex.DecodeString(r.URL.Query().Get("root"))
+	if err != nil || len(root) == 0 {
+		http.Error(w, "Invalid root", http.StatusBadRequest)
+		return
+	}
+
+	opening, ok := parseOpening(r)
+	if !ok {
+		http.Error(w, "Invalid opening", http.StatusBadRequest)
+		return
+	}
+
+	intent := db.WithdrawIntent{
+		OldRoot:   root,
+		Index:     index,
+		Amount:    amount,
+		Recipient: recipient,
+		Nonce:     nonce,
+		Signature: signature,
+		Opening:   opening,
+	}
+
+	proofData, err := database.ApplyWithdraw(depth, intent, provers.Withdraw.ProofData)
+	if err != nil {
+		writeTransitionError(w, "withdrawal", err, http.StatusBadRequest)
+		return
+	}
+
+	writeProof(w, proofData)
+}
+
+func registerAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The user keeps its private keys and only sends the Paillier modulus
+	// and the spending public key
+	var request struct {
+		N           string `json:"n"`
+		SpendingKey string `json:"spendingKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	n, ok := new(big.Int).SetString(request.N, 10)
+	if !ok {
+		http.Error(w, "Invalid n", http.StatusBadRequest)
+		return
+	}
+
+	spendingKeyBytes, err := hex.DecodeString(request.SpendingKey)
+	if err != nil {
+		http.Error(w, "Invalid spendingKey", http.StatusBadRequest)
+		return
+	}
+	var spendingKey eddsa.PublicKey
+	if _, err := spendingKey.SetBytes(spendingKeyBytes); err != nil {
+		http.Error(w, "Invalid spendingKey", http.StatusBadRequest)
+		return
+	}
+
+	proofData, _, err := database.RegisterAccount(depth, paillier.NewPublicKey(n), spendingKey, provers.Register.ProofData)
+	if err != nil {
+		writeTransitionError(w, "registration", err, http.StatusBadRequest)
+		return
+	}
+
+	writeProof(w, proofData)
+}
+
+// writeTransitionError reports a state transition that could not be applied,
+// with the given status. Transitions against a stale root are a conflict with
+// a concurrent one, which the client can retry against the new state.
+func writeTransitionError(w http.ResponseWriter, name string, err error, status int) {
+	if errors.Is(err, db.ErrStaleRoot) {
+		http.Error(w, "Stale "+name+": "+err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, "Error applying "+name+": "+err.Error(), status)
+}
+
+// writeProof sends the proof as the response, and also writes it to
+// exports/proof_data.json when the server runs with -export-proofs.
+func writeProof(w http.ResponseWriter, proofData db.Groth16ProofData) {
+	if exportProofs {
+		if err := proofData.WriteFile("exports/proof_data.json"); err != nil {
+			log.Println("Error exporting proof: ", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(proofData)
 }
 
 func main() {
+	flag.BoolVar(&exportProofs, "export-proofs", false, "also write every proof to exports/proof_data.json")
+	dataDir := flag.String("data-dir", "data", "directory persisting the accounts and the balances tree")
+	clientCustody := flag.Bool("client-custody", false, "only keep the public keys and encrypted balances of the accounts")
+	setup := flag.Bool("setup", false, "run a new setup of the circuits, writing their keys and Solidity verifiers to exports/")
+	chainID := flag.Int64("chain-id", 534351, "chain ID of the verifying contract, Scroll Sepolia by default")
+	contractFlag := flag.String("contract", "0x9AB81C32e1D621404b253c7fE0fC9972d1645E69", "address of the verifying contract")
+	flag.Parse()
+
+	contract, ok := parseAddress(*contractFlag)
+	if !ok || *chainID <= 0 {
+		log.Fatal("Invalid chain ID or contract address")
+	}
+
 	router := http.NewServeMux()
 
 	handlerWithCors := corsMiddleware(router)
 
-	database = db.New()
-
-	users := db.GenerateData(numUsers)
-	for _, user := range users {
-		database.StoreUser(user)
+	// The passphrase is read from the environment rather than a flag, which
+	// other users can read from the process list
+	passphrase := os.Getenv("STORE_PASSPHRASE")
+	if passphrase == "" && !*clientCustody {
+		log.Fatal("STORE_PASSPHRASE must be set to store the private keys held by the server")
+	}
+	store, err := db.OpenFileStore(*dataDir, []byte(passphrase))
+	if err != nil {
+		log.Fatal("Error opening the store: ", err)
+	}
+	database, err = db.New(depth, store)
+	if err != nil {
+		log.Fatal("Error recovering the database: ", err)
+	}
+	defer database.Close()
+	database.ClientCustody = *clientCustody
+	database.Domain = db.Domain{ChainID: big.NewInt(*chainID), Contract: contract}
+
+	// Random accounts are only generated on the first start, and never in
+	// client custody since the server would know their keys
+	if !*clientCustody && len(database.GetAllUsers()) == 0 {
+		users := db.GenerateData(numUsers)
+		if err := database.AddUsers(users); err != nil {
+			log.Fatal("Error generating accounts: ", err)
+		}
+		// The contract caps deposits against the supply of the accounts,
+		// see SecretSpend.setBalancesRootForDemo
+		supply, err := db.TotalSupply(users)
+		if err != nil {
+			log.Fatal("Error generating accounts: ", err)
+		}
+		log.Printf("Generated accounts with root %x and total supply %s", database.GetMerkleRoot(), supply)
 	}
 
-	tree := db.GenerateTreeFromUserData(users)
-	database.StoreMerkleTree(&tree)
+	provers, err = db.LoadProvers(depth, *clientCustody, *setup)
+	if err != nil {
+		log.Fatal("Error loading the provers: ", err)
+	}
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		allUsers := database.GetAllUsers()
 		for _, user := range allUsers {
+			if user.Balance == nil {
+				fmt.Fprintf(w, "User Index: %d, Encrypted Balance: %s\n", user.Index, user.EncBalance.String())
+				continue
+			}
 			fmt.Fprintf(w, "User Index: %d, Balance: %s\n", user.Index, user.Balance.String())
 		}
 	})
@@ -191,8 +704,22 @@ func main() {
 
 	router.HandleFunc("/get-merkle-root", getMerkleRootHandler)
 
+	router.HandleFunc("/get-merkle-path", getMerklePathHandler)
+
+	router.HandleFunc("/transfer-message", transferMessageHandler)
+
 	router.HandleFunc("/transfer-funds", transferFundsHandler)
 
+	router.HandleFunc("/submit-transfer", submitTransferHandler)
+
+	router.HandleFunc("/deposit", depositHandler)
+
+	router.HandleFunc("/withdraw-message", withdrawMessageHandler)
+
+	router.HandleFunc("/withdraw", withdrawHandler)
+
+	router.HandleFunc("/accounts", registerAccountHandler)
+
 	log.Println("Starting server on port 8080...")
 	if err := http.ListenAndServe(":8080", handlerWithCors); err != nil {
 		log.Fatal("ListenAndServe error: ", err)
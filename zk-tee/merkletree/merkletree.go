@@ -123,27 +123,23 @@ func (m *MerkleTree) GetMerklePath(content Content) ([][]byte, big.Int, error) {
 		}
 
 		if ok {
-			currentParent := current.Parent
-			var merklePath [][]byte
-			var index []int64
-			for currentParent != nil {
-				if bytes.Equal(currentParent.Left.Hash, current.Hash) {
-					merklePath = append(merklePath, currentParent.Right.Hash)
-					index = append(index, 1) // right leaf
-				} else {
-					merklePath = append(merklePath, currentParent.Left.Hash)
-					index = append(index, 0) // left leaf
-				}
-				current = currentParent
-				currentParent = currentParent.Parent
-			}
-			indexBig := utils.BitsToBigInt(index)
-			return merklePath, *indexBig, nil
+			merklePath, index := merklePathOf(current)
+			return merklePath, index, nil
 		}
 	}
 	return nil, big.Int{}, nil
 }
 
+// GetMerklePathAt returns the Merkle path and indexes of the leaf at the specified index, in the same
+// format as GetMerklePath. Returns an error if the index is out of bounds.
+func (m *MerkleTree) GetMerklePathAt(index int) ([][]byte, big.Int, error) {
+	if index < 0 || index >= len(m.Leafs) {
+		return nil, big.Int{}, errors.New("index out of bounds")
+	}
+	merklePath, indexes := merklePathOf(m.Leafs[index])
+	return merklePath, indexes, nil
+}
+
 // buildWithContent is a helper function that for a given set of Contents, generates a
 // corresponding tree and returns the root node, a list of leaf nodes, and a possible error.
 // Returns an error if cs contains no Contents.
@@ -274,56 +270,111 @@ func (m *MerkleTree) VerifyContent(content Content) (bool, error) {
 		}
 
 		if ok {
-			currentParent := l.Parent
-			for currentParent != nil {
-				h := m.hashStrategy()
-				rightBytes, err := currentParent.Right.calculateNodeHash()
-				if err != nil {
-					return false, err
-				}
-
-				leftBytes, err := currentParent.Left.calculateNodeHash()
-				if err != nil {
-					return false, err
-				}
-
-				if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
-					return false, err
-				}
-				if !bytes.Equal(h.Sum(nil), currentParent.Hash) {
-					return false, nil
-				}
-				currentParent = currentParent.Parent
-			}
-			return true, nil
+			return m.verifyLeaf(l)
 		}
 	}
 	return false, nil
 }
 
-// ModifyLeafAt modifies the leaf at the specified index, rebuilds the tree, and returns the modified tree.
+// VerifyLeafAt indicates whether the hashes on the critical path of the leaf at the specified index are valid.
 // Returns an error if the index is out of bounds.
-func (m *MerkleTree) ModifyLeafAt(index int, newContent Content) error {
+func (m *MerkleTree) VerifyLeafAt(index int) (bool, error) {
 	if index < 0 || index >= len(m.Leafs) {
-		return errors.New("index out of bounds")
+		return false, errors.New("index out of bounds")
+	}
+	return m.verifyLeaf(m.Leafs[index])
+}
+
+// verifyLeaf recalculates the hashes on the critical path of a leaf node and compares them with the stored ones.
+func (m *MerkleTree) verifyLeaf(l *Node) (bool, error) {
+	currentParent := l.Parent
+	for currentParent != nil {
+		h := m.hashStrategy()
+		rightBytes, err := currentParent.Right.calculateNodeHash()
+		if err != nil {
+			return false, err
+		}
+
+		leftBytes, err := currentParent.Left.calculateNodeHash()
+		if err != nil {
+			return false, err
+		}
+
+		if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(h.Sum(nil), currentParent.Hash) {
+			return false, nil
+		}
+		currentParent = currentParent.Parent
 	}
+	return true, nil
+}
 
-	// Replace the content of the leaf
-	m.Leafs[index].C = newContent
+// ModifyLeafAt modifies the leaf at the specified index and updates the hashes on its path to the root.
+// Returns an error if the index is out of bounds.
+func (m *MerkleTree) ModifyLeafAt(index int, newContent Content) error {
+	_, _, err := m.UpdateLeafAt(index, newContent)
+	return err
+}
+
+// UpdateLeafAt replaces the content of the leaf at the specified index and rehashes only the nodes on
+// its path to the root, instead of rebuilding the whole tree. It returns the new Merkle path of the leaf,
+// in the same format as GetMerklePath. Returns an error if the index is out of bounds or points to the
+// duplicate of the last leaf.
+func (m *MerkleTree) UpdateLeafAt(index int, newContent Content) ([][]byte, big.Int, error) {
+	if index < 0 || index >= len(m.Leafs) {
+		return nil, big.Int{}, errors.New("index out of bounds")
+	}
+	if m.Leafs[index].dup {
+		return nil, big.Int{}, errors.New("cannot modify a duplicated leaf")
+	}
 
-	// Recalculate the hash of the modified leaf
 	newHash, err := newContent.CalculateHash()
 	if err != nil {
-		return err
+		return nil, big.Int{}, err
 	}
-	m.Leafs[index].Hash = newHash
 
-	// Rebuild the tree to update all hashes and the Merkle root
-	if err := m.RebuildTree(); err != nil {
-		return err
+	leaf := m.Leafs[index]
+	leaf.C = newContent
+	leaf.Hash = newHash
+
+	// The last leaf of a tree with an odd number of leaves is paired with its duplicate
+	if index+1 < len(m.Leafs) && m.Leafs[index+1].dup {
+		m.Leafs[index+1].C = newContent
+		m.Leafs[index+1].Hash = newHash
 	}
 
-	return nil
+	for current := leaf.Parent; current != nil; current = current.Parent {
+		h, err := current.calculateNodeHash()
+		if err != nil {
+			return nil, big.Int{}, err
+		}
+		current.Hash = h
+	}
+	m.merkleRoot = m.Root.Hash
+
+	merklePath, indexes := merklePathOf(leaf)
+	return merklePath, indexes, nil
+}
+
+// merklePathOf walks up the tree from a leaf node and returns its Merkle path and indexes, in the same
+// format as GetMerklePath.
+func merklePathOf(leaf *Node) ([][]byte, big.Int) {
+	var merklePath [][]byte
+	var index []int64
+	current := leaf
+	for currentParent := current.Parent; currentParent != nil; currentParent = currentParent.Parent {
+		if currentParent.Left == current {
+			merklePath = append(merklePath, currentParent.Right.Hash)
+			index = append(index, 1) // right leaf
+		} else {
+			merklePath = append(merklePath, currentParent.Left.Hash)
+			index = append(index, 0) // left leaf
+		}
+		current = currentParent
+	}
+	return merklePath, *utils.BitsToBigInt(index)
 }
 
 // String returns a string representation of the node.
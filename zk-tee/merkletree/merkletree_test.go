@@ -84,3 +84,107 @@ func TestMerkleTreeWithBalanceLeaf(t *testing.T) {
 		}
 	}
 }
+
+// TestUpdateLeafAt checks that incremental updates match a tree rebuilt from scratch.
+func TestUpdateLeafAt(t *testing.T) {
+	newLeaf := func(i int64) TestBalanceLeaf {
+		return TestBalanceLeaf{
+			PubKey:     TestPaillierPubKey{N: big.NewInt(100 + i), G: big.NewInt(101 + i)},
+			EncBalance: big.NewInt(1000 * i),
+		}
+	}
+
+	for _, nbLeaves := range []int{2, 5, 8} {
+		var list []Content
+		for i := 0; i < nbLeaves; i++ {
+			list = append(list, newLeaf(int64(i)))
+		}
+
+		tree, err := NewTree(list)
+		if err != nil {
+			t.Fatalf("Failed to create Merkle Tree: %s", err)
+		}
+
+		for i := 0; i < nbLeaves; i++ {
+			list[i] = newLeaf(int64(nbLeaves + i))
+			path, index, err := tree.UpdateLeafAt(i, list[i])
+			if err != nil {
+				t.Fatalf("Failed to update leaf %d: %s", i, err)
+			}
+
+			expected, err := NewTree(list)
+			if err != nil {
+				t.Fatalf("Failed to create Merkle Tree: %s", err)
+			}
+			if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+				t.Errorf("Merkle Root mismatch after updating leaf %d of %d", i, nbLeaves)
+			}
+
+			expectedPath, expectedIndex, err := expected.GetMerklePath(list[i])
+			if err != nil {
+				t.Fatalf("Failed to get Merkle path: %s", err)
+			}
+			if len(path) != len(expectedPath) || index.Cmp(&expectedIndex) != 0 {
+				t.Fatalf("Merkle path mismatch after updating leaf %d of %d", i, nbLeaves)
+			}
+			for j := range path {
+				if !bytes.Equal(path[j], expectedPath[j]) {
+					t.Errorf("Merkle path mismatch at level %d after updating leaf %d of %d", j, i, nbLeaves)
+				}
+			}
+
+			validTree, err := tree.VerifyTree()
+			if err != nil || !validTree {
+				t.Errorf("Merkle Tree is invalid after updating leaf %d of %d", i, nbLeaves)
+			}
+		}
+	}
+
+	if _, _, err := (&MerkleTree{}).UpdateLeafAt(0, newLeaf(0)); err == nil {
+		t.Error("Updating a leaf out of bounds should fail")
+	}
+}
+
+// TestGetMerklePathAt checks that leaves with identical content get their own path.
+func TestGetMerklePathAt(t *testing.T) {
+	leaf := TestBalanceLeaf{
+		PubKey:     TestPaillierPubKey{N: big.NewInt(123), G: big.NewInt(456)},
+		EncBalance: big.NewInt(1000),
+	}
+	list := []Content{leaf, leaf, leaf, leaf}
+
+	tree, err := NewTree(list)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %s", err)
+	}
+
+	indexes := make(map[string]bool)
+	for i := range list {
+		path, index, err := tree.GetMerklePathAt(i)
+		if err != nil {
+			t.Fatalf("Failed to get Merkle path: %s", err)
+		}
+		if len(path) != 2 {
+			t.Errorf("Merkle path of leaf %d has length %d, expected 2", i, len(path))
+		}
+		indexes[index.String()] = true
+
+		validLeaf, err := tree.VerifyLeafAt(i)
+		if err != nil {
+			t.Errorf("Failed to verify leaf: %s", err)
+		}
+		if !validLeaf {
+			t.Errorf("Leaf %d is invalid, but it should be valid", i)
+		}
+	}
+	if len(indexes) != len(list) {
+		t.Error("Leaves with identical content should have distinct Merkle path indexes")
+	}
+
+	if _, _, err := tree.GetMerklePathAt(len(list)); err == nil {
+		t.Error("Getting a Merkle path out of bounds should fail")
+	}
+	if _, err := tree.VerifyLeafAt(-1); err == nil {
+		t.Error("Verifying a leaf out of bounds should fail")
+	}
+}
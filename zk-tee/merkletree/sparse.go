@@ -0,0 +1,266 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"math/big"
+
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// SparseMerkleTree is a Merkle tree of fixed depth with 2^depth leaves. Every leaf is empty until it is
+// set, and an empty leaf hashes to zero. Only the nodes of non-empty subtrees are stored, the hashes of
+// empty subtrees are precomputed for every level.
+type SparseMerkleTree struct {
+	depth        int
+	contents     map[int]Content
+	nodes        map[nodeKey][]byte
+	emptyHashes  [][]byte
+	hashStrategy func() hash.Hash
+}
+
+// nodeKey locates a node of a SparseMerkleTree, level 0 being the leaves.
+type nodeKey struct {
+	level int
+	index int
+}
+
+// SparseMerkleProof proves that the leaf at Index hashes to Leaf, or that it is empty when Leaf is the
+// empty leaf hash. Siblings are ordered from the leaves up to the root.
+type SparseMerkleProof struct {
+	Index    int
+	Leaf     []byte
+	Siblings [][]byte
+}
+
+// NewSparseTree creates an empty Sparse Merkle Tree of the given depth.
+func NewSparseTree(depth int) (*SparseMerkleTree, error) {
+	return NewSparseTreeWithHashStrategy(depth, bn254.NewMiMC)
+}
+
+// NewSparseTreeWithHashStrategy creates an empty Sparse Merkle Tree of the given depth using the provided
+// hash strategy. The empty leaf hash is the zero value of the hash size.
+func NewSparseTreeWithHashStrategy(depth int, hashStrategy func() hash.Hash) (*SparseMerkleTree, error) {
+	if depth < 1 || depth > 62 {
+		return nil, errors.New("depth out of range")
+	}
+
+	t := &SparseMerkleTree{
+		depth:        depth,
+		contents:     make(map[int]Content),
+		nodes:        make(map[nodeKey][]byte),
+		emptyHashes:  make([][]byte, depth+1),
+		hashStrategy: hashStrategy,
+	}
+
+	t.emptyHashes[0] = make([]byte, hashStrategy().Size())
+	for level := 1; level <= depth; level++ {
+		h, err := t.hashChildren(t.emptyHashes[level-1], t.emptyHashes[level-1])
+		if err != nil {
+			return nil, err
+		}
+		t.emptyHashes[level] = h
+	}
+
+	return t, nil
+}
+
+// Depth returns the depth of the tree.
+func (t *SparseMerkleTree) Depth() int {
+	return t.depth
+}
+
+// Capacity returns the number of leaves of the tree.
+func (t *SparseMerkleTree) Capacity() int {
+	return 1 << t.depth
+}
+
+// MerkleRoot returns the Merkle Root of the tree.
+func (t *SparseMerkleTree) MerkleRoot() []byte {
+	return t.node(t.depth, 0)
+}
+
+// EmptyLeaf returns the hash of an empty leaf.
+func (t *SparseMerkleTree) EmptyLeaf() []byte {
+	return t.emptyHashes[0]
+}
+
+// Get returns the content of the leaf at the specified index, or nil if the leaf is empty.
+func (t *SparseMerkleTree) Get(index int) Content {
+	return t.contents[index]
+}
+
+// IsEmpty indicates whether the leaf at the specified index is empty.
+func (t *SparseMerkleTree) IsEmpty(index int) bool {
+	_, ok := t.contents[index]
+	return !ok
+}
+
+// UpdateLeafAt sets the content of the leaf at the specified index, or empties it if newContent is nil,
+// and rehashes the nodes on its path to the root. It returns the proof of the new leaf.
+// Returns an error if the index is out of bounds.
+func (t *SparseMerkleTree) UpdateLeafAt(index int, newContent Content) (SparseMerkleProof, error) {
+	if err := t.checkIndex(index); err != nil {
+		return SparseMerkleProof{}, err
+	}
+
+	leaf := t.emptyHashes[0]
+	if newContent != nil {
+		h, err := newContent.CalculateHash()
+		if err != nil {
+			return SparseMerkleProof{}, err
+		}
+		leaf = h
+		t.contents[index] = newContent
+	} else {
+		delete(t.contents, index)
+	}
+	t.setNode(0, index, leaf)
+
+	current := leaf
+	for level := 0; level < t.depth; level++ {
+		i := index >> level
+		var err error
+		if i%2 == 0 {
+			current, err = t.hashChildren(current, t.node(level, i+1))
+		} else {
+			current, err = t.hashChildren(t.node(level, i-1), current)
+		}
+		if err != nil {
+			return SparseMerkleProof{}, err
+		}
+		t.setNode(level+1, i>>1, current)
+	}
+
+	return t.proof(index), nil
+}
+
+// ProveMembership returns the proof of the leaf at the specified index.
+// Returns an error if the index is out of bounds or the leaf is empty.
+func (t *SparseMerkleTree) ProveMembership(index int) (SparseMerkleProof, error) {
+	if err := t.checkIndex(index); err != nil {
+		return SparseMerkleProof{}, err
+	}
+	if t.IsEmpty(index) {
+		return SparseMerkleProof{}, errors.New("leaf is empty")
+	}
+	return t.proof(index), nil
+}
+
+// ProveNonMembership returns the proof that the leaf at the specified index is empty.
+// Returns an error if the index is out of bounds or the leaf is not empty.
+func (t *SparseMerkleTree) ProveNonMembership(index int) (SparseMerkleProof, error) {
+	if err := t.checkIndex(index); err != nil {
+		return SparseMerkleProof{}, err
+	}
+	if !t.IsEmpty(index) {
+		return SparseMerkleProof{}, errors.New("leaf is not empty")
+	}
+	return t.proof(index), nil
+}
+
+// VerifyProof indicates whether the proof leads to the current Merkle Root of the tree.
+func (t *SparseMerkleTree) VerifyProof(proof SparseMerkleProof) (bool, error) {
+	if err := t.checkIndex(proof.Index); err != nil {
+		return false, err
+	}
+	if len(proof.Siblings) != t.depth {
+		return false, nil
+	}
+
+	current := proof.Leaf
+	for level, sibling := range proof.Siblings {
+		var err error
+		if (proof.Index>>level)%2 == 0 {
+			current, err = t.hashChildren(current, sibling)
+		} else {
+			current, err = t.hashChildren(sibling, current)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return bytes.Equal(current, t.MerkleRoot()), nil
+}
+
+// GetMerklePathAt returns the Merkle path and indexes of the leaf at the specified index, in the same
+// format as MerkleTree.GetMerklePath. Returns an error if the index is out of bounds.
+func (t *SparseMerkleTree) GetMerklePathAt(index int) ([][]byte, big.Int, error) {
+	if err := t.checkIndex(index); err != nil {
+		return nil, big.Int{}, err
+	}
+
+	var indexes []int64
+	for level := 0; level < t.depth; level++ {
+		indexes = append(indexes, 1-int64((index>>level)%2)) // 1 for a left node
+	}
+	return t.proof(index).Siblings, *utils.BitsToBigInt(indexes), nil
+}
+
+// VerifyLeafAt indicates whether the hashes on the critical path of the leaf at the specified index are valid.
+// Returns an error if the index is out of bounds.
+func (t *SparseMerkleTree) VerifyLeafAt(index int) (bool, error) {
+	if err := t.checkIndex(index); err != nil {
+		return false, err
+	}
+	return t.VerifyProof(t.proof(index))
+}
+
+// IsNonMembership indicates whether the proof is about an empty leaf.
+func (p SparseMerkleProof) IsNonMembership() bool {
+	for _, b := range p.Leaf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// proof collects the siblings on the path of the leaf at the specified index.
+func (t *SparseMerkleTree) proof(index int) SparseMerkleProof {
+	siblings := make([][]byte, t.depth)
+	for level := range siblings {
+		siblings[level] = t.node(level, (index>>level)^1)
+	}
+
+	return SparseMerkleProof{
+		Index:    index,
+		Leaf:     t.node(0, index),
+		Siblings: siblings,
+	}
+}
+
+// node returns the hash of the node at the given level and index.
+func (t *SparseMerkleTree) node(level int, index int) []byte {
+	if h, ok := t.nodes[nodeKey{level, index}]; ok {
+		return h
+	}
+	return t.emptyHashes[level]
+}
+
+// setNode stores the hash of the node at the given level and index, dropping the nodes of empty subtrees.
+func (t *SparseMerkleTree) setNode(level int, index int, h []byte) {
+	if bytes.Equal(h, t.emptyHashes[level]) {
+		delete(t.nodes, nodeKey{level, index})
+		return
+	}
+	t.nodes[nodeKey{level, index}] = h
+}
+
+func (t *SparseMerkleTree) hashChildren(left []byte, right []byte) ([]byte, error) {
+	h := t.hashStrategy()
+	if _, err := h.Write(append(append([]byte{}, left...), right...)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func (t *SparseMerkleTree) checkIndex(index int) error {
+	if index < 0 || index >= t.Capacity() {
+		return errors.New("index out of bounds")
+	}
+	return nil
+}
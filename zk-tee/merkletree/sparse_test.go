@@ -0,0 +1,150 @@
+package merkletree
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// TestSparseMerkleTree tests membership and non-membership proofs of the Sparse Merkle Tree.
+func TestSparseMerkleTree(t *testing.T) {
+	newLeaf := func(i int64) TestBalanceLeaf {
+		return TestBalanceLeaf{
+			PubKey:     TestPaillierPubKey{N: big.NewInt(100 + i), G: big.NewInt(101 + i)},
+			EncBalance: big.NewInt(1000 * i),
+		}
+	}
+
+	depth := 4
+	tree, err := NewSparseTree(depth)
+	if err != nil {
+		t.Fatalf("Failed to create Sparse Merkle Tree: %s", err)
+	}
+	emptyRoot := tree.MerkleRoot()
+
+	// Every leaf of an empty tree has a non-membership proof
+	for i := 0; i < tree.Capacity(); i++ {
+		proof, err := tree.ProveNonMembership(i)
+		if err != nil {
+			t.Fatalf("Failed to prove non-membership: %s", err)
+		}
+		valid, err := tree.VerifyProof(proof)
+		if err != nil || !valid {
+			t.Errorf("Non-membership proof of leaf %d is invalid, but it should be valid", i)
+		}
+	}
+
+	indexes := []int{0, 5, 6, 15}
+	for _, i := range indexes {
+		proof, err := tree.UpdateLeafAt(i, newLeaf(int64(i)))
+		if err != nil {
+			t.Fatalf("Failed to update leaf: %s", err)
+		}
+		if proof.IsNonMembership() {
+			t.Errorf("Proof of leaf %d should be a membership proof", i)
+		}
+		valid, err := tree.VerifyProof(proof)
+		if err != nil || !valid {
+			t.Errorf("Proof returned by the update of leaf %d is invalid, but it should be valid", i)
+		}
+	}
+
+	for i := 0; i < tree.Capacity(); i++ {
+		occupied := false
+		for _, j := range indexes {
+			occupied = occupied || i == j
+		}
+
+		membership, errMembership := tree.ProveMembership(i)
+		nonMembership, errNonMembership := tree.ProveNonMembership(i)
+		if occupied {
+			if errMembership != nil || errNonMembership == nil {
+				t.Fatalf("Leaf %d should only have a membership proof", i)
+			}
+			valid, err := tree.VerifyProof(membership)
+			if err != nil || !valid {
+				t.Errorf("Membership proof of leaf %d is invalid, but it should be valid", i)
+			}
+		} else {
+			if errMembership == nil || errNonMembership != nil {
+				t.Fatalf("Leaf %d should only have a non-membership proof", i)
+			}
+			valid, err := tree.VerifyProof(nonMembership)
+			if err != nil || !valid {
+				t.Errorf("Non-membership proof of leaf %d is invalid, but it should be valid", i)
+			}
+		}
+	}
+
+	// A proof does not hold for another leaf
+	proof, _ := tree.ProveMembership(5)
+	proof.Index = 6
+	if valid, _ := tree.VerifyProof(proof); valid {
+		t.Error("Proof of leaf 5 should not be valid for leaf 6")
+	}
+
+	// Emptying all the leaves restores the empty tree
+	for _, i := range indexes {
+		if _, err := tree.UpdateLeafAt(i, nil); err != nil {
+			t.Fatalf("Failed to empty leaf: %s", err)
+		}
+	}
+	if !bytes.Equal(tree.MerkleRoot(), emptyRoot) {
+		t.Error("Merkle Root of the emptied tree should be the empty root")
+	}
+
+	if _, err := tree.UpdateLeafAt(tree.Capacity(), newLeaf(0)); err == nil {
+		t.Error("Updating a leaf out of bounds should fail")
+	}
+}
+
+// TestSparseMerkleTreeFull checks that a full Sparse Merkle Tree has the same root as the dense tree.
+func TestSparseMerkleTreeFull(t *testing.T) {
+	newLeaf := func(i int64) TestBalanceLeaf {
+		return TestBalanceLeaf{
+			PubKey:     TestPaillierPubKey{N: big.NewInt(100 + i), G: big.NewInt(101 + i)},
+			EncBalance: big.NewInt(1000 * i),
+		}
+	}
+
+	depth := 3
+	tree, err := NewSparseTree(depth)
+	if err != nil {
+		t.Fatalf("Failed to create Sparse Merkle Tree: %s", err)
+	}
+
+	var list []Content
+	for i := 0; i < tree.Capacity(); i++ {
+		list = append(list, newLeaf(int64(i)))
+		if _, err := tree.UpdateLeafAt(i, list[i]); err != nil {
+			t.Fatalf("Failed to update leaf: %s", err)
+		}
+	}
+
+	dense, err := NewTree(list)
+	if err != nil {
+		t.Fatalf("Failed to create Merkle Tree: %s", err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), dense.MerkleRoot()) {
+		t.Error("Merkle Root of the full Sparse Merkle Tree should match the dense tree")
+	}
+
+	for i := range list {
+		path, index, err := tree.GetMerklePathAt(i)
+		if err != nil {
+			t.Fatalf("Failed to get Merkle path: %s", err)
+		}
+		densePath, denseIndex, err := dense.GetMerklePathAt(i)
+		if err != nil {
+			t.Fatalf("Failed to get Merkle path: %s", err)
+		}
+		if index.Cmp(&denseIndex) != 0 || len(path) != len(densePath) {
+			t.Fatalf("Merkle path of leaf %d should match the dense tree", i)
+		}
+		for j := range path {
+			if !bytes.Equal(path[j], densePath[j]) {
+				t.Errorf("Merkle path of leaf %d should match the dense tree at level %d", i, j)
+			}
+		}
+	}
+}
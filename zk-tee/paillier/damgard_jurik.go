@@ -0,0 +1,137 @@
+package paillier
+
+import (
+	"errors"
+	"io"
+	"math/big"
+)
+
+// The Damgard-Jurik generalization of Paillier encrypts plain texts modulo
+// n^s as c = g^m * r^(n^s) mod n^(s+1), with g = n + 1, so that balances can
+// grow past n under many additions. For s = 1 it is plain Paillier. Keys of
+// degree s > 1 are used through the same Encrypt, Decrypt and homomorphic
+// functions as Paillier keys.
+
+// NewDamgardJurikPublicKey returns the public key of modulus n and degree s.
+func NewDamgardJurikPublicKey(n *big.Int, s int) *PublicKey {
+	pubKey := NewPublicKey(n)
+	pubKey.S = s
+	return pubKey
+}
+
+// NewDamgardJurikPrivateKey returns the private key of primes p and q and
+// degree s.
+func NewDamgardJurikPrivateKey(p *big.Int, q *big.Int, s int) *PrivateKey {
+	privKey := NewPrivateKey(p, q)
+	privKey.S = s
+	return privKey
+}
+
+// GenerateDamgardJurikKey generates a key of the given bit size and degree s
+// using the random source random (for example, crypto/rand.Reader).
+func GenerateDamgardJurikKey(random io.Reader, bits int, s int) (*PrivateKey, error) {
+	if s < 1 {
+		return nil, errors.New("paillier: degree must be at least one")
+	}
+	privKey, err := GenerateKey(random, bits)
+	if err != nil {
+		return nil, err
+	}
+	privKey.S = s
+	return privKey, nil
+}
+
+// degree returns the Damgard-Jurik degree s of the key, one for Paillier keys.
+func (pubKey *PublicKey) degree() int {
+	if pubKey.S < 1 {
+		return 1
+	}
+	return pubKey.S
+}
+
+// plainModulus returns n^s, the size of the plain text space.
+func (pubKey *PublicKey) plainModulus() *big.Int {
+	if pubKey.degree() == 1 {
+		return pubKey.N
+	}
+	return new(big.Int).Exp(pubKey.N, big.NewInt(int64(pubKey.degree())), nil)
+}
+
+// cipherModulus returns n^(s+1), the modulus of the cipher texts.
+func (pubKey *PublicKey) cipherModulus() *big.Int {
+	if pubKey.degree() == 1 {
+		return pubKey.NSquared
+	}
+	return new(big.Int).Exp(pubKey.N, big.NewInt(int64(pubKey.degree()+1)), nil)
+}
+
+// encryptDamgardJurik returns g^m * r^(n^s) mod n^(s+1).
+func encryptDamgardJurik(pubKey *PublicKey, r *big.Int, m *big.Int) (*big.Int, error) {
+	ns := pubKey.plainModulus()
+	if ns.Cmp(m) < 1 { // n^s < m
+		return nil, ErrMessageTooLong
+	}
+
+	modulus := pubKey.cipherModulus()
+	c := new(big.Int).Exp(pubKey.G, m, modulus)
+	c.Mul(c, new(big.Int).Exp(r, ns, modulus)).Mod(c, modulus)
+	return c, nil
+}
+
+// decryptDamgardJurik raises c to lambda = lcm(p-1, q-1), which cancels the
+// randomness and leaves (1+n)^(m*lambda), extracts m*lambda mod n^s from it
+// and divides by lambda.
+func decryptDamgardJurik(privKey *PrivateKey, c *big.Int) ([]byte, error) {
+	modulus := privKey.cipherModulus()
+	if modulus.Cmp(c) < 1 { // c < n^(s+1)
+		return nil, ErrMessageTooLong
+	}
+
+	gcd := new(big.Int).GCD(nil, nil, privKey.pminusone, privKey.qminusone)
+	lambda := new(big.Int).Mul(privKey.pminusone, privKey.qminusone)
+	lambda.Quo(lambda, gcd)
+
+	ns := privKey.plainModulus()
+	lambdaInv := new(big.Int).ModInverse(lambda, ns)
+	if lambdaInv == nil {
+		return nil, errors.New("paillier: lambda is not invertible modulo n^s")
+	}
+
+	a := new(big.Int).Exp(c, lambda, modulus)
+	m := logOnePlusN(a, privKey.N, privKey.degree())
+	m.Mul(m, lambdaInv).Mod(m, ns)
+	return m.Bytes(), nil
+}
+
+// logOnePlusN returns i mod n^s given a = (1+n)^i mod n^(s+1). It recovers i
+// modulo n, n^2, ..., n^s in turn from the binomial expansion
+// (1+n)^i = 1 + i*n + C(i, 2)*n^2 + ..., as in the Damgard-Jurik paper.
+func logOnePlusN(a *big.Int, n *big.Int, s int) *big.Int {
+	i := new(big.Int)
+	nj := new(big.Int).Set(n) // n^j
+	for j := 1; j <= s; j++ {
+		nj1 := new(big.Int).Mul(nj, n)
+
+		// t1 = L(a mod n^(j+1)) = i + C(i, 2)*n + ... mod n^j
+		t1 := l(new(big.Int).Mod(a, nj1), n)
+		t2 := new(big.Int).Set(i)
+		kFactorial := big.NewInt(1)
+		nk := big.NewInt(1) // n^(k-1)
+		for k := 2; k <= j; k++ {
+			// i is known modulo n^(j-1), which fixes the higher terms
+			i.Sub(i, one)
+			t2.Mul(t2, i).Mod(t2, nj)
+			kFactorial.Mul(kFactorial, big.NewInt(int64(k)))
+			nk.Mul(nk, n)
+
+			// t1 -= t2 * n^(k-1) / k! mod n^j
+			term := new(big.Int).Mul(t2, nk)
+			term.Mul(term, new(big.Int).ModInverse(kFactorial, nj))
+			t1.Sub(t1, term).Mod(t1, nj)
+		}
+		i = t1.Mod(t1, nj)
+		nj = nj1
+	}
+
+	return i
+}
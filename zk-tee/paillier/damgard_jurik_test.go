@@ -0,0 +1,184 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestDamgardJurik(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	p, q := privKey.Primes()
+
+	for s := 1; s <= 3; s++ {
+		djKey := NewDamgardJurikPrivateKey(p, q, s)
+		pubKey := &djKey.PublicKey
+		ns := new(big.Int).Exp(pubKey.N, big.NewInt(int64(s)), nil)
+
+		// The largest plain text, above n for s > 1
+		largest := new(big.Int).Sub(ns, one)
+		for _, m := range []*big.Int{big.NewInt(0), big.NewInt(42), new(big.Int).Add(pubKey.N, big.NewInt(7)), largest} {
+			if m.Cmp(ns) >= 0 {
+				continue
+			}
+			c, _, err := Encrypt(pubKey, m.Bytes())
+			if err != nil {
+				t.Fatalf("s=%d: failed to encrypt %s: %v", s, m, err)
+			}
+			if new(big.Int).SetBytes(c).Cmp(pubKey.cipherModulus()) >= 0 {
+				t.Errorf("s=%d: cipher text is not reduced modulo n^(s+1)", s)
+			}
+			plainText, err := Decrypt(djKey, c)
+			if err != nil {
+				t.Fatalf("s=%d: failed to decrypt: %v", s, err)
+			}
+			if new(big.Int).SetBytes(plainText).Cmp(m) != 0 {
+				t.Errorf("s=%d: decrypted %s, want %s", s, new(big.Int).SetBytes(plainText), m)
+			}
+		}
+		if _, _, err := Encrypt(pubKey, ns.Bytes()); err != ErrMessageTooLong {
+			t.Errorf("s=%d: encrypting n^s returned %v, want ErrMessageTooLong", s, err)
+		}
+
+		// A sum above n does not wrap for s > 1
+		a := new(big.Int).Sub(pubKey.N, big.NewInt(5))
+		b := big.NewInt(12)
+		ca, _, err := Encrypt(pubKey, a.Bytes())
+		if err != nil {
+			t.Fatalf("s=%d: failed to encrypt: %v", s, err)
+		}
+		cb, r, err := Encrypt(pubKey, b.Bytes())
+		if err != nil {
+			t.Fatalf("s=%d: failed to encrypt: %v", s, err)
+		}
+		sum := new(big.Int).Add(a, b)
+		sum.Mod(sum, ns)
+		if got := decryptInt(t, djKey, AddCipher(pubKey, ca, cb)); got.Cmp(sum) != 0 {
+			t.Errorf("s=%d: AddCipher decrypted %s, want %s", s, got, sum)
+		}
+		if got := decryptInt(t, djKey, Add(pubKey, ca, b.Bytes())); got.Cmp(sum) != 0 {
+			t.Errorf("s=%d: Add decrypted %s, want %s", s, got, sum)
+		}
+		product := new(big.Int).Mul(a, big.NewInt(3))
+		product.Mod(product, ns)
+		if got := decryptInt(t, djKey, Mul(pubKey, ca, big.NewInt(3).Bytes())); got.Cmp(product) != 0 {
+			t.Errorf("s=%d: Mul decrypted %s, want %s", s, got, product)
+		}
+		diff, err := SubCipher(pubKey, cb, ca)
+		if err != nil {
+			t.Fatalf("s=%d: failed to subtract: %v", s, err)
+		}
+		want := new(big.Int).Sub(b, a)
+		want.Mod(want, ns)
+		if got := decryptInt(t, djKey, diff); got.Cmp(want) != 0 {
+			t.Errorf("s=%d: SubCipher decrypted %s, want %s", s, got, want)
+		}
+
+		nonce, err := DecryptNonce(djKey, cb)
+		if err != nil {
+			t.Fatalf("s=%d: failed to recover the nonce: %v", s, err)
+		}
+		if nonce.Cmp(r) != 0 {
+			t.Errorf("s=%d: recovered nonce %s, want %s", s, nonce, r)
+		}
+
+		// Fresh randomness keeps the plain text
+		re, _, err := Rerandomize(pubKey, cb)
+		if err != nil {
+			t.Fatalf("s=%d: failed to rerandomize: %v", s, err)
+		}
+		if got := decryptInt(t, djKey, re); got.Cmp(b) != 0 {
+			t.Errorf("s=%d: rerandomized cipher text decrypted %s, want %s", s, got, b)
+		}
+	}
+}
+
+// TestDamgardJurikMatchesPaillier checks that the Damgard-Jurik decryption of
+// degree one agrees with the CRT decryption of Paillier keys.
+func TestDamgardJurikMatchesPaillier(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	m := big.NewInt(123456789)
+	c, _, err := Encrypt(&privKey.PublicKey, m.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	plainText, err := decryptDamgardJurik(privKey, new(big.Int).SetBytes(c))
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if new(big.Int).SetBytes(plainText).Cmp(m) != 0 {
+		t.Errorf("Decrypted %s, want %s", new(big.Int).SetBytes(plainText), m)
+	}
+
+	if _, err := GenerateDamgardJurikKey(rand.Reader, 512, 0); err == nil {
+		t.Errorf("Degree zero should fail")
+	}
+}
+
+func TestMarshalDamgardJurikKey(t *testing.T) {
+	privKey, err := GenerateDamgardJurikKey(rand.Reader, 512, 3)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	data, err := privKey.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	loaded := new(PrivateKey)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal private key: %v", err)
+	}
+	if loaded.S != 3 {
+		t.Errorf("Unmarshalled private key has degree %d, want 3", loaded.S)
+	}
+
+	jsonData, err := privKey.PublicKey.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	pubKey := new(PublicKey)
+	if err := pubKey.UnmarshalJSON(jsonData); err != nil {
+		t.Fatalf("Failed to unmarshal public key: %v", err)
+	}
+	if pubKey.S != 3 {
+		t.Errorf("Unmarshalled public key has degree %d, want 3", pubKey.S)
+	}
+
+	// A plain text above n^2 survives the round trip through both keys
+	m := new(big.Int).Exp(pubKey.N, big.NewInt(2), nil)
+	m.Add(m, big.NewInt(1))
+	c, _, err := Encrypt(pubKey, m.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if got := decryptInt(t, loaded, c); got.Cmp(m) != 0 {
+		t.Errorf("Decrypted %s, want %s", got, m)
+	}
+
+	// Paillier keys keep their encoding
+	pubData, _ := NewPublicKey(pubKey.N).MarshalBinary()
+	if pubData[1] != kindPublic {
+		t.Errorf("Paillier public key encoded with kind %d", pubData[1])
+	}
+
+	// Decryption proofs only cover Paillier keys
+	if _, _, err := Prove(privKey, c); err == nil {
+		t.Errorf("Proving a Damgard-Jurik decryption should fail")
+	}
+}
+
+func decryptInt(t *testing.T, privKey *PrivateKey, cipherText []byte) *big.Int {
+	t.Helper()
+	plainText, err := Decrypt(privKey, cipherText)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	return new(big.Int).SetBytes(plainText)
+}
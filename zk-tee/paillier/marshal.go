@@ -0,0 +1,407 @@
+package paillier
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keys and proofs are encoded with a version byte followed by a kind byte and
+// the length-prefixed big-endian integers of the value: n for public keys, p
+// and q for private keys. Damgard-Jurik keys of degree s > 1 have kinds of
+// their own and store s after these. Only n, p, q and s are stored, the other
+// values are recomputed on load.
+const (
+	encodingVersion = 1
+
+	kindPublic              = 0
+	kindPrivate             = 1
+	kindDecryptionProof     = 2
+	kindDamgardJurikPublic  = 3
+	kindDamgardJurikPrivate = 4
+)
+
+// maxDegree bounds the degree of decoded Damgard-Jurik keys, whose cipher
+// texts grow with it.
+const maxDegree = 64
+
+// PEM block types of the keys.
+const (
+	PublicKeyPEMType           = "PAILLIER PUBLIC KEY"
+	PrivateKeyPEMType          = "PAILLIER PRIVATE KEY"
+	EncryptedPrivateKeyPEMType = "ENCRYPTED PAILLIER PRIVATE KEY"
+)
+
+// ErrInvalidKey is returned when decoding malformed or inconsistent key data.
+var ErrInvalidKey = errors.New("paillier: invalid key encoding")
+
+// ErrWrongPassphrase is returned when an encrypted private key does not
+// decrypt under the passphrase, or has been tampered with.
+var ErrWrongPassphrase = errors.New("paillier: wrong passphrase or corrupted key")
+
+// Parameters of the scrypt derivation of the key encrypting private keys at
+// rest, fixed by the version of the encoding.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	saltSize      = 16
+	encKeyVersion = 1
+)
+
+// keyJSON is the JSON encoding of the keys. Integers are decimal strings.
+type keyJSON struct {
+	Version int    `json:"version"`
+	N       string `json:"n"`
+	P       string `json:"p,omitempty"`
+	Q       string `json:"q,omitempty"`
+	S       int    `json:"s,omitempty"`
+}
+
+// MarshalBinary encodes the public key.
+func (pubKey *PublicKey) MarshalBinary() ([]byte, error) {
+	if pubKey.N == nil {
+		return nil, ErrInvalidKey
+	}
+	if pubKey.degree() > 1 {
+		return appendInts([]byte{encodingVersion, kindDamgardJurikPublic}, pubKey.N, big.NewInt(int64(pubKey.S))), nil
+	}
+	return appendInts([]byte{encodingVersion, kindPublic}, pubKey.N), nil
+}
+
+// UnmarshalBinary decodes a public key encoded by MarshalBinary.
+func (pubKey *PublicKey) UnmarshalBinary(data []byte) error {
+	if len(data) >= 2 && data[1] == kindDamgardJurikPublic {
+		ints, err := parseInts(data, kindDamgardJurikPublic, 2)
+		if err != nil {
+			return err
+		}
+		return pubKey.set(ints[0], ints[1])
+	}
+	ints, err := parseInts(data, kindPublic, 1)
+	if err != nil {
+		return err
+	}
+	return pubKey.set(ints[0], one)
+}
+
+// MarshalJSON encodes the public key as {"version": 1, "n": "..."}, with the
+// degree in "s" for Damgard-Jurik keys.
+func (pubKey *PublicKey) MarshalJSON() ([]byte, error) {
+	if pubKey.N == nil {
+		return nil, ErrInvalidKey
+	}
+	return json.Marshal(keyJSON{Version: encodingVersion, N: pubKey.N.String(), S: pubKey.jsonDegree()})
+}
+
+// UnmarshalJSON decodes a public key encoded by MarshalJSON.
+func (pubKey *PublicKey) UnmarshalJSON(data []byte) error {
+	var v keyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Version != encodingVersion {
+		return fmt.Errorf("paillier: unsupported key version %d", v.Version)
+	}
+	n, ok := new(big.Int).SetString(v.N, 10)
+	if !ok {
+		return ErrInvalidKey
+	}
+	return pubKey.set(n, jsonDegree(v.S))
+}
+
+// MarshalPEM encodes the public key in a PEM block.
+func (pubKey *PublicKey) MarshalPEM() ([]byte, error) {
+	data, err := pubKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PublicKeyPEMType, Bytes: data}), nil
+}
+
+// set sets the public key of modulus n and degree s, recomputing g and n^2.
+func (pubKey *PublicKey) set(n *big.Int, s *big.Int) error {
+	if n.Cmp(one) <= 0 || !validDegree(s) {
+		return ErrInvalidKey
+	}
+	*pubKey = *NewDamgardJurikPublicKey(n, storedDegree(s))
+	return nil
+}
+
+// MarshalBinary encodes the private key. The encoding holds the primes of the
+// key in the clear, see EncryptPrivateKey to store it.
+func (privKey *PrivateKey) MarshalBinary() ([]byte, error) {
+	if privKey.p == nil || privKey.q == nil {
+		return nil, errors.New("paillier: key only holds a public key")
+	}
+	if privKey.degree() > 1 {
+		return appendInts([]byte{encodingVersion, kindDamgardJurikPrivate}, privKey.p, privKey.q, big.NewInt(int64(privKey.S))), nil
+	}
+	return appendInts([]byte{encodingVersion, kindPrivate}, privKey.p, privKey.q), nil
+}
+
+// UnmarshalBinary decodes a private key encoded by MarshalBinary.
+func (privKey *PrivateKey) UnmarshalBinary(data []byte) error {
+	if len(data) >= 2 && data[1] == kindDamgardJurikPrivate {
+		ints, err := parseInts(data, kindDamgardJurikPrivate, 3)
+		if err != nil {
+			return err
+		}
+		return privKey.set(ints[0], ints[1], ints[2])
+	}
+	ints, err := parseInts(data, kindPrivate, 2)
+	if err != nil {
+		return err
+	}
+	return privKey.set(ints[0], ints[1], one)
+}
+
+// MarshalJSON encodes the private key as {"version": 1, "n": "...", "p":
+// "...", "q": "..."}, with the degree in "s" for Damgard-Jurik keys. n is
+// redundant and only checked on load.
+func (privKey *PrivateKey) MarshalJSON() ([]byte, error) {
+	if privKey.p == nil || privKey.q == nil {
+		return nil, errors.New("paillier: key only holds a public key")
+	}
+	return json.Marshal(keyJSON{
+		Version: encodingVersion,
+		N:       privKey.N.String(),
+		P:       privKey.p.String(),
+		Q:       privKey.q.String(),
+		S:       privKey.jsonDegree(),
+	})
+}
+
+// UnmarshalJSON decodes a private key encoded by MarshalJSON.
+func (privKey *PrivateKey) UnmarshalJSON(data []byte) error {
+	var v keyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Version != encodingVersion {
+		return fmt.Errorf("paillier: unsupported key version %d", v.Version)
+	}
+	n, okN := new(big.Int).SetString(v.N, 10)
+	p, okP := new(big.Int).SetString(v.P, 10)
+	q, okQ := new(big.Int).SetString(v.Q, 10)
+	if !okN || !okP || !okQ || new(big.Int).Mul(p, q).Cmp(n) != 0 {
+		return ErrInvalidKey
+	}
+	return privKey.set(p, q, jsonDegree(v.S))
+}
+
+// MarshalPEM encodes the private key in a PEM block, encrypted under the
+// passphrase unless it is empty.
+func (privKey *PrivateKey) MarshalPEM(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		data, err := privKey.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: PrivateKeyPEMType, Bytes: data}), nil
+	}
+
+	data, err := EncryptPrivateKey(privKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: EncryptedPrivateKeyPEMType, Bytes: data}), nil
+}
+
+// set sets the private key of primes p and q and degree s, recomputing the
+// values used for CRT decryption.
+func (privKey *PrivateKey) set(p *big.Int, q *big.Int, s *big.Int) error {
+	if p.Cmp(q) == 0 || !p.ProbablyPrime(20) || !q.ProbablyPrime(20) || !validDegree(s) {
+		return ErrInvalidKey
+	}
+	*privKey = *NewDamgardJurikPrivateKey(p, q, storedDegree(s))
+	return nil
+}
+
+// jsonDegree returns the degree stored in the JSON encoding of the key, zero
+// for Paillier keys so that it is omitted.
+func (pubKey *PublicKey) jsonDegree() int {
+	if pubKey.degree() == 1 {
+		return 0
+	}
+	return pubKey.S
+}
+
+// jsonDegree returns the degree of a key decoded from JSON, where a missing
+// degree stands for a Paillier key.
+func jsonDegree(s int) *big.Int {
+	if s == 0 {
+		return one
+	}
+	return big.NewInt(int64(s))
+}
+
+// validDegree reports whether s is a supported degree.
+func validDegree(s *big.Int) bool {
+	return s.Sign() > 0 && s.Cmp(big.NewInt(maxDegree)) <= 0
+}
+
+// storedDegree returns the value of the S field for a key of degree s, zero
+// for Paillier keys as returned by NewPublicKey and NewPrivateKey.
+func storedDegree(s *big.Int) int {
+	if s.Cmp(one) == 0 {
+		return 0
+	}
+	return int(s.Int64())
+}
+
+// ParsePublicKeyPEM decodes the first PEM block of data, which must hold a
+// public key.
+func ParsePublicKeyPEM(data []byte) (*PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != PublicKeyPEMType {
+		return nil, errors.New("paillier: no public key PEM block")
+	}
+	pubKey := new(PublicKey)
+	if err := pubKey.UnmarshalBinary(block.Bytes); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// ParsePrivateKeyPEM decodes the first PEM block of data, which must hold a
+// private key. The passphrase is only used for encrypted keys.
+func ParsePrivateKeyPEM(data []byte, passphrase []byte) (*PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("paillier: no private key PEM block")
+	}
+
+	switch block.Type {
+	case PrivateKeyPEMType:
+		privKey := new(PrivateKey)
+		if err := privKey.UnmarshalBinary(block.Bytes); err != nil {
+			return nil, err
+		}
+		return privKey, nil
+	case EncryptedPrivateKeyPEMType:
+		return DecryptPrivateKey(block.Bytes, passphrase)
+	default:
+		return nil, errors.New("paillier: no private key PEM block")
+	}
+}
+
+// EncryptPrivateKey encrypts the binary encoding of the private key under the
+// passphrase, for storage at rest. The key is encrypted with AES-256-GCM under
+// a key derived from the passphrase with scrypt and a random salt. The output
+// is the version byte, the salt, the GCM nonce and the sealed key.
+func EncryptPrivateKey(privKey *PrivateKey, passphrase []byte) ([]byte, error) {
+	plainText, err := privKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := PassphraseAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{encKeyVersion}, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated along with the key
+	return aead.Seal(out, nonce, plainText, out[:1+saltSize]), nil
+}
+
+// DecryptPrivateKey decrypts a private key encrypted by EncryptPrivateKey.
+func DecryptPrivateKey(data []byte, passphrase []byte) (*PrivateKey, error) {
+	if len(data) < 1+saltSize || data[0] != encKeyVersion {
+		return nil, ErrInvalidKey
+	}
+	salt := data[1 : 1+saltSize]
+	aead, err := PassphraseAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[1+saltSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrInvalidKey
+	}
+
+	plainText, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], data[:1+saltSize])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	privKey := new(PrivateKey)
+	if err := privKey.UnmarshalBinary(plainText); err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
+
+// PassphraseAEAD returns the AES-256-GCM cipher under the key derived from the
+// passphrase and salt with scrypt, as used by EncryptPrivateKey.
+func PassphraseAEAD(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// appendInts appends the length-prefixed big-endian encoding of the integers
+// to b.
+func appendInts(b []byte, ints ...*big.Int) []byte {
+	for _, x := range ints {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(x.Bytes())))
+		b = append(b, x.Bytes()...)
+	}
+	return b
+}
+
+// parseInts parses the count integers of a key of the given kind.
+func parseInts(data []byte, kind byte, count int) ([]*big.Int, error) {
+	if len(data) < 2 {
+		return nil, ErrInvalidKey
+	}
+	if data[0] != encodingVersion {
+		return nil, fmt.Errorf("paillier: unsupported key version %d", data[0])
+	}
+	if data[1] != kind {
+		return nil, ErrInvalidKey
+	}
+
+	data = data[2:]
+	ints := make([]*big.Int, count)
+	for i := range ints {
+		if len(data) < 4 {
+			return nil, ErrInvalidKey
+		}
+		size := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(size) {
+			return nil, ErrInvalidKey
+		}
+		ints[i] = new(big.Int).SetBytes(data[:size])
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		return nil, ErrInvalidKey
+	}
+
+	return ints, nil
+}
@@ -0,0 +1,196 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// checkSameKey checks that the loaded key decrypts a cipher text of the
+// original one.
+func checkSameKey(t *testing.T, privKey *PrivateKey, loaded *PrivateKey) {
+	t.Helper()
+	if loaded.N.Cmp(privKey.N) != 0 || loaded.G.Cmp(privKey.G) != 0 || loaded.NSquared.Cmp(privKey.NSquared) != 0 {
+		t.Fatalf("Loaded public key does not match")
+	}
+	c, _, err := Encrypt(&privKey.PublicKey, big.NewInt(42).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	d, err := Decrypt(loaded, c)
+	if err != nil {
+		t.Fatalf("Failed to decrypt with the loaded key: %v", err)
+	}
+	if new(big.Int).SetBytes(d).Int64() != 42 {
+		t.Errorf("Loaded key decrypted %s, want 42", new(big.Int).SetBytes(d))
+	}
+}
+
+func TestMarshalKeys(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	// Binary
+	data, err := privKey.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	loaded := new(PrivateKey)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal private key: %v", err)
+	}
+	checkSameKey(t, privKey, loaded)
+
+	data, err = privKey.PublicKey.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	pubKey := new(PublicKey)
+	if err := pubKey.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal public key: %v", err)
+	}
+	if pubKey.N.Cmp(privKey.N) != 0 || pubKey.G.Cmp(privKey.G) != 0 {
+		t.Errorf("Loaded public key does not match")
+	}
+	if err := new(PrivateKey).UnmarshalBinary(data); err == nil {
+		t.Errorf("A public key should not load as a private key")
+	}
+
+	// JSON
+	data, err = json.Marshal(privKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key to JSON: %v", err)
+	}
+	loaded = new(PrivateKey)
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("Failed to unmarshal private key from JSON: %v", err)
+	}
+	checkSameKey(t, privKey, loaded)
+
+	data, err = json.Marshal(&privKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key to JSON: %v", err)
+	}
+	pubKey = new(PublicKey)
+	if err := json.Unmarshal(data, pubKey); err != nil {
+		t.Fatalf("Failed to unmarshal public key from JSON: %v", err)
+	}
+	if pubKey.N.Cmp(privKey.N) != 0 || pubKey.NSquared.Cmp(privKey.NSquared) != 0 {
+		t.Errorf("Loaded public key does not match")
+	}
+
+	// Keys without primes have no private encoding
+	if _, err := (&PrivateKey{PublicKey: *pubKey}).MarshalBinary(); err == nil {
+		t.Errorf("Marshalling a public-only key should fail")
+	}
+}
+
+func TestUnmarshalInvalidKeys(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	data, _ := privKey.MarshalBinary()
+
+	for name, invalid := range map[string][]byte{
+		"empty":     nil,
+		"version":   append([]byte{2}, data[1:]...),
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+	} {
+		if err := new(PrivateKey).UnmarshalBinary(invalid); err == nil {
+			t.Errorf("Unmarshalling a %s key should fail", name)
+		}
+	}
+
+	// The primes must be prime and multiply to n
+	p, q := privKey.Primes()
+	composite := appendInts([]byte{encodingVersion, kindPrivate}, p, new(big.Int).Add(q, one))
+	if err := new(PrivateKey).UnmarshalBinary(composite); err == nil {
+		t.Errorf("Unmarshalling a key with a composite prime should fail")
+	}
+	wrongN := []byte(`{"version":1,"n":"15","p":"` + p.String() + `","q":"` + q.String() + `"}`)
+	if err := json.Unmarshal(wrongN, new(PrivateKey)); err == nil {
+		t.Errorf("Unmarshalling a key with the wrong modulus should fail")
+	}
+	if err := json.Unmarshal([]byte(`{"version":2,"n":"15"}`), new(PublicKey)); err == nil {
+		t.Errorf("Unmarshalling an unknown version should fail")
+	}
+}
+
+func TestPEMKeys(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	data, err := privKey.PublicKey.MarshalPEM()
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	pubKey, err := ParsePublicKeyPEM(data)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	if pubKey.N.Cmp(privKey.N) != 0 {
+		t.Errorf("Parsed public key does not match")
+	}
+	if _, err := ParsePrivateKeyPEM(data, nil); err == nil {
+		t.Errorf("A public key should not parse as a private key")
+	}
+
+	data, err = privKey.MarshalPEM(nil)
+	if err != nil {
+		t.Fatalf("Failed to encode private key: %v", err)
+	}
+	loaded, err := ParsePrivateKeyPEM(data, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	checkSameKey(t, privKey, loaded)
+
+	// Encrypted under a passphrase
+	data, err = privKey.MarshalPEM([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Failed to encode encrypted private key: %v", err)
+	}
+	loaded, err = ParsePrivateKeyPEM(data, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Failed to parse encrypted private key: %v", err)
+	}
+	checkSameKey(t, privKey, loaded)
+	if _, err := ParsePrivateKeyPEM(data, []byte("battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Parsing with the wrong passphrase returned %v", err)
+	}
+}
+
+func TestEncryptPrivateKey(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	passphrase := []byte("correct horse")
+
+	data, err := EncryptPrivateKey(privKey, passphrase)
+	if err != nil {
+		t.Fatalf("Failed to encrypt private key: %v", err)
+	}
+	loaded, err := DecryptPrivateKey(data, passphrase)
+	if err != nil {
+		t.Fatalf("Failed to decrypt private key: %v", err)
+	}
+	checkSameKey(t, privKey, loaded)
+
+	// The salt is part of the authenticated data
+	data[1] ^= 1
+	if _, err := DecryptPrivateKey(data, passphrase); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Decrypting a tampered key returned %v", err)
+	}
+	if _, err := DecryptPrivateKey(data[:5], passphrase); err == nil {
+		t.Errorf("Decrypting a truncated key should fail")
+	}
+}
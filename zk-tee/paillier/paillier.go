@@ -13,6 +13,10 @@ var one = big.NewInt(1)
 // too large for the size of the public key.
 var ErrMessageTooLong = errors.New("paillier: message too long for Paillier public key size")
 
+// ErrInvalidCipher is returned for a cipher text that is not an invertible
+// element modulo n^(s+1).
+var ErrInvalidCipher = errors.New("paillier: invalid cipher text")
+
 // GenerateKey generates an Paillier keypair of the given bit size using the
 // random source random (for example, crypto/rand.Reader).
 func GenerateKey(random io.Reader, bits int) (*PrivateKey, error) {
@@ -36,6 +40,12 @@ func GenerateKey(random io.Reader, bits int) (*PrivateKey, error) {
 		return nil, err
 	}
 
+	return NewPrivateKey(p, q), nil
+}
+
+// NewPrivateKey returns the private key of primes p and q, recomputing the
+// values used for CRT decryption.
+func NewPrivateKey(p *big.Int, q *big.Int) *PrivateKey {
 	n := new(big.Int).Mul(p, q)
 	pp := new(big.Int).Mul(p, p)
 	qq := new(big.Int).Mul(q, q)
@@ -56,7 +66,13 @@ func GenerateKey(random io.Reader, bits int) (*PrivateKey, error) {
 		hp:        h(p, pp, n),
 		hq:        h(q, qq, n),
 		n:         n,
-	}, nil
+	}
+}
+
+// Primes returns the primes p and q of the key, or nils for a key that only
+// holds a public key.
+func (privKey *PrivateKey) Primes() (*big.Int, *big.Int) {
+	return privKey.p, privKey.q
 }
 
 // PrivateKey represents a Paillier key.
@@ -79,6 +95,20 @@ type PublicKey struct {
 	N        *big.Int // modulus
 	G        *big.Int // n+1, since p and q are same length
 	NSquared *big.Int
+	// S is the degree of the Damgard-Jurik generalization: plain texts live
+	// modulo n^S and cipher texts modulo n^(S+1). Zero and one both stand for
+	// plain Paillier, see NewDamgardJurikPublicKey.
+	S int
+}
+
+// NewPublicKey returns the public key of modulus n, with g = n + 1 as
+// generated by GenerateKey.
+func NewPublicKey(n *big.Int) *PublicKey {
+	return &PublicKey{
+		N:        new(big.Int).Set(n),
+		NSquared: new(big.Int).Mul(n, n),
+		G:        new(big.Int).Add(n, one),
+	}
 }
 
 func h(p *big.Int, pp *big.Int, n *big.Int) *big.Int {
@@ -118,9 +148,12 @@ func EncryptAndNonce(pubKey *PublicKey, plainText []byte) ([]byte, *big.Int, err
 
 // EncryptWithNonce encrypts a plain text represented as a byte array using the
 // provided nonce to perform encryption. The passed plain text MUST NOT be
-// larger than the modulus of the passed public key.
+// larger than the plain text space n^s of the passed public key.
 func EncryptWithNonce(pubKey *PublicKey, r *big.Int, plainText []byte) (*big.Int, error) {
 	m := new(big.Int).SetBytes(plainText)
+	if pubKey.degree() > 1 {
+		return encryptDamgardJurik(pubKey, r, m)
+	}
 	if pubKey.N.Cmp(m) < 1 { // N < m
 		return nil, ErrMessageTooLong
 	}
@@ -141,6 +174,9 @@ func EncryptWithNonce(pubKey *PublicKey, r *big.Int, plainText []byte) (*big.Int
 // Decrypt decrypts the passed cipher text.
 func Decrypt(privKey *PrivateKey, cipherText []byte) ([]byte, error) {
 	c := new(big.Int).SetBytes(cipherText)
+	if privKey.degree() > 1 {
+		return decryptDamgardJurik(privKey, c)
+	}
 	if privKey.NSquared.Cmp(c) < 1 { // c < n^2
 		return nil, ErrMessageTooLong
 	}
@@ -158,6 +194,32 @@ func Decrypt(privKey *PrivateKey, cipherText []byte) ([]byte, error) {
 	return m.Bytes(), nil
 }
 
+// DecryptNonce returns the nonce r the passed cipher text was encrypted with,
+// so that the owner of the key can open a cipher text it did not compute,
+// such as the sum of two others.
+func DecryptNonce(privKey *PrivateKey, cipherText []byte) (*big.Int, error) {
+	m, err := Decrypt(privKey, cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	// c * g^-m = r^(n^s) mod n^(s+1), and
+	// r = (r^(n^s) mod n)^(n^-s mod phi(n)) mod n
+	c := new(big.Int).SetBytes(cipherText)
+	modulus := privKey.cipherModulus()
+	gm := new(big.Int).Exp(privKey.G, new(big.Int).SetBytes(m), modulus)
+	rn := new(big.Int).Mul(c, new(big.Int).ModInverse(gm, modulus))
+	rn.Mod(rn, privKey.N)
+
+	phi := new(big.Int).Mul(privKey.pminusone, privKey.qminusone)
+	nInv := new(big.Int).ModInverse(privKey.plainModulus(), phi)
+	if nInv == nil {
+		return nil, errors.New("paillier: n is not invertible modulo phi(n)")
+	}
+
+	return new(big.Int).Exp(rn, nInv, privKey.N), nil
+}
+
 func crt(mp *big.Int, mq *big.Int, privKey *PrivateKey) *big.Int {
 	u := new(big.Int).Mod(new(big.Int).Mul(new(big.Int).Sub(mq, mp), privKey.pinvq), privKey.q)
 	m := new(big.Int).Add(mp, new(big.Int).Mul(u, privKey.p))
@@ -171,13 +233,56 @@ func AddCipher(pubKey *PublicKey, cipher1, cipher2 []byte) []byte {
 	x := new(big.Int).SetBytes(cipher1)
 	y := new(big.Int).SetBytes(cipher2)
 
-	// x * y mod n^2
+	// x * y mod n^(s+1)
 	return new(big.Int).Mod(
 		new(big.Int).Mul(x, y),
-		pubKey.NSquared,
+		pubKey.cipherModulus(),
 	).Bytes()
 }
 
+// SubCipher homomorphically subtracts the second cipher text from the first.
+// We multiply the first cipher text by the inverse of the second, upon
+// decryption, the resulting plain text will be the difference of the
+// corresponding plain texts modulo n^s.
+func SubCipher(pubKey *PublicKey, cipher1, cipher2 []byte) ([]byte, error) {
+	neg, err := Neg(pubKey, cipher2)
+	if err != nil {
+		return nil, err
+	}
+	return AddCipher(pubKey, cipher1, neg), nil
+}
+
+// Neg homomorphically negates a cipher text by inverting it modulo n^(s+1).
+// Upon decryption, the resulting plain text will be the opposite of the plain
+// text modulo n^s.
+func Neg(pubKey *PublicKey, cipher []byte) ([]byte, error) {
+	c := new(big.Int).SetBytes(cipher)
+	inv := new(big.Int).ModInverse(c, pubKey.cipherModulus())
+	if inv == nil {
+		return nil, ErrInvalidCipher
+	}
+	return inv.Bytes(), nil
+}
+
+// Rerandomize returns a fresh encryption of the plain text of the cipher
+// text, c * r^(n^s) mod n^(s+1) for a random r, along with r. The randomness
+// of the new cipher text is the product of the old one and r.
+func Rerandomize(pubKey *PublicKey, cipher []byte) ([]byte, *big.Int, error) {
+	modulus := pubKey.cipherModulus()
+	c := new(big.Int).SetBytes(cipher)
+	if c.Sign() <= 0 || c.Cmp(modulus) >= 0 {
+		return nil, nil, ErrInvalidCipher
+	}
+	r, err := randomUnit(pubKey.N)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// c * r^(n^s) mod n^(s+1)
+	c.Mul(c, new(big.Int).Exp(r, pubKey.plainModulus(), modulus)).Mod(c, modulus)
+	return c.Bytes(), r, nil
+}
+
 // Add homomorphically adds a passed constant to the encrypted integer
 // (our cipher text). We do this by multiplying the constant with our
 // ciphertext. Upon decryption, the resulting plain text will be the sum of
@@ -186,10 +291,11 @@ func Add(pubKey *PublicKey, cipher, constant []byte) []byte {
 	c := new(big.Int).SetBytes(cipher)
 	x := new(big.Int).SetBytes(constant)
 
-	// c * g ^ x mod n^2
+	// c * g ^ x mod n^(s+1)
+	modulus := pubKey.cipherModulus()
 	return new(big.Int).Mod(
-		new(big.Int).Mul(c, new(big.Int).Exp(pubKey.G, x, pubKey.NSquared)),
-		pubKey.NSquared,
+		new(big.Int).Mul(c, new(big.Int).Exp(pubKey.G, x, modulus)),
+		modulus,
 	).Bytes()
 }
 
@@ -201,6 +307,6 @@ func Mul(pubKey *PublicKey, cipher []byte, constant []byte) []byte {
 	c := new(big.Int).SetBytes(cipher)
 	x := new(big.Int).SetBytes(constant)
 
-	// c ^ x mod n^2
-	return new(big.Int).Exp(c, x, pubKey.NSquared).Bytes()
+	// c ^ x mod n^(s+1)
+	return new(big.Int).Exp(c, x, pubKey.cipherModulus()).Bytes()
 }
@@ -69,3 +69,157 @@ func TestPaillierCryptosystem(t *testing.T) {
 		t.Errorf("Multiplication of 15*10 failed, got %s", new(big.Int).SetBytes(decryptedMul).String())
 	}
 }
+
+func TestNewPrivateKey(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	// A key rebuilt from its primes decrypts the ciphertexts of the original.
+	loaded := NewPrivateKey(privKey.Primes())
+	if loaded.N.Cmp(privKey.N) != 0 {
+		t.Fatalf("Rebuilt key has modulus %s, want %s", loaded.N, privKey.N)
+	}
+
+	c, _, err := Encrypt(&privKey.PublicKey, big.NewInt(42).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	d, err := Decrypt(loaded, c)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if new(big.Int).SetBytes(d).Int64() != 42 {
+		t.Errorf("Decryption of 42 failed, got %s", new(big.Int).SetBytes(d))
+	}
+
+	// Public keys have no primes.
+	p, q := (&PrivateKey{PublicKey: privKey.PublicKey}).Primes()
+	if p != nil || q != nil {
+		t.Errorf("Public key has primes")
+	}
+}
+
+func TestDecryptNonce(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	c1, r1, err := Encrypt(&privKey.PublicKey, big.NewInt(15).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 15: %v", err)
+	}
+	r, err := DecryptNonce(privKey, c1)
+	if err != nil {
+		t.Fatalf("Failed to decrypt nonce: %v", err)
+	}
+	if r.Cmp(r1) != 0 {
+		t.Errorf("Decrypted nonce %s, want %s", r, r1)
+	}
+
+	// The nonce of a sum is the product of the nonces.
+	c2, r2, err := Encrypt(&privKey.PublicKey, big.NewInt(20).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 20: %v", err)
+	}
+	r, err = DecryptNonce(privKey, AddCipher(&privKey.PublicKey, c1, c2))
+	if err != nil {
+		t.Fatalf("Failed to decrypt nonce: %v", err)
+	}
+	want := new(big.Int).Mod(new(big.Int).Mul(r1, r2), privKey.N)
+	if r.Cmp(want) != 0 {
+		t.Errorf("Decrypted nonce of the sum %s, want %s", r, want)
+	}
+}
+
+func TestSubCipherAndNeg(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	pubKey := &privKey.PublicKey
+
+	c35, _, err := Encrypt(pubKey, big.NewInt(35).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 35: %v", err)
+	}
+	c15, _, err := Encrypt(pubKey, big.NewInt(15).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 15: %v", err)
+	}
+
+	// Subtract the encrypted integer 15 from 35.
+	diff, err := SubCipher(pubKey, c35, c15)
+	if err != nil {
+		t.Fatalf("Failed to subtract: %v", err)
+	}
+	d, err := Decrypt(privKey, diff)
+	if err != nil {
+		t.Fatalf("Failed to decrypt subtraction: %v", err)
+	}
+	if new(big.Int).SetBytes(d).Int64() != 20 {
+		t.Errorf("Subtraction of 35-15 failed, got %s", new(big.Int).SetBytes(d))
+	}
+
+	// Negatives wrap around n.
+	neg, err := Neg(pubKey, c15)
+	if err != nil {
+		t.Fatalf("Failed to negate: %v", err)
+	}
+	d, err = Decrypt(privKey, neg)
+	if err != nil {
+		t.Fatalf("Failed to decrypt negation: %v", err)
+	}
+	want := new(big.Int).Sub(privKey.N, big.NewInt(15))
+	if new(big.Int).SetBytes(d).Cmp(want) != 0 {
+		t.Errorf("Negation of 15 failed, got %s", new(big.Int).SetBytes(d))
+	}
+
+	// Multiples of a prime factor are not cipher texts.
+	p, _ := privKey.Primes()
+	if _, err := Neg(pubKey, p.Bytes()); err != ErrInvalidCipher {
+		t.Errorf("Negating a non-invertible cipher text returned %v", err)
+	}
+}
+
+func TestRerandomize(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, utils.PaillierBits)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	c, r1, err := Encrypt(&privKey.PublicKey, big.NewInt(42).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	fresh, r, err := Rerandomize(&privKey.PublicKey, c)
+	if err != nil {
+		t.Fatalf("Failed to rerandomize: %v", err)
+	}
+	if new(big.Int).SetBytes(fresh).Cmp(new(big.Int).SetBytes(c)) == 0 {
+		t.Errorf("Rerandomized cipher text is unchanged")
+	}
+
+	// Same plain text, randomness multiplied by r.
+	d, err := Decrypt(privKey, fresh)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if new(big.Int).SetBytes(d).Int64() != 42 {
+		t.Errorf("Rerandomized cipher text decrypted to %s, want 42", new(big.Int).SetBytes(d))
+	}
+	nonce, err := DecryptNonce(privKey, fresh)
+	if err != nil {
+		t.Fatalf("Failed to decrypt nonce: %v", err)
+	}
+	want := new(big.Int).Mod(new(big.Int).Mul(r1, r), privKey.N)
+	if nonce.Cmp(want) != 0 {
+		t.Errorf("Rerandomized nonce %s, want %s", nonce, want)
+	}
+
+	if _, _, err := Rerandomize(&privKey.PublicKey, privKey.NSquared.Bytes()); err != ErrInvalidCipher {
+		t.Errorf("Rerandomizing an out of range cipher text returned %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// challengeBits is the size of the Fiat-Shamir challenge of the proofs. It
+// must stay below the size of the primes of the key for the proofs to be
+// sound.
+const challengeBits = 128
+
+// decryptionProofTag separates the challenges of decryption proofs from the
+// hashes of other protocols.
+var decryptionProofTag = []byte("paillier decryption proof v1")
+
+// DecryptionProof proves that a cipher text decrypts to a given plain text
+// without revealing the private key. c decrypts to m exactly when u = c * g^-m
+// is an n-th power r^n mod n^2, so the prover shows it knows such an r: it
+// commits to A = s^n for a random s, derives the challenge e from the hash of
+// the statement and A, and answers with Z = s * r^e mod n, which satisfies
+// Z^n = A * u^e mod n^2.
+type DecryptionProof struct {
+	A *big.Int
+	Z *big.Int
+}
+
+// Prove decrypts the cipher text and returns its plain text along with a
+// proof that the cipher text decrypts to it, which anyone holding the public
+// key can check with Verify. Damgard-Jurik keys are not supported.
+func Prove(privKey *PrivateKey, cipherText []byte) (*big.Int, *DecryptionProof, error) {
+	if privKey.degree() > 1 {
+		return nil, nil, errors.New("paillier: decryption proofs need a Paillier key")
+	}
+	plainText, err := Decrypt(privKey, cipherText)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := new(big.Int).SetBytes(plainText)
+	r, err := DecryptNonce(privKey, cipherText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := randomUnit(privKey.N)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := new(big.Int).Exp(s, privKey.N, privKey.NSquared)
+	e := decryptionChallenge(&privKey.PublicKey, new(big.Int).SetBytes(cipherText), m, a)
+
+	// z = s * r^e mod n
+	z := new(big.Int).Exp(r, e, privKey.N)
+	z.Mul(z, s).Mod(z, privKey.N)
+
+	return m, &DecryptionProof{A: a, Z: z}, nil
+}
+
+// Verify reports whether the proof shows that the cipher text decrypts to m
+// under the public key.
+func Verify(pubKey *PublicKey, cipherText []byte, m *big.Int, proof *DecryptionProof) bool {
+	if pubKey.degree() > 1 {
+		return false
+	}
+	c := new(big.Int).SetBytes(cipherText)
+	if proof == nil || proof.A == nil || proof.Z == nil || m == nil || m.Sign() < 0 || m.Cmp(pubKey.N) >= 0 {
+		return false
+	}
+	if !isUnit(c, pubKey.NSquared, pubKey.N) || !isUnit(proof.A, pubKey.NSquared, pubKey.N) || !isUnit(proof.Z, pubKey.N, pubKey.N) {
+		return false
+	}
+
+	// u = c * g^-m mod n^2
+	gm := new(big.Int).Exp(pubKey.G, m, pubKey.NSquared)
+	u := new(big.Int).Mul(c, new(big.Int).ModInverse(gm, pubKey.NSquared))
+	u.Mod(u, pubKey.NSquared)
+
+	e := decryptionChallenge(pubKey, c, m, proof.A)
+
+	// z^n = a * u^e mod n^2
+	lhs := new(big.Int).Exp(proof.Z, pubKey.N, pubKey.NSquared)
+	rhs := new(big.Int).Exp(u, e, pubKey.NSquared)
+	rhs.Mul(rhs, proof.A).Mod(rhs, pubKey.NSquared)
+	return lhs.Cmp(rhs) == 0
+}
+
+// MarshalBinary encodes the proof.
+func (proof *DecryptionProof) MarshalBinary() ([]byte, error) {
+	if proof.A == nil || proof.Z == nil {
+		return nil, errors.New("paillier: incomplete decryption proof")
+	}
+	return appendInts([]byte{encodingVersion, kindDecryptionProof}, proof.A, proof.Z), nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary.
+func (proof *DecryptionProof) UnmarshalBinary(data []byte) error {
+	ints, err := parseInts(data, kindDecryptionProof, 2)
+	if err != nil {
+		return err
+	}
+	proof.A, proof.Z = ints[0], ints[1]
+	return nil
+}
+
+// decryptionChallenge returns the Fiat-Shamir challenge of a decryption
+// proof: the first challengeBits bits of the hash of the public key, the
+// statement and the commitment.
+func decryptionChallenge(pubKey *PublicKey, c *big.Int, m *big.Int, a *big.Int) *big.Int {
+	transcript := appendInts(append([]byte{}, decryptionProofTag...), pubKey.N, c, m, a)
+	digest := sha256.Sum256(transcript)
+	return new(big.Int).SetBytes(digest[:challengeBits/8])
+}
+
+// randomUnit returns a random element of Z_n*.
+func randomUnit(n *big.Int) (*big.Int, error) {
+	for {
+		s, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		if isUnit(s, n, n) {
+			return s, nil
+		}
+	}
+}
+
+// isUnit reports whether 0 < x < bound and x is coprime to n.
+func isUnit(x *big.Int, bound *big.Int, n *big.Int) bool {
+	return x.Sign() > 0 && x.Cmp(bound) < 0 && new(big.Int).GCD(nil, nil, x, n).Cmp(one) == 0
+}
@@ -0,0 +1,95 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestDecryptionProof(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	pubKey := &privKey.PublicKey
+
+	// A balance built homomorphically, as the owner receives it
+	c15, _, err := Encrypt(pubKey, big.NewInt(15).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 15: %v", err)
+	}
+	c20, _, err := Encrypt(pubKey, big.NewInt(20).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 20: %v", err)
+	}
+	c := AddCipher(pubKey, c15, c20)
+
+	m, proof, err := Prove(privKey, c)
+	if err != nil {
+		t.Fatalf("Failed to prove decryption: %v", err)
+	}
+	if m.Int64() != 35 {
+		t.Fatalf("Proved decryption to %s, want 35", m)
+	}
+	if !Verify(pubKey, c, m, proof) {
+		t.Fatalf("Valid decryption proof rejected")
+	}
+
+	// The proof only holds for its statement
+	if Verify(pubKey, c, big.NewInt(36), proof) {
+		t.Errorf("Proof accepted for the wrong plain text")
+	}
+	if Verify(pubKey, c15, m, proof) {
+		t.Errorf("Proof accepted for another cipher text")
+	}
+	otherKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	if Verify(&otherKey.PublicKey, c, m, proof) {
+		t.Errorf("Proof accepted under another key")
+	}
+	forged := &DecryptionProof{A: proof.A, Z: new(big.Int).Add(proof.Z, one)}
+	if Verify(pubKey, c, m, forged) {
+		t.Errorf("Forged proof accepted")
+	}
+	if Verify(pubKey, c, m, &DecryptionProof{A: big.NewInt(0), Z: proof.Z}) || Verify(pubKey, c, m, nil) {
+		t.Errorf("Malformed proof accepted")
+	}
+}
+
+func TestMarshalDecryptionProof(t *testing.T) {
+	privKey, err := GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatalf("Failed to generate private key: %v", err)
+	}
+	c, _, err := Encrypt(&privKey.PublicKey, big.NewInt(42).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	m, proof, err := Prove(privKey, c)
+	if err != nil {
+		t.Fatalf("Failed to prove decryption: %v", err)
+	}
+
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal proof: %v", err)
+	}
+	loaded := new(DecryptionProof)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal proof: %v", err)
+	}
+	if !Verify(&privKey.PublicKey, c, m, loaded) {
+		t.Errorf("Unmarshalled proof rejected")
+	}
+
+	// Keys do not decode as proofs
+	keyData, _ := privKey.MarshalBinary()
+	if err := new(DecryptionProof).UnmarshalBinary(keyData); err == nil {
+		t.Errorf("Unmarshalling a key as a proof should fail")
+	}
+	if err := new(DecryptionProof).UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("Unmarshalling a truncated proof should fail")
+	}
+}
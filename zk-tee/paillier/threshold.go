@@ -0,0 +1,291 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Threshold decryption follows Shoup's threshold RSA as adapted to Paillier by
+// Fouque, Poupard and Stern and by Damgard and Jurik. A dealer picks safe
+// primes p = 2p'+1 and q = 2q'+1 and the secret d with d = 0 mod p'q' and
+// d = 1 mod n, which it splits with a polynomial of degree threshold-1 over
+// Z_{n p'q'}. Each of the parties raises a cipher text to its share of d, and
+// any threshold of these partial decryptions combine into the plain text,
+// while fewer reveal nothing. The dealer knows the factorization and must
+// forget it once the shares are handed out.
+
+// ThresholdPublicKey is the public key of a committee of Parties members, any
+// Threshold of which can decrypt together.
+type ThresholdPublicKey struct {
+	PublicKey
+	Threshold int
+	Parties   int
+	// V generates the squares of Z_{n^2}*, and VerificationKeys[i-1] is
+	// V^(Delta*s_i) for the share s_i of party i, against which its partial
+	// decryptions are checked.
+	V                *big.Int
+	VerificationKeys []*big.Int
+}
+
+// ThresholdKeyShare is the share of the decryption key held by the party at
+// Index, from 1 to Parties.
+type ThresholdKeyShare struct {
+	PublicKey *ThresholdPublicKey
+	Index     int
+	Share     *big.Int
+}
+
+// DecryptionShare is the partial decryption of a cipher text by the party at
+// Index, c^(2*Delta*s_i) mod n^2, along with a proof that it used the share
+// matching its verification key.
+type DecryptionShare struct {
+	Index int
+	C     *big.Int
+	// E and Z prove that log_{c^4}(C^2) = log_V(V_i), see proveShare.
+	E *big.Int
+	Z *big.Int
+}
+
+// shareProofTag separates the challenges of decryption share proofs from the
+// hashes of other protocols.
+var shareProofTag = []byte("paillier threshold decryption share v1")
+
+// statisticalBits is the statistical security of the proofs over the
+// integers, which hide the share in a random exponent this many bits larger.
+const statisticalBits = 128
+
+// GenerateThresholdKey deals a key of the given bit size to parties members,
+// any threshold of which can decrypt, using the random source random (for
+// example, crypto/rand.Reader).
+func GenerateThresholdKey(random io.Reader, bits int, threshold int, parties int) (*ThresholdPublicKey, []*ThresholdKeyShare, error) {
+	if threshold < 1 || threshold > parties {
+		return nil, nil, fmt.Errorf("paillier: invalid threshold %d of %d parties", threshold, parties)
+	}
+
+	p, pp, err := safePrime(random, bits/2)
+	if err != nil {
+		return nil, nil, err
+	}
+	q, qq, err := safePrime(random, bits/2)
+	for err == nil && q.Cmp(p) == 0 {
+		q, qq, err = safePrime(random, bits/2)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := new(big.Int).Mul(p, q)
+	m := new(big.Int).Mul(pp, qq)
+	nm := new(big.Int).Mul(n, m)
+	// d = 0 mod m and d = 1 mod n
+	d := new(big.Int).ModInverse(m, n)
+	if d == nil {
+		return nil, nil, errors.New("paillier: n and p'q' are not coprime")
+	}
+	d.Mul(d, m)
+
+	// f(X) = d + a_1 X + ... + a_{threshold-1} X^{threshold-1} mod nm
+	coeffs := []*big.Int{d}
+	for i := 1; i < threshold; i++ {
+		a, err := rand.Int(random, nm)
+		if err != nil {
+			return nil, nil, err
+		}
+		coeffs = append(coeffs, a)
+	}
+
+	r, err := randomUnit(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKey := &ThresholdPublicKey{
+		PublicKey: *NewPublicKey(n),
+		Threshold: threshold,
+		Parties:   parties,
+	}
+	pubKey.V = new(big.Int).Exp(r, big.NewInt(2), pubKey.NSquared)
+
+	delta := factorial(parties)
+	shares := make([]*ThresholdKeyShare, parties)
+	for i := range shares {
+		s := evalPolynomial(coeffs, big.NewInt(int64(i+1)), nm)
+		shares[i] = &ThresholdKeyShare{PublicKey: pubKey, Index: i + 1, Share: s}
+		vk := new(big.Int).Exp(pubKey.V, new(big.Int).Mul(delta, s), pubKey.NSquared)
+		pubKey.VerificationKeys = append(pubKey.VerificationKeys, vk)
+	}
+
+	return pubKey, shares, nil
+}
+
+// Decrypt returns the partial decryption of the cipher text by the share.
+func (share *ThresholdKeyShare) Decrypt(cipherText []byte) (*DecryptionShare, error) {
+	pubKey := share.PublicKey
+	c := new(big.Int).SetBytes(cipherText)
+	if !isUnit(c, pubKey.NSquared, pubKey.N) {
+		return nil, errors.New("paillier: invalid cipher text")
+	}
+
+	// c_i = c^(2*Delta*s_i) mod n^2
+	x := new(big.Int).Mul(factorial(pubKey.Parties), share.Share)
+	ci := new(big.Int).Exp(c, new(big.Int).Lsh(x, 1), pubKey.NSquared)
+
+	e, z, err := proveShare(pubKey, share.Index, c, ci, x)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptionShare{Index: share.Index, C: ci, E: e, Z: z}, nil
+}
+
+// VerifyShare reports whether the partial decryption of the cipher text was
+// computed with the share of its party.
+func (pubKey *ThresholdPublicKey) VerifyShare(cipherText []byte, share *DecryptionShare) bool {
+	if share == nil || share.Index < 1 || share.Index > pubKey.Parties || len(pubKey.VerificationKeys) != pubKey.Parties {
+		return false
+	}
+	if share.C == nil || share.E == nil || share.Z == nil || share.E.Sign() < 0 || share.Z.Sign() < 0 {
+		return false
+	}
+	c := new(big.Int).SetBytes(cipherText)
+	if !isUnit(c, pubKey.NSquared, pubKey.N) || !isUnit(share.C, pubKey.NSquared, pubKey.N) {
+		return false
+	}
+
+	c4 := new(big.Int).Exp(c, big.NewInt(4), pubKey.NSquared)
+	ci2 := new(big.Int).Exp(share.C, big.NewInt(2), pubKey.NSquared)
+	vi := pubKey.VerificationKeys[share.Index-1]
+
+	// a = c^(4z) * c_i^(-2e), b = v^z * v_i^(-e)
+	a := new(big.Int).Exp(c4, share.Z, pubKey.NSquared)
+	a.Mul(a, new(big.Int).ModInverse(new(big.Int).Exp(ci2, share.E, pubKey.NSquared), pubKey.NSquared)).Mod(a, pubKey.NSquared)
+	b := new(big.Int).Exp(pubKey.V, share.Z, pubKey.NSquared)
+	b.Mul(b, new(big.Int).ModInverse(new(big.Int).Exp(vi, share.E, pubKey.NSquared), pubKey.NSquared)).Mod(b, pubKey.NSquared)
+
+	return shareChallenge(pubKey, share.Index, c4, ci2, a, b).Cmp(share.E) == 0
+}
+
+// Combine decrypts the cipher text from the partial decryptions of at least
+// Threshold distinct parties. Shares that fail verification are skipped, so
+// that a faulty party cannot prevent the decryption as long as Threshold
+// others answered.
+func (pubKey *ThresholdPublicKey) Combine(cipherText []byte, shares []*DecryptionShare) ([]byte, error) {
+	// Only the first Threshold valid shares are used
+	var used []*DecryptionShare
+	seen := make(map[int]bool)
+	for _, share := range shares {
+		if len(used) == pubKey.Threshold {
+			break
+		}
+		if share == nil || seen[share.Index] || !pubKey.VerifyShare(cipherText, share) {
+			continue
+		}
+		seen[share.Index] = true
+		used = append(used, share)
+	}
+	if len(used) < pubKey.Threshold {
+		return nil, fmt.Errorf("paillier: %d valid decryption shares, need %d", len(used), pubKey.Threshold)
+	}
+
+	// c' = prod c_i^(2*mu_i) = c^(4*Delta^2*d), with mu_i = Delta*lambda_i the
+	// Lagrange coefficient at 0 scaled to an integer
+	delta := factorial(pubKey.Parties)
+	combined := big.NewInt(1)
+	for _, share := range used {
+		num := new(big.Int).Set(delta)
+		den := big.NewInt(1)
+		for _, other := range used {
+			if other.Index == share.Index {
+				continue
+			}
+			num.Mul(num, big.NewInt(int64(other.Index)))
+			den.Mul(den, big.NewInt(int64(other.Index-share.Index)))
+		}
+		mu := num.Quo(num, den)
+
+		base := share.C
+		if mu.Sign() < 0 {
+			base = new(big.Int).ModInverse(share.C, pubKey.NSquared)
+			mu.Neg(mu)
+		}
+		term := new(big.Int).Exp(base, mu.Lsh(mu, 1), pubKey.NSquared)
+		combined.Mul(combined, term).Mod(combined, pubKey.NSquared)
+	}
+
+	// m = L(c') * (4*Delta^2)^-1 mod n
+	scale := new(big.Int).Mul(delta, delta)
+	scale.Lsh(scale, 2)
+	scaleInv := new(big.Int).ModInverse(scale, pubKey.N)
+	if scaleInv == nil {
+		return nil, errors.New("paillier: 4*Delta^2 is not invertible modulo n")
+	}
+	m := l(combined, pubKey.N)
+	m.Mul(m, scaleInv).Mod(m, pubKey.N)
+
+	return m.Bytes(), nil
+}
+
+// proveShare proves that the partial decryption c_i = c^(2x) used the exponent
+// x = Delta*s_i of the verification key v_i = v^x, by showing that c^4 and v
+// have the same discrete logarithm x to c_i^2 and v_i. The proof is a
+// Chaum-Pedersen proof over the integers, since the order of the group is
+// secret: the prover commits with a random exponent r much larger than x and
+// answers z = r + e*x without reduction.
+func proveShare(pubKey *ThresholdPublicKey, index int, c *big.Int, ci *big.Int, x *big.Int) (*big.Int, *big.Int, error) {
+	bound := new(big.Int).Lsh(one, uint(x.BitLen()+challengeBits+statisticalBits))
+	r, err := rand.Int(rand.Reader, bound)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c4 := new(big.Int).Exp(c, big.NewInt(4), pubKey.NSquared)
+	ci2 := new(big.Int).Exp(ci, big.NewInt(2), pubKey.NSquared)
+	a := new(big.Int).Exp(c4, r, pubKey.NSquared)
+	b := new(big.Int).Exp(pubKey.V, r, pubKey.NSquared)
+
+	e := shareChallenge(pubKey, index, c4, ci2, a, b)
+	z := new(big.Int).Mul(e, x)
+	z.Add(z, r)
+	return e, z, nil
+}
+
+// shareChallenge returns the Fiat-Shamir challenge of a decryption share
+// proof.
+func shareChallenge(pubKey *ThresholdPublicKey, index int, c4 *big.Int, ci2 *big.Int, a *big.Int, b *big.Int) *big.Int {
+	transcript := appendInts(append([]byte{}, shareProofTag...),
+		pubKey.N, pubKey.V, pubKey.VerificationKeys[index-1], big.NewInt(int64(index)), c4, ci2, a, b)
+	digest := sha256.Sum256(transcript)
+	return new(big.Int).SetBytes(digest[:challengeBits/8])
+}
+
+// safePrime returns a random safe prime p = 2p'+1 of the given bit size along
+// with p'.
+func safePrime(random io.Reader, bits int) (*big.Int, *big.Int, error) {
+	for {
+		pp, err := rand.Prime(random, bits-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := new(big.Int).Lsh(pp, 1)
+		p.Add(p, one)
+		if p.BitLen() == bits && p.ProbablyPrime(20) {
+			return p, pp, nil
+		}
+	}
+}
+
+// evalPolynomial returns the polynomial of the coefficients, constant term
+// first, at x mod m.
+func evalPolynomial(coeffs []*big.Int, x *big.Int, m *big.Int) *big.Int {
+	y := new(big.Int)
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y.Mul(y, x).Add(y, coeffs[i]).Mod(y, m)
+	}
+	return y
+}
+
+func factorial(n int) *big.Int {
+	return new(big.Int).MulRange(1, int64(n))
+}
@@ -0,0 +1,129 @@
+package paillier
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// TestThresholdDecryption simulates a 3-of-5 committee decrypting a cipher
+// text with every subset of its members.
+func TestThresholdDecryption(t *testing.T) {
+	pubKey, keyShares, err := GenerateThresholdKey(rand.Reader, 256, 3, 5)
+	if err != nil {
+		t.Fatalf("Failed to generate threshold key: %v", err)
+	}
+
+	// An escrowed amount built homomorphically
+	c15, _, err := Encrypt(&pubKey.PublicKey, big.NewInt(15).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 15: %v", err)
+	}
+	c20, _, err := Encrypt(&pubKey.PublicKey, big.NewInt(20).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 20: %v", err)
+	}
+	c := AddCipher(&pubKey.PublicKey, c15, c20)
+
+	// Each party decrypts on its own
+	decShares := make([]*DecryptionShare, len(keyShares))
+	for i, keyShare := range keyShares {
+		decShares[i], err = keyShare.Decrypt(c)
+		if err != nil {
+			t.Fatalf("Party %d failed to decrypt: %v", keyShare.Index, err)
+		}
+		if !pubKey.VerifyShare(c, decShares[i]) {
+			t.Fatalf("Valid share of party %d rejected", keyShare.Index)
+		}
+	}
+
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for d := b + 1; d < 5; d++ {
+				m, err := pubKey.Combine(c, []*DecryptionShare{decShares[d], decShares[a], decShares[b]})
+				if err != nil {
+					t.Fatalf("Failed to combine shares %d, %d and %d: %v", a+1, b+1, d+1, err)
+				}
+				if new(big.Int).SetBytes(m).Int64() != 35 {
+					t.Errorf("Shares %d, %d and %d decrypted %s, want 35", a+1, b+1, d+1, new(big.Int).SetBytes(m))
+				}
+			}
+		}
+	}
+
+	// More shares than needed are fine
+	m, err := pubKey.Combine(c, decShares)
+	if err != nil || new(big.Int).SetBytes(m).Int64() != 35 {
+		t.Errorf("Combining all shares returned %v, %v", m, err)
+	}
+
+	// Too few shares, counting duplicates once
+	if _, err := pubKey.Combine(c, decShares[:2]); err == nil {
+		t.Errorf("Two shares should not decrypt")
+	}
+	if _, err := pubKey.Combine(c, []*DecryptionShare{decShares[0], decShares[1], decShares[1]}); err == nil {
+		t.Errorf("A duplicated share should only count once")
+	}
+}
+
+func TestThresholdInvalidShares(t *testing.T) {
+	pubKey, keyShares, err := GenerateThresholdKey(rand.Reader, 256, 2, 3)
+	if err != nil {
+		t.Fatalf("Failed to generate threshold key: %v", err)
+	}
+	c, _, err := Encrypt(&pubKey.PublicKey, big.NewInt(42).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 42: %v", err)
+	}
+	share1, err := keyShares[0].Decrypt(c)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	share2, err := keyShares[1].Decrypt(c)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+
+	// A wrong partial decryption
+	forged := *share2
+	forged.C = new(big.Int).Mod(new(big.Int).Mul(share2.C, pubKey.G), pubKey.NSquared)
+	if pubKey.VerifyShare(c, &forged) {
+		t.Errorf("Forged partial decryption accepted")
+	}
+	if _, err := pubKey.Combine(c, []*DecryptionShare{share1, &forged}); err == nil {
+		t.Errorf("A forged share should not count towards the threshold")
+	}
+
+	// A forged share among enough valid ones is skipped, even when it claims
+	// the index of a valid share
+	share3, err := keyShares[2].Decrypt(c)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	for _, shares := range [][]*DecryptionShare{{share1, &forged, share3}, {&forged, share1, share2}} {
+		m, err := pubKey.Combine(c, shares)
+		if err != nil || new(big.Int).SetBytes(m).Int64() != 42 {
+			t.Errorf("Combining valid shares along a forged one returned %v, %v", m, err)
+		}
+	}
+
+	// A share claimed by another party
+	relabelled := *share2
+	relabelled.Index = 3
+	if pubKey.VerifyShare(c, &relabelled) {
+		t.Errorf("Share of party 2 accepted for party 3")
+	}
+
+	// A share of another cipher text
+	other, _, err := Encrypt(&pubKey.PublicKey, big.NewInt(43).Bytes())
+	if err != nil {
+		t.Fatalf("Failed to encrypt 43: %v", err)
+	}
+	if pubKey.VerifyShare(other, share1) {
+		t.Errorf("Share accepted for another cipher text")
+	}
+
+	if _, _, err := GenerateThresholdKey(rand.Reader, 256, 4, 3); err == nil {
+		t.Errorf("A threshold above the number of parties should fail")
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/consensys/gnark-crypto/hash"
+)
+
+// blindingTag seeds the derivation of the blinding generator, see
+// BlindingGenerator.
+const blindingTag = "private-erc20/balance-commitment/H"
+
+var (
+	blindingOnce      sync.Once
+	blindingGenerator twistededwards.PointAffine
+)
+
+// BlindingGenerator returns H, the generator of the blinding of the balance
+// commitments. It is derived by hashing blindingTag to the curve, so that
+// nobody knows its discrete logarithm in base of the curve generator G.
+func BlindingGenerator() twistededwards.PointAffine {
+	blindingOnce.Do(func() {
+		curve := twistededwards.GetEdwardsCurve()
+		cofactor := curve.Cofactor.BigInt(new(big.Int))
+		for counter := 0; ; counter++ {
+			hfunc := hash.MIMC_BN254.New()
+			hfunc.Write(Pad32Bytes([]byte(blindingTag)))
+			hfunc.Write(Pad32Bytes(big.NewInt(int64(counter)).Bytes()))
+
+			var y fr.Element
+			y.SetBytes(hfunc.Sum(nil))
+			p, ok := pointWithY(curve, y)
+			if !ok {
+				continue
+			}
+			// Clearing the cofactor lands in the subgroup of G
+			p.ScalarMultiplication(&p, cofactor)
+			if !p.IsZero() {
+				blindingGenerator = p
+				return
+			}
+		}
+	})
+	return blindingGenerator
+}
+
+// pointWithY returns a point of the curve of ordinate y, if there is one:
+// a*x^2 + y^2 = 1 + d*x^2*y^2 gives x^2 = (1 - y^2) / (a - d*y^2).
+func pointWithY(curve twistededwards.CurveParams, y fr.Element) (twistededwards.PointAffine, bool) {
+	var one, y2, num, den, x fr.Element
+	one.SetOne()
+	y2.Square(&y)
+	num.Sub(&one, &y2)
+	den.Mul(&curve.D, &y2)
+	den.Sub(&curve.A, &den)
+	if den.IsZero() {
+		return twistededwards.PointAffine{}, false
+	}
+	den.Inverse(&den)
+	num.Mul(&num, &den)
+	if x.Sqrt(&num) == nil {
+		return twistededwards.PointAffine{}, false
+	}
+	return twistededwards.NewPointAffine(x, y), true
+}
+
+// Commit returns the commitment to a balance, balance*G + blinding*H, where G
+// is the generator of the curve and H the one of BlindingGenerator.
+func Commit(balance *big.Int, blinding *big.Int) twistededwards.PointAffine {
+	curve := twistededwards.GetEdwardsCurve()
+	h := BlindingGenerator()
+
+	var c, sH twistededwards.PointAffine
+	c.ScalarMultiplication(&curve.Base, balance)
+	sH.ScalarMultiplication(&h, blinding)
+	c.Add(&c, &sH)
+	return c
+}
+
+// RandomBlinding returns a random blinding, below the order of G.
+func RandomBlinding() (*big.Int, error) {
+	curve := twistededwards.GetEdwardsCurve()
+	return rand.Int(rand.Reader, &curve.Order)
+}
+
+// BalancePlaintext returns the Paillier plaintext opening a balance
+// commitment, balance + 2^BalanceBits * blinding, which its owner decrypts to
+// recover both. Adding plaintexts adds the balances and the blindings alike,
+// as long as the balances stay below 2^BalanceBits.
+func BalancePlaintext(balance *big.Int, blinding *big.Int) *big.Int {
+	p := new(big.Int).Lsh(blinding, BalanceBits)
+	return p.Add(p, balance)
+}
+
+// OpenPlaintext returns the balance and the blinding of a plaintext, see
+// BalancePlaintext. The blinding is reduced modulo the order of G, which the
+// sums of blindings may exceed.
+func OpenPlaintext(plaintext *big.Int) (*big.Int, *big.Int) {
+	curve := twistededwards.GetEdwardsCurve()
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), BalanceBits), big.NewInt(1))
+	balance := new(big.Int).And(plaintext, mask)
+	blinding := new(big.Int).Rsh(plaintext, BalanceBits)
+	return balance, blinding.Mod(blinding, &curve.Order)
+}
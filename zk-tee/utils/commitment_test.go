@@ -0,0 +1,66 @@
+package utils_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+func TestBlindingGenerator(t *testing.T) {
+	curve := twistededwards.GetEdwardsCurve()
+	h := utils.BlindingGenerator()
+	if !h.IsOnCurve() || h.IsZero() {
+		t.Fatal("blinding generator is not a point of the curve")
+	}
+	if h.Equal(&curve.Base) {
+		t.Fatal("blinding generator is the curve generator")
+	}
+
+	var p twistededwards.PointAffine
+	p.ScalarMultiplication(&h, &curve.Order)
+	if !p.IsZero() {
+		t.Fatal("blinding generator is not in the subgroup of the curve generator")
+	}
+}
+
+func TestCommitIsAdditive(t *testing.T) {
+	b1, s1 := big.NewInt(1000), big.NewInt(7)
+	b2, s2 := big.NewInt(234), big.NewInt(11)
+
+	c1, c2 := utils.Commit(b1, s1), utils.Commit(b2, s2)
+	var sum twistededwards.PointAffine
+	sum.Add(&c1, &c2)
+
+	expected := utils.Commit(big.NewInt(1234), big.NewInt(18))
+	if !sum.Equal(&expected) {
+		t.Fatal("sum of commitments does not commit to the sum of balances")
+	}
+
+	other := utils.Commit(big.NewInt(1234), big.NewInt(19))
+	if sum.Equal(&other) {
+		t.Fatal("commitments to distinct blindings are equal")
+	}
+}
+
+func TestBalancePlaintext(t *testing.T) {
+	curve := twistededwards.GetEdwardsCurve()
+	balance := big.NewInt(5000)
+	blinding, err := utils.RandomBlinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, s := utils.OpenPlaintext(utils.BalancePlaintext(balance, blinding))
+	if b.Cmp(balance) != 0 || s.Cmp(blinding) != 0 {
+		t.Fatal("plaintext does not open to its balance and blinding")
+	}
+
+	// Sums of blindings are reduced, and still open the sum of commitments
+	sum := new(big.Int).Add(utils.BalancePlaintext(balance, blinding), utils.BalancePlaintext(big.NewInt(1), &curve.Order))
+	b, s = utils.OpenPlaintext(sum)
+	if b.Cmp(big.NewInt(5001)) != 0 || s.Cmp(blinding) != 0 {
+		t.Fatal("sum of plaintexts does not open to the sum of balances")
+	}
+}
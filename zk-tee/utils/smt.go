@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash"
+)
+
+// SparseMerkleProof stores the root hash and the siblings of a proof in a
+// fixed-depth sparse Merkle tree, where empty leaves hash to zero.
+type SparseMerkleProof struct {
+	// RootHash root of the sparse Merkle tree
+	RootHash frontend.Variable
+
+	// Siblings siblings of the leaf, from the leaves up to the root
+	Siblings []frontend.Variable
+}
+
+// Nodes returns the nodes on the path of the leaf at index, from the leaf up
+// to the root. The index is decomposed in len(Siblings) little-endian bits,
+// which also bounds it by the capacity of the tree.
+func (mp *SparseMerkleProof) Nodes(api frontend.API, h hash.FieldHasher, leaf, index frontend.Variable) []frontend.Variable {
+	binIndex := api.ToBinary(index, len(mp.Siblings))
+
+	nodes := []frontend.Variable{leaf}
+	for i, sibling := range mp.Siblings {
+		// bit i of the index is set when the current node is a right child
+		left := api.Select(binIndex[i], sibling, nodes[i])
+		right := api.Select(binIndex[i], nodes[i], sibling)
+		nodes = append(nodes, nodeSum(api, h, left, right))
+	}
+
+	return nodes
+}
+
+// root computes the root of the tree holding leaf at index, see Nodes.
+func (mp *SparseMerkleProof) root(api frontend.API, h hash.FieldHasher, leaf, index frontend.Variable) frontend.Variable {
+	nodes := mp.Nodes(api, h, leaf, index)
+	return nodes[len(nodes)-1]
+}
+
+// VerifyMembership asserts that the leaf at index hashes to leaf.
+func (mp *SparseMerkleProof) VerifyMembership(api frontend.API, h hash.FieldHasher, leaf, index frontend.Variable) {
+	api.AssertIsEqual(mp.root(api, h, leaf, index), mp.RootHash)
+}
+
+// VerifyNonMembership asserts that the leaf at index is empty.
+func (mp *SparseMerkleProof) VerifyNonMembership(api frontend.API, h hash.FieldHasher, index frontend.Variable) {
+	mp.VerifyMembership(api, h, 0, index)
+}
+
+// VerifyUpdate asserts that the leaf at index hashes to oldLeaf, and that
+// setting it to newLeaf turns the tree into one of root newRoot. The siblings
+// are shared by both trees since only the leaf changes.
+func (mp *SparseMerkleProof) VerifyUpdate(api frontend.API, h hash.FieldHasher, oldLeaf, newLeaf, index, newRoot frontend.Variable) {
+	mp.VerifyMembership(api, h, oldLeaf, index)
+	api.AssertIsEqual(mp.root(api, h, newLeaf, index), newRoot)
+}
+
+// VerifyInsertion asserts that the leaf at index is empty, and that setting
+// it to leaf turns the tree into one of root newRoot.
+func (mp *SparseMerkleProof) VerifyInsertion(api frontend.API, h hash.FieldHasher, leaf, index, newRoot frontend.Variable) {
+	mp.VerifyUpdate(api, h, 0, leaf, index, newRoot)
+}
+
+// nodeSum returns the hash created from data inserted to form a leaf.
+// Without domain separation.
+func nodeSum(api frontend.API, h hash.FieldHasher, a, b frontend.Variable) frontend.Variable {
+	h.Reset()
+	h.Write(a, b)
+	res := h.Sum()
+
+	return res
+}
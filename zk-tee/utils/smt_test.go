@@ -0,0 +1,214 @@
+package utils_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// testLeaf is a leaf whose hash is its own value.
+type testLeaf struct {
+	value *big.Int
+}
+
+func (l testLeaf) CalculateHash() ([]byte, error) {
+	return utils.Pad32Bytes(l.value.Bytes()), nil
+}
+
+func (l testLeaf) Equals(other merkletree.Content) (bool, error) {
+	otherHash, err := other.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(utils.Pad32Bytes(l.value.Bytes()), otherHash), nil
+}
+
+type TestSparseMerkleCircuit struct {
+	Proof   utils.SparseMerkleProof
+	Leaf    frontend.Variable
+	Index   frontend.Variable
+	NewRoot frontend.Variable
+}
+
+func (circuit *TestSparseMerkleCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Proof.VerifyInsertion(api, &hFunc, circuit.Leaf, circuit.Index, circuit.NewRoot)
+
+	return nil
+}
+
+type TestSparseMembershipCircuit struct {
+	Proof utils.SparseMerkleProof
+	Leaf  frontend.Variable
+	Index frontend.Variable
+}
+
+func (circuit *TestSparseMembershipCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	circuit.Proof.VerifyMembership(api, &hFunc, circuit.Leaf, circuit.Index)
+
+	return nil
+}
+
+func sparseProofValue(root []byte, proof merkletree.SparseMerkleProof) utils.SparseMerkleProof {
+	res := utils.SparseMerkleProof{
+		RootHash: root,
+		Siblings: make([]frontend.Variable, len(proof.Siblings)),
+	}
+	for i, sibling := range proof.Siblings {
+		res.Siblings[i] = sibling
+	}
+	return res
+}
+
+func TestSparseMerkleProof(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 4
+	tree, err := merkletree.NewSparseTree(depth)
+	assert.NoError(err)
+	for _, i := range []int{1, 2, 9} {
+		_, err := tree.UpdateLeafAt(i, testLeaf{big.NewInt(int64(100 + i))})
+		assert.NoError(err)
+	}
+
+	membershipCircuit := &TestSparseMembershipCircuit{
+		Proof: utils.SparseMerkleProof{Siblings: make([]frontend.Variable, depth)},
+	}
+	insertionCircuit := &TestSparseMerkleCircuit{
+		Proof: utils.SparseMerkleProof{Siblings: make([]frontend.Variable, depth)},
+	}
+
+	// Membership of an existing leaf
+	proof, err := tree.ProveMembership(9)
+	assert.NoError(err)
+	witness := &TestSparseMembershipCircuit{
+		Proof: sparseProofValue(tree.MerkleRoot(), proof),
+		Leaf:  proof.Leaf,
+		Index: 9,
+	}
+	assert.NoError(test.IsSolved(membershipCircuit, witness, ecc.BN254.ScalarField()))
+
+	witness.Index = 8
+	assert.Error(test.IsSolved(membershipCircuit, witness, ecc.BN254.ScalarField()))
+
+	// The index is bounded by the capacity of the tree
+	witness.Index = 9 + tree.Capacity()
+	assert.Error(test.IsSolved(membershipCircuit, witness, ecc.BN254.ScalarField()))
+
+	// Insertion into an empty leaf
+	oldRoot := tree.MerkleRoot()
+	proof, err = tree.ProveNonMembership(5)
+	assert.NoError(err)
+	_, err = tree.UpdateLeafAt(5, testLeaf{big.NewInt(105)})
+	assert.NoError(err)
+
+	insertion := &TestSparseMerkleCircuit{
+		Proof:   sparseProofValue(oldRoot, proof),
+		Leaf:    105,
+		Index:   5,
+		NewRoot: tree.MerkleRoot(),
+	}
+	assert.NoError(test.IsSolved(insertionCircuit, insertion, ecc.BN254.ScalarField()))
+
+	// Insertion into an occupied leaf
+	proof, err = tree.ProveMembership(2)
+	assert.NoError(err)
+	oldRoot = tree.MerkleRoot()
+	_, err = tree.UpdateLeafAt(2, testLeaf{big.NewInt(200)})
+	assert.NoError(err)
+
+	insertion = &TestSparseMerkleCircuit{
+		Proof:   sparseProofValue(oldRoot, proof),
+		Leaf:    200,
+		Index:   2,
+		NewRoot: tree.MerkleRoot(),
+	}
+	assert.Error(test.IsSolved(insertionCircuit, insertion, ecc.BN254.ScalarField()))
+}
+
+// TestSparseNodesCircuit checks the nodes on the path of a leaf.
+type TestSparseNodesCircuit struct {
+	Proof utils.SparseMerkleProof
+	Leaf  frontend.Variable
+	Index frontend.Variable
+	Nodes []frontend.Variable
+}
+
+func (circuit *TestSparseNodesCircuit) Define(api frontend.API) error {
+	hFunc, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+
+	for i, node := range circuit.Proof.Nodes(api, &hFunc, circuit.Leaf, circuit.Index) {
+		api.AssertIsEqual(node, circuit.Nodes[i])
+	}
+	api.AssertIsEqual(circuit.Nodes[len(circuit.Nodes)-1], circuit.Proof.RootHash)
+
+	return nil
+}
+
+func TestSparseMerkleNodes(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	depth := 3
+	tree, err := merkletree.NewSparseTree(depth)
+	assert.NoError(err)
+	indexes := []int{0, 3, 5, 6}
+	for _, i := range indexes {
+		_, err := tree.UpdateLeafAt(i, testLeaf{big.NewInt(int64(100 + i))})
+		assert.NoError(err)
+	}
+
+	circuit := &TestSparseNodesCircuit{
+		Proof: utils.SparseMerkleProof{Siblings: make([]frontend.Variable, depth)},
+		Nodes: make([]frontend.Variable, depth+1),
+	}
+	witnessAt := func(index int, proofIndex int) *TestSparseNodesCircuit {
+		proof, err := tree.ProveMembership(proofIndex)
+		assert.NoError(err)
+
+		witness := &TestSparseNodesCircuit{
+			Proof: sparseProofValue(tree.MerkleRoot(), proof),
+			Leaf:  proof.Leaf,
+			Index: index,
+		}
+		current := proof.Leaf
+		witness.Nodes = append(witness.Nodes, current)
+		for level, sibling := range proof.Siblings {
+			h := bn254.NewMiMC()
+			if (proofIndex>>level)%2 == 0 {
+				h.Write(append(append([]byte{}, current...), sibling...))
+			} else {
+				h.Write(append(append([]byte{}, sibling...), current...))
+			}
+			current = h.Sum(nil)
+			witness.Nodes = append(witness.Nodes, current)
+		}
+		return witness
+	}
+
+	for _, i := range indexes {
+		assert.NoError(test.IsSolved(circuit, witnessAt(i, i), ecc.BN254.ScalarField()), "index %d", i)
+	}
+
+	// The path of a leaf does not lead to the root from another index
+	assert.Error(test.IsSolved(circuit, witnessAt(5, 3), ecc.BN254.ScalarField()))
+}
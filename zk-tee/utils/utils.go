@@ -10,7 +10,13 @@ import (
 )
 
 const (
-	PaillierBits = 62
+	PaillierBits = 2048
+	BalanceBits  = 64
+
+	// LimbBits is the width of a single limb of a non-native integer in the
+	// circuits. Products of two limbs must fit comfortably in the BN254 scalar
+	// field, together with the carries of a limb-wise multiplication.
+	LimbBits = 64
 )
 
 func RandomBigInt(n int) *big.Int {
@@ -18,6 +24,40 @@ func RandomBigInt(n int) *big.Int {
 	return new(big.Int).Rand(rng, new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(n)), nil))
 }
 
+// NbLimbs returns the number of LimbBits-sized limbs needed to hold an
+// integer of the given bit size.
+func NbLimbs(bits int) int {
+	return (bits + LimbBits - 1) / LimbBits
+}
+
+// ToLimbs splits x into nbLimbs little-endian limbs of LimbBits bits. It
+// panics if x does not fit in nbLimbs limbs.
+func ToLimbs(x *big.Int, nbLimbs int) []*big.Int {
+	if x.Sign() < 0 || x.BitLen() > nbLimbs*LimbBits {
+		panic("utils: integer does not fit in the given number of limbs")
+	}
+
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), LimbBits), big.NewInt(1))
+	limbs := make([]*big.Int, nbLimbs)
+	tmp := new(big.Int).Set(x)
+	for i := 0; i < nbLimbs; i++ {
+		limbs[i] = new(big.Int).And(tmp, mask)
+		tmp.Rsh(tmp, LimbBits)
+	}
+
+	return limbs
+}
+
+// FromLimbs recomposes an integer from little-endian limbs of LimbBits bits.
+func FromLimbs(limbs []*big.Int) *big.Int {
+	res := new(big.Int)
+	for i := len(limbs) - 1; i >= 0; i-- {
+		res.Lsh(res, LimbBits)
+		res.Add(res, limbs[i])
+	}
+	return res
+}
+
 func HashInCircuit(h hash.FieldHasher, inputs ...frontend.Variable) frontend.Variable {
 	h.Reset()
 	for _, input := range inputs {
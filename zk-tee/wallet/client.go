@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/shreyas-londhe/private-erc20-circuits/db"
+)
+
+// Client reads the public state of the balances tree from the server.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client of the server at url, such as
+// http://localhost:8080.
+func NewClient(url string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Leaf returns the leaf of the account at index.
+func (c *Client) Leaf(index int) (db.BalanceLeaf, error) {
+	var resp struct {
+		KeyPair           db.PublicKeyResponse `json:"keyPair"`
+		SpendingKey       string               `json:"spendingKey"`
+		Nonce             string               `json:"nonce"`
+		EncBalance        string               `json:"encBalance"`
+		BalanceCommitment string               `json:"balanceCommitment"`
+	}
+	if err := c.get("/get-user", url.Values{"index": {strconv.Itoa(index)}}, &resp); err != nil {
+		return db.BalanceLeaf{}, err
+	}
+
+	var spendingKey eddsa.PublicKey
+	spendingKeyBytes, err := hex.DecodeString(resp.SpendingKey)
+	if err != nil {
+		return db.BalanceLeaf{}, err
+	}
+	if _, err := spendingKey.SetBytes(spendingKeyBytes); err != nil {
+		return db.BalanceLeaf{}, err
+	}
+	nonce, ok := new(big.Int).SetString(resp.Nonce, 10)
+	if !ok {
+		return db.BalanceLeaf{}, fmt.Errorf("invalid nonce %q", resp.Nonce)
+	}
+	encBalance, ok := new(big.Int).SetString(resp.EncBalance, 10)
+	if !ok {
+		return db.BalanceLeaf{}, fmt.Errorf("invalid encBalance %q", resp.EncBalance)
+	}
+	commitment, err := db.DecodePoint(resp.BalanceCommitment)
+	if err != nil {
+		return db.BalanceLeaf{}, fmt.Errorf("invalid balanceCommitment: %w", err)
+	}
+	pubKey, err := resp.KeyPair.PublicKey()
+	if err != nil {
+		return db.BalanceLeaf{}, fmt.Errorf("account %d: %w", index, err)
+	}
+
+	return db.BalanceLeaf{
+		PubKey:            db.PaillierPubKey{N: pubKey.N, G: pubKey.G},
+		EncBalance:        encBalance,
+		BalanceCommitment: commitment,
+		SpendingKey:       spendingKey,
+		Nonce:             nonce,
+	}, nil
+}
+
+// MerklePath returns the current balances root and the siblings of the leaf
+// at index, ordered from the leaves up.
+func (c *Client) MerklePath(index int) ([]byte, [][]byte, error) {
+	var resp struct {
+		Root     string   `json:"root"`
+		Siblings []string `json:"siblings"`
+	}
+	if err := c.get("/get-merkle-path", url.Values{"index": {strconv.Itoa(index)}}, &resp); err != nil {
+		return nil, nil, err
+	}
+
+	root, err := hex.DecodeString(resp.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	siblings := make([][]byte, len(resp.Siblings))
+	for i, sibling := range resp.Siblings {
+		if siblings[i], err = hex.DecodeString(sibling); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return root, siblings, nil
+}
+
+// SubmitTransfer submits a transfer proven by the wallet and returns its proof
+// data once the server has applied it.
+func (c *Client) SubmitTransfer(transfer db.ProvenTransfer) (db.Groth16ProofData, error) {
+	params := url.Values{
+		"fromIndex":         {strconv.Itoa(transfer.FromIndex)},
+		"toIndex":           {strconv.Itoa(transfer.ToIndex)},
+		"newFromEncBalance": {transfer.NewFromEncBalance.String()},
+		"newFromCommitment": {db.EncodePoint(transfer.NewFromCommitment)},
+		"amount":            {transfer.Amount.String()},
+		"amountBlinding":    {transfer.AmountBlinding.String()},
+		"encAmountR":        {transfer.EncAmountR.String()},
+		"proof":             {hex.EncodeToString(transfer.Proof)},
+	}
+	var proofData db.Groth16ProofData
+	if err := c.get("/submit-transfer", params, &proofData); err != nil {
+		return db.Groth16ProofData{}, err
+	}
+	return proofData, nil
+}
+
+// get sends a GET request to the endpoint and decodes its JSON response in v.
+func (c *Client) get(endpoint string, params url.Values, v interface{}) error {
+	resp, err := c.HTTPClient.Get(c.URL + endpoint + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", endpoint, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// merklePath is the path of a leaf of the balances tree: the hash of the leaf
+// and its siblings, ordered from the leaves up to the root.
+type merklePath struct {
+	index    int
+	leaf     []byte
+	siblings [][]byte
+}
+
+// root returns the root the path leads to.
+func (p merklePath) root() []byte {
+	return p.node(len(p.siblings))
+}
+
+// node returns the hash of the ancestor of the leaf at the given level, level
+// 0 being the leaf.
+func (p merklePath) node(level int) []byte {
+	current := p.leaf
+	for l := 0; l < level; l++ {
+		if (p.index>>l)%2 == 0 {
+			current = hashChildren(current, p.siblings[l])
+		} else {
+			current = hashChildren(p.siblings[l], current)
+		}
+	}
+	return current
+}
+
+// proof returns the assignment of the path in the circuit witness.
+func (p merklePath) proof() utils.SparseMerkleProof {
+	proof := utils.SparseMerkleProof{
+		RootHash: p.root(),
+		Siblings: make([]frontend.Variable, len(p.siblings)),
+	}
+	for i, sibling := range p.siblings {
+		proof.Siblings[i] = sibling
+	}
+	return proof
+}
+
+// updatePaths returns the paths of two distinct leaves of the same tree once
+// both are set to the new hashes. Their siblings only differ at the level
+// right below the node where both paths meet, where each path holds an
+// ancestor of the other leaf.
+func updatePaths(a merklePath, b merklePath, newLeafA []byte, newLeafB []byte) (merklePath, merklePath, error) {
+	if a.index == b.index || len(a.siblings) != len(b.siblings) {
+		return merklePath{}, merklePath{}, errors.New("paths are not of two distinct leaves")
+	}
+	if !bytes.Equal(a.root(), b.root()) {
+		return merklePath{}, merklePath{}, errors.New("paths are not of the same tree")
+	}
+
+	newA := merklePath{index: a.index, leaf: newLeafA, siblings: append([][]byte{}, a.siblings...)}
+	newB := merklePath{index: b.index, leaf: newLeafB, siblings: append([][]byte{}, b.siblings...)}
+
+	level := bits.Len(uint(a.index^b.index)) - 1
+	newA.siblings[level] = newB.node(level)
+	newB.siblings[level] = newA.node(level)
+
+	return newA, newB, nil
+}
+
+// hashChildren hashes two nodes of the balances tree, as
+// merkletree.NewSparseTree does.
+func hashChildren(left []byte, right []byte) []byte {
+	h := mimc.NewMiMC()
+	h.Write(append(append([]byte{}, left...), right...))
+	return h.Sum(nil)
+}
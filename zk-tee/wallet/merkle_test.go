@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shreyas-londhe/private-erc20-circuits/merkletree"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+type testLeaf int64
+
+func (l testLeaf) CalculateHash() ([]byte, error) {
+	return hashChildren(utils.Pad32Bytes([]byte{byte(l)}), make([]byte, 32)), nil
+}
+
+func (l testLeaf) Equals(other merkletree.Content) (bool, error) {
+	return other.(testLeaf) == l, nil
+}
+
+func pathAt(t *testing.T, tree *merkletree.SparseMerkleTree, index int) merklePath {
+	siblings, _, err := tree.GetMerklePathAt(index)
+	if err != nil {
+		t.Fatalf("Failed to get the path of leaf %d: %s", index, err)
+	}
+	leaf, _ := tree.Get(index).CalculateHash()
+	return merklePath{index: index, leaf: leaf, siblings: siblings}
+}
+
+// TestUpdatePaths checks the paths computed from the old ones against the
+// tree in which both leaves are updated.
+func TestUpdatePaths(t *testing.T) {
+	depth := 4
+	for _, indexes := range [][2]int{{0, 1}, {1, 0}, {2, 13}, {7, 8}, {5, 6}} {
+		tree, err := merkletree.NewSparseTree(depth)
+		if err != nil {
+			t.Fatalf("Failed to create Sparse Merkle Tree: %s", err)
+		}
+		for i := 0; i < 10; i++ {
+			tree.UpdateLeafAt(i, testLeaf(i))
+		}
+		tree.UpdateLeafAt(13, testLeaf(13))
+
+		a, b := indexes[0], indexes[1]
+		oldA, oldB := pathAt(t, tree, a), pathAt(t, tree, b)
+		if !bytes.Equal(oldA.root(), tree.MerkleRoot()) {
+			t.Fatalf("Path of leaf %d does not lead to the root", a)
+		}
+
+		newLeafA, _ := testLeaf(100 + a).CalculateHash()
+		newLeafB, _ := testLeaf(100 + b).CalculateHash()
+		newA, newB, err := updatePaths(oldA, oldB, newLeafA, newLeafB)
+		if err != nil {
+			t.Fatalf("Failed to update the paths of leaves %d and %d: %s", a, b, err)
+		}
+
+		tree.UpdateLeafAt(a, testLeaf(100+a))
+		tree.UpdateLeafAt(b, testLeaf(100+b))
+		for _, path := range []merklePath{newA, newB} {
+			wantPath := pathAt(t, tree, path.index)
+			if !bytes.Equal(path.leaf, wantPath.leaf) {
+				t.Errorf("Leaf %d does not match the tree", path.index)
+			}
+			for level := range wantPath.siblings {
+				if !bytes.Equal(path.siblings[level], wantPath.siblings[level]) {
+					t.Errorf("Sibling %d of leaf %d does not match the tree", level, path.index)
+				}
+			}
+			if !bytes.Equal(path.root(), tree.MerkleRoot()) {
+				t.Errorf("Path of leaf %d does not lead to the new root", path.index)
+			}
+		}
+	}
+
+	// Paths of different trees
+	tree, _ := merkletree.NewSparseTree(depth)
+	tree.UpdateLeafAt(0, testLeaf(0))
+	a := pathAt(t, tree, 0)
+	tree.UpdateLeafAt(1, testLeaf(1))
+	b := pathAt(t, tree, 1)
+	if _, _, err := updatePaths(a, b, a.leaf, b.leaf); err == nil {
+		t.Errorf("Paths of different trees should not be updated")
+	}
+}
@@ -0,0 +1,295 @@
+// Package wallet builds and proves transfers on the client side. The wallet
+// holds the keys of an account and only reads public state from the server,
+// so that neither the server nor the prover learns the balance of the sender.
+// The server learns the amount, with which it updates the recipient leaf.
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark-crypto/hash"
+	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
+	"github.com/shreyas-londhe/private-erc20-circuits/db"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+// Wallet holds the keys of the account at Index of the deployment Domain.
+type Wallet struct {
+	Domain      db.Domain
+	Index       int
+	KeyPair     *paillier.PrivateKey
+	SpendingKey *eddsa.PrivateKey
+
+	client *Client
+	prover *db.Prover
+}
+
+// New returns the wallet of the account at the given index of the domain.
+// Transfers are proven with the prover of the client custody transfer
+// circuit, see db.TransferCircuitName, since the sender does not know the
+// balance of the recipient.
+func New(client *Client, prover *db.Prover, domain db.Domain, index int, keyPair *paillier.PrivateKey, spendingKey *eddsa.PrivateKey) *Wallet {
+	return &Wallet{
+		Domain:      domain,
+		Index:       index,
+		KeyPair:     keyPair,
+		SpendingKey: spendingKey,
+		client:      client,
+		prover:      prover,
+	}
+}
+
+// transferState is the public state a transfer is built from.
+type transferState struct {
+	root     []byte
+	from     db.BalanceLeaf
+	to       db.BalanceLeaf
+	fromPath merklePath
+	toPath   merklePath
+}
+
+// Opening decrypts the balance of the leaf and the blinding of its balance
+// commitment.
+func (w *Wallet) Opening(leaf db.BalanceLeaf) (db.Opening, error) {
+	opening, err := db.DecryptOpening(w.KeyPair, leaf.EncBalance)
+	if err != nil {
+		return db.Opening{}, err
+	}
+	commitment := utils.Commit(opening.Balance, opening.Blinding)
+	if !commitment.Equal(&leaf.BalanceCommitment) {
+		return db.Opening{}, errors.New("encrypted balance does not open the balance commitment")
+	}
+	return opening, nil
+}
+
+// Balance returns the current balance of the account.
+func (w *Wallet) Balance() (*big.Int, error) {
+	leaf, err := w.client.Leaf(w.Index)
+	if err != nil {
+		return nil, err
+	}
+	opening, err := w.Opening(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return opening.Balance, nil
+}
+
+// Transfer proves a transfer of amount to the account at toIndex, applied to
+// the current state of the server, without submitting it, see SubmitTransfer.
+func (w *Wallet) Transfer(toIndex int, amount *big.Int) (db.Groth16ProofData, error) {
+	witness, pubInputs, err := w.TransferWitness(toIndex, amount)
+	if err != nil {
+		return db.Groth16ProofData{}, err
+	}
+
+	return w.prover.ProofData(&witness, pubInputs)
+}
+
+// SubmitTransfer proves a transfer of amount to the account at toIndex and
+// submits it to the server, which applies it once the proof verifies against
+// its current state. It returns the proof data to settle the transfer on the
+// contract.
+func (w *Wallet) SubmitTransfer(toIndex int, amount *big.Int) (db.Groth16ProofData, error) {
+	if toIndex == w.Index {
+		return db.Groth16ProofData{}, errors.New("invalid transfer indexes")
+	}
+
+	state, err := w.fetchState(toIndex)
+	if err != nil {
+		return db.Groth16ProofData{}, err
+	}
+	witness, _, transfer, err := w.transferWitness(state, amount)
+	if err != nil {
+		return db.Groth16ProofData{}, err
+	}
+	proof, err := w.prover.Prove(&witness)
+	if err != nil {
+		return db.Groth16ProofData{}, err
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return db.Groth16ProofData{}, err
+	}
+	transfer.Proof = buf.Bytes()
+
+	return w.client.SubmitTransfer(transfer)
+}
+
+// TransferWitness builds the witness and public inputs of a transfer of
+// amount to the account at toIndex, applied to the current state of the
+// server.
+func (w *Wallet) TransferWitness(toIndex int, amount *big.Int) (circuits.PrivateCoinCircuit, []*big.Int, error) {
+	if toIndex == w.Index {
+		return circuits.PrivateCoinCircuit{}, nil, errors.New("invalid transfer indexes")
+	}
+
+	state, err := w.fetchState(toIndex)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, err
+	}
+	witness, pubInputs, _, err := w.transferWitness(state, amount)
+	return witness, pubInputs, err
+}
+
+// fetchState reads the leaves and paths of the sender and the recipient from
+// the server, and checks that they all belong to the same tree.
+func (w *Wallet) fetchState(toIndex int) (transferState, error) {
+	state := transferState{
+		fromPath: merklePath{index: w.Index},
+		toPath:   merklePath{index: toIndex},
+	}
+
+	var err error
+	if state.from, err = w.client.Leaf(w.Index); err != nil {
+		return transferState{}, err
+	}
+	if state.to, err = w.client.Leaf(toIndex); err != nil {
+		return transferState{}, err
+	}
+	if state.root, state.fromPath.siblings, err = w.client.MerklePath(w.Index); err != nil {
+		return transferState{}, err
+	}
+	toRoot, toSiblings, err := w.client.MerklePath(toIndex)
+	if err != nil {
+		return transferState{}, err
+	}
+	state.toPath.siblings = toSiblings
+
+	if state.fromPath.leaf, err = state.from.CalculateHash(); err != nil {
+		return transferState{}, err
+	}
+	if state.toPath.leaf, err = state.to.CalculateHash(); err != nil {
+		return transferState{}, err
+	}
+
+	// A transition applied between the requests changes the root
+	if !bytes.Equal(toRoot, state.root) || !bytes.Equal(state.fromPath.root(), state.root) || !bytes.Equal(state.toPath.root(), state.root) {
+		return transferState{}, db.ErrStaleRoot
+	}
+
+	return state, nil
+}
+
+// transferWitness builds the witness of the transfer on top of the state,
+// along with the transfer to submit once proven.
+func (w *Wallet) transferWitness(state transferState, amount *big.Int) (circuits.PrivateCoinCircuit, []*big.Int, db.ProvenTransfer, error) {
+	if state.from.PubKey.N.Cmp(w.KeyPair.N) != 0 || !state.from.SpendingKey.Equal(&w.SpendingKey.PublicKey) {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, errors.New("account does not hold the keys of the wallet")
+	}
+
+	opening, err := w.Opening(state.from)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	if amount.Sign() < 0 || amount.Cmp(opening.Balance) > 0 {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, errors.New("amount exceeds the sender balance")
+	}
+
+	// Commit to the amount and sign the transfer
+	amountBlinding, err := utils.RandomBlinding()
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	msg, err := db.TransferMessage(w.Domain, state.root, state.to, utils.Commit(amount, amountBlinding), state.from.Nonce)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	sig, err := w.SpendingKey.Sign(msg, hash.MIMC_BN254.New())
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+
+	// Compute the new leaves and the root of the new tree. The balance left
+	// is committed to under a fresh blinding, and the amount is encrypted
+	// for the recipient as the server does, see db.ReceiveLeaf
+	newFromBlinding, err := utils.RandomBlinding()
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newFromBalance := new(big.Int).Sub(opening.Balance, amount)
+	newFrom := state.from
+	newFrom.EncBalance, err = db.EncryptBalance(&w.KeyPair.PublicKey, newFromBalance, newFromBlinding)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newFrom.BalanceCommitment = utils.Commit(newFromBalance, newFromBlinding)
+	newFrom.Nonce = new(big.Int).Add(state.from.Nonce, big.NewInt(1))
+
+	encAmountR, err := rand.Int(rand.Reader, state.to.PubKey.N)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newTo, err := db.ReceiveLeaf(state.to, amount, amountBlinding, encAmountR)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+
+	newFromHash, err := newFrom.CalculateHash()
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newToHash, err := newTo.CalculateHash()
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newFromPath, _, err := updatePaths(state.fromPath, state.toPath, newFromHash, newToHash)
+	if err != nil {
+		return circuits.PrivateCoinCircuit{}, nil, db.ProvenTransfer{}, err
+	}
+	newRoot := newFromPath.root()
+
+	var witness circuits.PrivateCoinCircuit
+	witness.ClientCustody = true
+	witness.Domain = w.Domain.CircuitValue()
+	witness.OldBalancesRoot = state.root
+	witness.NewBalancesRoot = newRoot
+
+	witness.OldFromLeaf = state.from.CircuitValue()
+	witness.FromIndex = state.fromPath.index
+	witness.OldFromLeafMP = state.fromPath.proof()
+	witness.OldFromBalance = opening.Balance
+	witness.OldFromBlinding = opening.Blinding
+
+	// The recipient balance is not opened in client custody
+	witness.OldToLeaf = state.to.CircuitValue()
+	witness.ToIndex = state.toPath.index
+	witness.OldToLeafMP = state.toPath.proof()
+	witness.OldToBalance = 0
+	witness.OldToBlinding = 0
+
+	witness.Amount = amount
+	witness.AmountBlinding = amountBlinding
+	witness.Signature.Assign(tedwards.BN254, sig)
+	witness.NewFromBlinding = newFromBlinding
+
+	witness.NewFromLeaf = newFrom.CircuitValue()
+	witness.NewToLeaf = newTo.CircuitValue()
+
+	leavesHash := db.TransferLeavesHash(state.from, state.to, newFrom, newTo)
+	witness.LeavesHash = leavesHash
+
+	// Public inputs, in the order in which the circuit declares them
+	pubInputs := append(w.Domain.Fields(),
+		new(big.Int).SetBytes(state.root),
+		new(big.Int).SetBytes(newRoot),
+		new(big.Int).SetBytes(leavesHash),
+	)
+	transfer := db.ProvenTransfer{
+		FromIndex:         w.Index,
+		ToIndex:           state.toPath.index,
+		NewFromEncBalance: newFrom.EncBalance,
+		NewFromCommitment: newFrom.BalanceCommitment,
+		Amount:            amount,
+		AmountBlinding:    amountBlinding,
+		EncAmountR:        encAmountR,
+	}
+
+	return witness, pubInputs, transfer, nil
+}
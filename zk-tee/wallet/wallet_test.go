@@ -0,0 +1,245 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards/eddsa"
+	"github.com/consensys/gnark/test"
+	"github.com/shreyas-londhe/private-erc20-circuits/circuits"
+	"github.com/shreyas-londhe/private-erc20-circuits/db"
+	"github.com/shreyas-londhe/private-erc20-circuits/paillier"
+	"github.com/shreyas-londhe/private-erc20-circuits/utils"
+)
+
+const testDepth = 3
+
+var testDomain = db.Domain{ChainID: big.NewInt(31337), Contract: big.NewInt(0xc0ffee)}
+
+// testServer serves the endpoints the wallet reads from a DB in client
+// custody. Submitted transfers are verified by verify.
+type testServer struct {
+	*httptest.Server
+	database *db.DB
+	verify   db.VerifyFunc
+}
+
+func newTestServer(t *testing.T) (*testServer, []*Wallet) {
+	t.Helper()
+	database, err := db.New(testDepth, nil)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	database.Domain = testDomain
+
+	// Small keys keep the test fast, the circuit takes any key up to
+	// utils.PaillierBits that fits a balance and its blinding, see
+	// utils.BalancePlaintext
+	var users []db.UserData
+	for i := 0; i < 3; i++ {
+		keyPair, err := paillier.GenerateKey(rand.Reader, 512)
+		if err != nil {
+			t.Fatalf("Failed to generate Paillier key: %v", err)
+		}
+		spendingKey, err := eddsa.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate spending key: %v", err)
+		}
+		blinding, err := utils.RandomBlinding()
+		if err != nil {
+			t.Fatalf("Failed to draw blinding: %v", err)
+		}
+		encBalance, err := db.EncryptBalance(&keyPair.PublicKey, big.NewInt(100), blinding)
+		if err != nil {
+			t.Fatalf("Failed to encrypt balance: %v", err)
+		}
+		users = append(users, db.UserData{
+			KeyPair:           keyPair,
+			SpendingKey:       spendingKey,
+			Nonce:             big.NewInt(0),
+			EncBalance:        encBalance,
+			BalanceCommitment: utils.Commit(big.NewInt(100), blinding),
+		})
+	}
+	if err := database.AddUsers(users); err != nil {
+		t.Fatalf("Failed to add users: %v", err)
+	}
+	database.ClientCustody = true
+
+	s := &testServer{database: database}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get-user", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		user := database.GetUser(index)
+		json.NewEncoder(w).Encode(struct {
+			KeyPair           db.PublicKeyResponse `json:"keyPair"`
+			SpendingKey       string               `json:"spendingKey"`
+			Nonce             string               `json:"nonce"`
+			EncBalance        string               `json:"encBalance"`
+			BalanceCommitment string               `json:"balanceCommitment"`
+		}{
+			KeyPair:           db.NewPublicKeyResponse(&user.KeyPair.PublicKey),
+			SpendingKey:       hex.EncodeToString(user.SpendingKey.PublicKey.Bytes()),
+			Nonce:             user.Nonce.String(),
+			EncBalance:        user.EncBalance.String(),
+			BalanceCommitment: db.EncodePoint(user.BalanceCommitment),
+		})
+	})
+	mux.HandleFunc("/get-merkle-path", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		root, siblings, err := database.GetMerklePath(index)
+		if err != nil {
+			http.Error(w, "Invalid index", http.StatusBadRequest)
+			return
+		}
+		resp := struct {
+			Root     string   `json:"root"`
+			Siblings []string `json:"siblings"`
+		}{Root: hex.EncodeToString(root)}
+		for _, sibling := range siblings {
+			resp.Siblings = append(resp.Siblings, hex.EncodeToString(sibling))
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/submit-transfer", func(w http.ResponseWriter, r *http.Request) {
+		fromIndex, _ := strconv.Atoi(r.URL.Query().Get("fromIndex"))
+		toIndex, _ := strconv.Atoi(r.URL.Query().Get("toIndex"))
+		newFromEncBalance, _ := new(big.Int).SetString(r.URL.Query().Get("newFromEncBalance"), 10)
+		newFromCommitment, _ := db.DecodePoint(r.URL.Query().Get("newFromCommitment"))
+		amount, _ := new(big.Int).SetString(r.URL.Query().Get("amount"), 10)
+		amountBlinding, _ := new(big.Int).SetString(r.URL.Query().Get("amountBlinding"), 10)
+		encAmountR, _ := new(big.Int).SetString(r.URL.Query().Get("encAmountR"), 10)
+		proof, _ := hex.DecodeString(r.URL.Query().Get("proof"))
+		proofData, err := database.ApplyProvenTransfer(db.ProvenTransfer{
+			FromIndex:         fromIndex,
+			ToIndex:           toIndex,
+			NewFromEncBalance: newFromEncBalance,
+			NewFromCommitment: newFromCommitment,
+			Amount:            amount,
+			AmountBlinding:    amountBlinding,
+			EncAmountR:        encAmountR,
+			Proof:             proof,
+		}, s.verify)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(proofData)
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	var wallets []*Wallet
+	for _, user := range users {
+		wallets = append(wallets, New(NewClient(s.URL), nil, testDomain, len(wallets), user.KeyPair, user.SpendingKey))
+	}
+	return s, wallets
+}
+
+// verifyInputs returns a VerifyFunc accepting the proof if the server derives
+// the given public inputs, as the verifier of the circuit would.
+func verifyInputs(want []*big.Int) db.VerifyFunc {
+	return func(proof []byte, pInputs []*big.Int) (db.Groth16ProofData, error) {
+		if len(pInputs) != len(want) {
+			return db.Groth16ProofData{}, errors.New("wrong number of public inputs")
+		}
+		for i := range pInputs {
+			if pInputs[i].Cmp(want[i]) != 0 {
+				return db.Groth16ProofData{}, fmt.Errorf("public input %d does not match the proof", i)
+			}
+		}
+		return db.Groth16ProofData{Proof: []string{hex.EncodeToString(proof)}}, nil
+	}
+}
+
+func balance(t *testing.T, w *Wallet) *big.Int {
+	t.Helper()
+	b, err := w.Balance()
+	if err != nil {
+		t.Fatalf("Failed to read balance of account %d: %v", w.Index, err)
+	}
+	return b
+}
+
+// TestTransferWitness checks the witness built by the wallet against the
+// client custody transfer circuit at the size the server proves it.
+func TestTransferWitness(t *testing.T) {
+	_, wallets := newTestServer(t)
+	witness, _, err := wallets[0].TransferWitness(2, big.NewInt(30))
+	if err != nil {
+		t.Fatalf("Failed to build transfer witness: %v", err)
+	}
+
+	circuit := circuits.NewPrivateCoinCircuit(testDepth, utils.PaillierBits)
+	circuit.ClientCustody = true
+	if err := test.IsSolved(&circuit, &witness, ecc.BN254.ScalarField()); err != nil {
+		t.Errorf("Witness does not solve the circuit: %v", err)
+	}
+}
+
+// TestSubmitTransfer checks that the server derives the public inputs of the
+// wallet from its own state, and applies the new leaves of the transfer.
+func TestSubmitTransfer(t *testing.T) {
+	server, wallets := newTestServer(t)
+	sender, recipient := wallets[0], wallets[1]
+
+	state, err := sender.fetchState(recipient.Index)
+	if err != nil {
+		t.Fatalf("Failed to fetch state: %v", err)
+	}
+	witness, pubInputs, transfer, err := sender.transferWitness(state, big.NewInt(30))
+	if err != nil {
+		t.Fatalf("Failed to build transfer witness: %v", err)
+	}
+	transfer.Proof = []byte("proof")
+	server.verify = verifyInputs(pubInputs)
+
+	// New leaves other than the proven ones are rejected
+	tampered := transfer
+	tampered.Amount = big.NewInt(31)
+	if _, err := sender.client.SubmitTransfer(tampered); err == nil {
+		t.Fatalf("Submitting leaves that were not proven should fail")
+	}
+	if !bytes.Equal(server.database.GetMerkleRoot(), state.root) {
+		t.Fatalf("Rejected transfer changed the root")
+	}
+
+	proofData, err := sender.client.SubmitTransfer(transfer)
+	if err != nil {
+		t.Fatalf("Failed to submit transfer: %v", err)
+	}
+	if len(proofData.Proof) != 1 || proofData.Proof[0] != hex.EncodeToString(transfer.Proof) {
+		t.Errorf("Proof was not passed to the verifier")
+	}
+	if !bytes.Equal(server.database.GetMerkleRoot(), witness.NewBalancesRoot.([]byte)) {
+		t.Errorf("Server root does not match the root of the witness")
+	}
+	if got := balance(t, sender); got.Cmp(big.NewInt(70)) != 0 {
+		t.Errorf("Sender balance is %s, want 70", got)
+	}
+	if got := balance(t, recipient); got.Cmp(big.NewInt(130)) != 0 {
+		t.Errorf("Recipient balance is %s, want 130", got)
+	}
+	if got := server.database.GetUser(sender.Index).Nonce; got.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("Sender nonce is %s, want 1", got)
+	}
+
+	// The transfer was proven against the old root, so it cannot be replayed
+	root := server.database.GetMerkleRoot()
+	if _, err := sender.client.SubmitTransfer(transfer); err == nil {
+		t.Errorf("Replaying a transfer should fail")
+	}
+	if !bytes.Equal(server.database.GetMerkleRoot(), root) {
+		t.Errorf("Replayed transfer changed the root")
+	}
+}